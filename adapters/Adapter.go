@@ -0,0 +1,41 @@
+package adapters
+
+import (
+	"guru/models"
+	"net/http"
+)
+
+// Adapter translates the seamless-wallet protocol of a game provider to the wallet calls and back.
+// Its routes are served under /provider/{Provider()}.
+type Adapter interface {
+	// Provider names the provider; transactions booked through the adapter carry it.
+	Provider() string
+	// Path returns the route of an action of models.ProviderActions below the provider prefix.
+	Path(action string) string
+	// Decode reads the call of an action from the provider request.
+	Decode(action string, req *http.Request) (*models.ProviderRequestModel, error)
+	// Encode writes the wallet state after a call in the provider format.
+	Encode(w http.ResponseWriter, call *models.ProviderRequestModel, response *models.ProviderResponseModel)
+	// EncodeError writes the failure of a call with the provider's error code. The call holds only the action
+	// when the request could not be decoded.
+	EncodeError(w http.ResponseWriter, call *models.ProviderRequestModel, err error)
+}
+
+// All returns the adapters of every supported provider.
+func All() []Adapter {
+	return []Adapter{NewGenericAdapter("generic")}
+}
+
+// RequestError wraps a provider request that could not be decoded or failed validation, so that adapters
+// can tell it from a wallet failure.
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,247 @@
+package adapters_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"guru/adapters"
+	"guru/handlers"
+	"guru/models"
+	"guru/repositories"
+	"guru/services"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// contractClient speaks the protocol of an adapter from the provider side.
+type contractClient struct {
+	adapter adapters.Adapter
+	// body encodes a call as the provider sends it.
+	body func(call models.ProviderRequestModel) []byte
+	// result decodes the provider response into the reported balance in major units or the error code.
+	result func(body []byte) (balance string, code string, err error)
+	// codes maps the wallet errors exercised by the contract, and "invalid request", to the provider codes.
+	codes map[string]string
+}
+
+// contractClients holds a client for every adapter of adapters.All.
+var contractClients = map[string]func(adapter adapters.Adapter) contractClient{
+	"generic": func(adapter adapters.Adapter) contractClient {
+		return contractClient{
+			adapter: adapter,
+			body: func(call models.ProviderRequestModel) []byte {
+				request := map[string]interface{}{
+					"player_id":                call.UserId,
+					"session_token":            call.Token,
+					"currency":                 call.Currency,
+					"transaction_id":           call.TransactionId,
+					"reference_transaction_id": call.OriginalTransactionId,
+					"round_id":                 call.RoundId,
+					"game_id":                  call.GameId,
+					"round_finished":           call.CloseRound,
+				}
+				if call.Amount != 0 {
+					request["amount"] = call.Amount.FormatCurrency(call.Currency)
+				}
+				body, _ := json.Marshal(request)
+				return body
+			},
+			result: func(body []byte) (string, string, error) {
+				var response struct {
+					Status  string `json:"status"`
+					Balance string `json:"balance"`
+					Code    string `json:"code"`
+				}
+				err := json.Unmarshal(body, &response)
+				return response.Balance, response.Code, err
+			},
+			codes: map[string]string{
+				"invalid request":                "INVALID_REQUEST",
+				"not found":                      "PLAYER_NOT_FOUND",
+				"wrong token":                    "INVALID_TOKEN",
+				"wallet not found":               "INVALID_CURRENCY",
+				"not enough balance":             "INSUFFICIENT_FUNDS",
+				"conflict":                       "DUPLICATE_TRANSACTION",
+				"original transaction not found": "TRANSACTION_NOT_FOUND",
+				"already rolled back":            "TRANSACTION_ROLLED_BACK",
+				"account suspended":              "PLAYER_BLOCKED",
+			},
+		}
+	},
+}
+
+// contractResult is the balance or the error code reported by the provider response.
+type contractResult struct {
+	Balance string
+	Code    string
+}
+
+func (c contractClient) call(t *testing.T, url string, call models.ProviderRequestModel) contractResult {
+	t.Helper()
+
+	res, err := http.Post(url+c.adapter.Path(call.Action), "application/json", bytes.NewBuffer(c.body(call)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, code, err := c.result(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return contractResult{Balance: balance, Code: code}
+}
+
+func newContractServer(t *testing.T, adapter adapters.Adapter) (*services.UserService, *httptest.Server) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := &services.UserService{
+		UserRepository: repositories.NewMemoryUserRepository(
+			models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 5000}, Token: "token", Status: models.StatusActive},
+			models.UserModel{Id: 2, Wallets: models.Wallets{"EUR": 5000}, Token: "token", Status: models.StatusSuspended},
+		),
+		DepositRepository:     repositories.NewMemoryDepositRepository(),
+		TransactionRepository: repositories.NewMemoryTransactionRepository(),
+		WithdrawalRepository:  repositories.NewMemoryWithdrawalRepository(),
+		BonusRepository:       repositories.NewMemoryBonusRepository(),
+		LimitRepository:       repositories.NewMemoryLimitRepository(),
+		RoundRepository:       repositories.NewMemoryRoundRepository(),
+		AdjustmentRepository:  repositories.NewMemoryAdjustmentRepository(),
+		AuditRepository:       repositories.NewMemoryAuditRepository(),
+		Journal:               repositories.NewMemoryJournalRepository(),
+		Ticker:                time.NewTicker(time.Hour),
+		Clock:                 func() time.Time { return now },
+	}
+	if err := service.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := handlers.NewProviderHandler(service, adapter)
+	r := mux.NewRouter()
+	p := r.PathPrefix("/provider/" + adapter.Provider()).Subrouter()
+	for _, action := range models.ProviderActions {
+		p.HandleFunc(adapter.Path(action), handler.Handle(action)).Methods(http.MethodPost)
+	}
+
+	return service, httptest.NewServer(r)
+}
+
+func TestAdapters_Contract(t *testing.T) {
+	for _, adapter := range adapters.All() {
+		newClient, ok := contractClients[adapter.Provider()]
+		if !ok {
+			t.Errorf("no contract client for provider %s", adapter.Provider())
+			continue
+		}
+
+		t.Run(adapter.Provider(), func(t *testing.T) {
+			client := newClient(adapter)
+			service, srv := newContractServer(t, adapter)
+			defer srv.Close()
+			url := fmt.Sprintf("%s/provider/%s", srv.URL, adapter.Provider())
+
+			call := func(action string, transactionId string, amount models.Money) contractResult {
+				return client.call(t, url, models.ProviderRequestModel{
+					Action:        action,
+					UserId:        1,
+					Token:         "token",
+					Currency:      "EUR",
+					TransactionId: transactionId,
+					Amount:        amount,
+					RoundId:       "r1",
+					GameId:        "slots",
+				})
+			}
+			assertBalance := func(expected string, result contractResult) {
+				t.Helper()
+				assert.Equal(t, contractResult{Balance: expected}, result)
+			}
+			assertCode := func(err string, result contractResult) {
+				t.Helper()
+				assert.NotEmpty(t, client.codes[err], err)
+				assert.Equal(t, client.codes[err], result.Code, err)
+			}
+
+			assertBalance("50.00", call(models.ProviderAuthenticate, "", 0))
+			assertBalance("50.00", call(models.ProviderBalance, "", 0))
+			assertBalance("40.00", call(models.ProviderDebit, "tx-1", 1000))
+			// Retries of a booked call report the balance after it.
+			assertBalance("40.00", call(models.ProviderDebit, "tx-1", 1000))
+			assertBalance("45.00", call(models.ProviderCredit, "tx-2", 500))
+			assertBalance("45.00", call(models.ProviderCredit, "tx-3", 0))
+
+			transaction, err := service.TransactionRepository.FindByExternalId(adapter.Provider(), "tx-1")
+			assert.Nil(t, err)
+			assert.Equal(t, adapter.Provider(), transaction.Provider)
+			assert.Equal(t, "r1", transaction.RoundId)
+			assert.Equal(t, "slots", transaction.GameId)
+			assert.Equal(t, "tx-1", transaction.ExternalId)
+
+			rollback := models.ProviderRequestModel{
+				Action:                models.ProviderRollback,
+				UserId:                1,
+				Token:                 "token",
+				Currency:              "EUR",
+				TransactionId:         "tx-4",
+				OriginalTransactionId: "tx-1",
+			}
+			assertBalance("55.00", client.call(t, url, rollback))
+			rollback.TransactionId = "tx-5"
+			assertCode("already rolled back", client.call(t, url, rollback))
+			rollback.OriginalTransactionId = "tx-99"
+			assertCode("original transaction not found", client.call(t, url, rollback))
+
+			assertCode("conflict", call(models.ProviderDebit, "tx-1", 2000))
+			assertCode("not enough balance", call(models.ProviderDebit, "tx-6", 10000))
+			assertCode("invalid request", call(models.ProviderDebit, "", 1000))
+
+			assertCode("wrong token", client.call(t, url, models.ProviderRequestModel{Action: models.ProviderBalance, UserId: 1, Token: "wrong", Currency: "EUR"}))
+			assertCode("not found", client.call(t, url, models.ProviderRequestModel{Action: models.ProviderBalance, UserId: 9, Token: "token", Currency: "EUR"}))
+			assertCode("wallet not found", client.call(t, url, models.ProviderRequestModel{Action: models.ProviderBalance, UserId: 1, Token: "token", Currency: "JPY"}))
+
+			// A suspended player cannot start a session or bet but still reads the balance.
+			suspended := models.ProviderRequestModel{Action: models.ProviderAuthenticate, UserId: 2, Token: "token", Currency: "EUR"}
+			assertCode("account suspended", client.call(t, url, suspended))
+			suspended.Action = models.ProviderBalance
+			assertBalance("50.00", client.call(t, url, suspended))
+			suspended.Action, suspended.TransactionId, suspended.Amount = models.ProviderDebit, "tx-7", 1000
+			assertCode("account suspended", client.call(t, url, suspended))
+		})
+	}
+}
+
+func TestGenericAdapter_DecodeIds(t *testing.T) {
+	adapter := adapters.NewGenericAdapter("generic")
+	for _, test := range []struct {
+		body        string
+		id          string
+		referenceId string
+		invalid     bool
+	}{
+		{`{"transaction_id": "a-1", "reference_transaction_id": "a-0"}`, "a-1", "a-0", false},
+		{`{"transaction_id": 17, "reference_transaction_id": 0}`, "17", "", false},
+		{`{"transaction_id": 1.5}`, "", "", true},
+		{`{"transaction_id": -1}`, "", "", true},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/debit", bytes.NewBufferString(test.body))
+		call, err := adapter.Decode(models.ProviderDebit, req)
+		if test.invalid {
+			assert.NotNil(t, err, test.body)
+			continue
+		}
+		if assert.Nil(t, err, test.body) {
+			assert.Equal(t, test.id, call.TransactionId, test.body)
+			assert.Equal(t, test.referenceId, call.OriginalTransactionId, test.body)
+		}
+	}
+}
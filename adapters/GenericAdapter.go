@@ -0,0 +1,171 @@
+package adapters
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"guru/models"
+	"guru/services"
+	"net/http"
+	"strconv"
+)
+
+// genericError is a code of the generic protocol and the HTTP status it is sent with.
+type genericError struct {
+	Code   string
+	Status int
+}
+
+// genericErrors maps the codes of wallet errors to the codes of the generic protocol; any other error is INTERNAL_ERROR.
+var genericErrors = map[string]genericError{
+	services.ErrNotFound.Code:                      {"PLAYER_NOT_FOUND", http.StatusNotFound},
+	services.ErrWrongToken.Code:                    {"INVALID_TOKEN", http.StatusUnauthorized},
+	services.ErrWalletNotFound.Code:                {"INVALID_CURRENCY", http.StatusBadRequest},
+	services.ErrUnknownCurrency.Code:               {"INVALID_CURRENCY", http.StatusBadRequest},
+	services.ErrNotEnoughBalance.Code:              {"INSUFFICIENT_FUNDS", http.StatusPaymentRequired},
+	services.ErrLossLimitExceeded.Code:             {"LIMIT_EXCEEDED", http.StatusForbidden},
+	services.ErrAccountSuspended.Code:              {"PLAYER_BLOCKED", http.StatusForbidden},
+	services.ErrAccountSelfExcluded.Code:           {"PLAYER_BLOCKED", http.StatusForbidden},
+	services.ErrAccountClosed.Code:                 {"PLAYER_BLOCKED", http.StatusForbidden},
+	services.ErrConflict.Code:                      {"DUPLICATE_TRANSACTION", http.StatusConflict},
+	services.ErrOriginalTransactionIdRequired.Code: {"INVALID_REQUEST", http.StatusBadRequest},
+	services.ErrOriginalTransactionNotFound.Code:   {"TRANSACTION_NOT_FOUND", http.StatusNotFound},
+	services.ErrTransactionNotRollbackable.Code:    {"INVALID_REQUEST", http.StatusBadRequest},
+	services.ErrRollbackCurrencyMismatch.Code:      {"INVALID_REQUEST", http.StatusBadRequest},
+	services.ErrRollbackAmountMismatch.Code:        {"INVALID_REQUEST", http.StatusBadRequest},
+	services.ErrAlreadyRolledBack.Code:             {"TRANSACTION_ROLLED_BACK", http.StatusConflict},
+	services.ErrRoundNotFound.Code:                 {"ROUND_NOT_FOUND", http.StatusNotFound},
+	services.ErrRoundClosed.Code:                   {"ROUND_CLOSED", http.StatusConflict},
+	services.ErrRoundMismatch.Code:                 {"ROUND_MISMATCH", http.StatusConflict},
+}
+
+// GenericAdapter serves the reference seamless-wallet protocol: one JSON POST route per action, amounts as
+// decimal strings in major units, and {"status": "ERROR", "code": ...} bodies for failures.
+type GenericAdapter struct {
+	provider string
+}
+
+func NewGenericAdapter(provider string) *GenericAdapter {
+	return &GenericAdapter{provider: provider}
+}
+
+type genericRequest struct {
+	PlayerId               uint64    `json:"player_id"`
+	SessionToken           string    `json:"session_token"`
+	Currency               string    `json:"currency"`
+	TransactionId          genericId `json:"transaction_id"`
+	ReferenceTransactionId genericId `json:"reference_transaction_id"`
+	Amount                 string    `json:"amount"`
+	RoundId                string    `json:"round_id"`
+	GameId                 string    `json:"game_id"`
+	RoundFinished          bool      `json:"round_finished"`
+}
+
+// genericId is a transaction id of the provider, sent as a string or an integer; zero is no id.
+type genericId string
+
+func (id *genericId) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*id = genericId(value)
+		return nil
+	}
+	if string(data) == "null" {
+		return nil
+	}
+
+	number, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return errors.New("invalid transaction id")
+	}
+	if number != 0 {
+		*id = genericId(data)
+	}
+
+	return nil
+}
+
+type genericResponse struct {
+	Status   string `json:"status"`
+	PlayerId uint64 `json:"player_id,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Balance  string `json:"balance,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+func (g *GenericAdapter) Provider() string {
+	return g.provider
+}
+
+func (g *GenericAdapter) Path(action string) string {
+	return "/" + action
+}
+
+func (g *GenericAdapter) Decode(action string, req *http.Request) (*models.ProviderRequestModel, error) {
+	var request genericRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		return nil, err
+	}
+
+	call := &models.ProviderRequestModel{
+		Action:                action,
+		UserId:                request.PlayerId,
+		Token:                 request.SessionToken,
+		Currency:              request.Currency,
+		TransactionId:         string(request.TransactionId),
+		OriginalTransactionId: string(request.ReferenceTransactionId),
+		RoundId:               request.RoundId,
+		GameId:                request.GameId,
+		CloseRound:            request.RoundFinished,
+	}
+	if request.Amount != "" {
+		exponent, err := models.CurrencyExponent(request.Currency)
+		if err != nil {
+			return nil, err
+		}
+		if call.Amount, err = models.ParseMoney(request.Amount, exponent); err != nil {
+			return nil, err
+		}
+	}
+
+	return call, nil
+}
+
+func (g *GenericAdapter) Encode(w http.ResponseWriter, call *models.ProviderRequestModel, response *models.ProviderResponseModel) {
+	g.write(w, http.StatusOK, genericResponse{
+		Status:   "OK",
+		PlayerId: response.UserId,
+		Currency: response.Currency,
+		Balance:  response.Balance.FormatCurrency(response.Currency),
+	})
+}
+
+func (g *GenericAdapter) EncodeError(w http.ResponseWriter, call *models.ProviderRequestModel, err error) {
+	domainError := services.AsError(err)
+	message := domainError.Message
+	code, ok := genericErrors[domainError.Code]
+	var requestError *RequestError
+	switch {
+	case ok:
+	case errors.As(err, &requestError):
+		code = genericError{"INVALID_REQUEST", http.StatusBadRequest}
+		message = err.Error()
+	default:
+		code = genericError{"INTERNAL_ERROR", http.StatusInternalServerError}
+	}
+
+	g.write(w, code.Status, genericResponse{Status: "ERROR", Code: code.Code, Message: message})
+}
+
+func (g *GenericAdapter) write(w http.ResponseWriter, status int, response genericResponse) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		zap.L().Error(err.Error())
+	}
+}
@@ -4,10 +4,13 @@ go 1.14
 
 require (
 	github.com/go-playground/validator/v10 v10.3.0
+	github.com/golang/protobuf v1.4.3
 	github.com/gorilla/mux v1.7.4
 	github.com/imdario/mergo v0.3.9
 	github.com/joho/godotenv v1.3.0
-	github.com/stretchr/testify v1.4.0
+	github.com/stretchr/testify v1.5.1
 	go.mongodb.org/mongo-driver v1.3.5
 	go.uber.org/zap v1.15.0
+	google.golang.org/grpc v1.34.0
+	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+	"guru/models"
+	"guru/services"
+	"net/http"
+)
+
+type AdminHandler struct {
+	service   *services.UserService
+	validator *validator.Validate
+	keys      map[string]models.AdminKeyModel
+}
+
+// adminKeyContext is the context key of the admin key authenticated by Middleware.
+type adminKeyContext struct{}
+
+// NewAdminHandler creates the handler of operator endpoints, authenticated by one of keys in the
+// X-Api-Key header; each key grants the role of its operator. The endpoints are disabled when keys is empty.
+func NewAdminHandler(service *services.UserService, keys map[string]models.AdminKeyModel) *AdminHandler {
+	return &AdminHandler{
+		service:   service,
+		validator: validator.New(),
+		keys:      keys,
+	}
+}
+
+// Middleware authenticates the admin key of every call and records its operator in the audit entry.
+func (h *AdminHandler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key, ok := h.authenticate(req.Header.Get(apiKeyHeader))
+		if !ok {
+			w.Header().Add("Content-Type", "application/json")
+			writeError(w, services.ErrUnauthorized)
+			return
+		}
+
+		recordActor(w, key.Name, key.Role)
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), adminKeyContext{}, key)))
+	})
+}
+
+// authenticate finds the key among the admin keys, comparing with every one in constant time.
+func (h *AdminHandler) authenticate(apiKey string) (models.AdminKeyModel, bool) {
+	var found models.AdminKeyModel
+	ok := false
+	for key, adminKey := range h.keys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(key)) == 1 {
+			found = adminKey
+			ok = true
+		}
+	}
+
+	return found, ok && apiKey != ""
+}
+
+// allow checks that the key authenticated by Middleware grants at least role, and writes the error otherwise.
+func (h *AdminHandler) allow(w http.ResponseWriter, req *http.Request, role string) bool {
+	key, ok := req.Context().Value(adminKeyContext{}).(models.AdminKeyModel)
+	if !ok {
+		writeError(w, services.ErrUnauthorized)
+		return false
+	}
+	if !key.Allows(role) {
+		writeError(w, services.ErrForbidden)
+		return false
+	}
+
+	return true
+}
+
+// actor returns the name of the operator behind the key authenticated by Middleware.
+func actor(req *http.Request) string {
+	key, _ := req.Context().Value(adminKeyContext{}).(models.AdminKeyModel)
+	return key.Name
+}
+
+func (h *AdminHandler) Reconcile(w http.ResponseWriter, req *http.Request) {
+	var reconcileRequest models.ReconcileRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleFinance) {
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &reconcileRequest) {
+		return
+	}
+
+	report, err := h.service.Reconcile(reconcileRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *AdminHandler) GrantBonus(w http.ResponseWriter, req *http.Request) {
+	var grantRequest models.GrantBonusRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleFinance) {
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &grantRequest) {
+		return
+	}
+
+	bonusResponse, err := h.service.GrantBonus(grantRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(bonusResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *AdminHandler) ChangeStatus(w http.ResponseWriter, req *http.Request) {
+	var statusRequest models.ChangeStatusRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleSuperuser) {
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &statusRequest) {
+		return
+	}
+
+	statusResponse, err := h.service.ChangeStatus(statusRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(statusResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *AdminHandler) SearchUsers(w http.ResponseWriter, req *http.Request) {
+	var searchRequest models.SearchUsersRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleSupport) {
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &searchRequest) {
+		return
+	}
+
+	searchResponse, err := h.service.SearchUsers(searchRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(searchResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *AdminHandler) GetUser(w http.ResponseWriter, req *http.Request) {
+	var userRequest models.AdminUserRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleSupport) {
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &userRequest) {
+		return
+	}
+
+	userResponse, err := h.service.AdminGetUser(userRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(userResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *AdminHandler) History(w http.ResponseWriter, req *http.Request) {
+	var historyRequest models.AdminHistoryRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleSupport) {
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &historyRequest) {
+		return
+	}
+
+	historyResponse, err := h.service.AdminHistory(historyRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(historyResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *AdminHandler) AdjustBalance(w http.ResponseWriter, req *http.Request) {
+	var adjustRequest models.AdjustBalanceRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleFinance) {
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &adjustRequest) {
+		return
+	}
+
+	adjustment, err := h.service.AdjustBalance(adjustRequest, actor(req))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(adjustment); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *AdminHandler) Freeze(w http.ResponseWriter, req *http.Request) {
+	var freezeRequest models.FreezeRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleFinance) {
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &freezeRequest) {
+		return
+	}
+
+	statusResponse, err := h.service.Freeze(freezeRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(statusResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *AdminHandler) SearchAudit(w http.ResponseWriter, req *http.Request) {
+	var searchRequest models.SearchAuditRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleFinance) {
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &searchRequest) {
+		return
+	}
+
+	searchResponse, err := h.service.SearchAudit(searchRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(searchResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *AdminHandler) VerifyAudit(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	if !h.allow(w, req, models.RoleFinance) {
+		return
+	}
+
+	verification, err := h.service.VerifyAudit()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(verification); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
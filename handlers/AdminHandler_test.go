@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"guru/models"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestAdminHandler_Reconcile(t *testing.T) {
+	jsonStr := []byte(`{
+		"repair": false
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin/reconcile", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", adminApiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report models.ReconciliationReportModel
+	if err = json.Unmarshal(resBytes, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, models.ReconciliationReportModel{
+		CheckedUsers: 2,
+		Discrepancies: []models.DiscrepancyModel{
+			{UserId: 1, Kind: models.DiscrepancyChainBreak, Currency: "EUR", Source: models.HistorySourceDeposit, EntryId: 3, Expected: 10000, Actual: 0},
+			{UserId: 1, Kind: models.DiscrepancyChainBreak, Currency: "EUR", Source: models.HistorySourceTransaction, EntryId: 1, Expected: 20000, Actual: 10000},
+			{UserId: 1, Kind: models.DiscrepancyBalanceMismatch, Currency: "EUR", Expected: 15000, Actual: 5000},
+		},
+	}, report)
+}
+
+func TestAdminHandler_ReconcileUnauthorized(t *testing.T) {
+	jsonStr := []byte(`{}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin/reconcile", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "wrong")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, models.ErrorResponseModel{Code: "UNAUTHORIZED", Message: "unauthorized", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestAdminHandler_GrantBonus(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 2,
+		"bonus_id": 1,
+		"currency": "JPY",
+		"amount": "500",
+		"wagering_multiplier": 3,
+		"expires_at": "2020-07-13T10:00:00Z"
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin/bonus/grant", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", adminApiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{
+		"id": 1,
+		"currency": "JPY",
+		"amount": "500",
+		"balance": "500",
+		"wagering_target": "1500",
+		"wagered": "0",
+		"status": "Active",
+		"expires_at": "2020-07-13T10:00:00Z"
+	}`, string(resBytes))
+}
+
+func TestAdminHandler_GrantBonusAlreadyActive(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 2,
+		"bonus_id": 2,
+		"currency": "JPY",
+		"amount": "100",
+		"wagering_multiplier": 1,
+		"expires_at": "2020-07-13T10:00:00Z"
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin/bonus/grant", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", adminApiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Equal(t, models.ErrorResponseModel{Code: "BONUS_ALREADY_ACTIVE", Message: "bonus already active", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestAdminHandler_ChangeStatus(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 1,
+		"status": "Suspended",
+		"reason": "document check"
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin/user/status", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", adminApiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{
+		"user_id": 1,
+		"status": "Suspended",
+		"reason": "document check",
+		"changed_at": "2020-07-12T10:00:00Z"
+	}`, string(resBytes))
+}
+
+func TestAdminHandler_ChangeStatusReactivate(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 1,
+		"status": "Active",
+		"reason": "documents verified"
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin/user/status", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", adminApiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{
+		"user_id": 1,
+		"status": "Active",
+		"reason": "documents verified",
+		"changed_at": "2020-07-12T10:00:00Z"
+	}`, string(resBytes))
+}
+
+func adminCall(t *testing.T, apiKey string, path string, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin%s", srv.URL, path), bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", apiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, resBytes
+}
+
+func TestAdminHandler_SearchUsers(t *testing.T) {
+	status, resBytes := adminCall(t, supportApiKey, "/users/search", `{"currency": "JPY"}`)
+
+	var searchResponse models.SearchUsersResponseModel
+	if err := json.Unmarshal(resBytes, &searchResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, status)
+	if assert.Len(t, searchResponse.Users, 1) {
+		assert.Equal(t, uint64(2), searchResponse.Users[0].Id)
+	}
+
+	status, resBytes = adminCall(t, supportApiKey, "/users/search", `{"limit": 1}`)
+	searchResponse = models.SearchUsersResponseModel{}
+	if err := json.Unmarshal(resBytes, &searchResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, status)
+	if assert.Len(t, searchResponse.Users, 1) {
+		assert.Equal(t, uint64(1), searchResponse.Users[0].Id)
+	}
+	assert.Equal(t, uint64(1), searchResponse.NextAfterId)
+}
+
+func TestAdminHandler_GetUser(t *testing.T) {
+	status, resBytes := adminCall(t, supportApiKey, "/user/get", `{"user_id": 1}`)
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{
+		"id": 1,
+		"status": "Active",
+		"wallets": [{
+			"currency": "EUR",
+			"balance": "50.00",
+			"deposit_count": 2,
+			"deposit_sum": "200.00",
+			"bet_count": 1,
+			"bet_sum": "50.00",
+			"win_count": 0,
+			"win_sum": "0.00",
+			"withdrawal_count": 0,
+			"withdrawal_sum": "0.00",
+			"pending_withdrawal_sum": "0.00"
+		}]
+	}`, string(resBytes))
+
+	status, resBytes = adminCall(t, supportApiKey, "/user/get", `{"user_id": 99}`)
+
+	var errorResponse models.ErrorResponseModel
+	if err := json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, models.ErrorResponseModel{Code: "NOT_FOUND", Message: "not found", RequestId: errorResponse.RequestId}, errorResponse)
+}
+
+func TestAdminHandler_History(t *testing.T) {
+	status, resBytes := adminCall(t, supportApiKey, "/user/history", `{"user_id": 2, "types": ["Win"]}`)
+
+	var historyResponse models.HistoryResponseModel
+	if err := json.Unmarshal(resBytes, &historyResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, status)
+	if assert.Len(t, historyResponse.Entries, 1) {
+		assert.Equal(t, uint64(3), historyResponse.Entries[0].Id)
+	}
+}
+
+func TestAdminHandler_AdjustBalanceForbidden(t *testing.T) {
+	status, resBytes := adminCall(t, supportApiKey, "/user/adjust", `{
+		"user_id": 2,
+		"adjustment_id": 1,
+		"currency": "EUR",
+		"amount": "10.00",
+		"reason": "goodwill"
+	}`)
+
+	var errorResponse models.ErrorResponseModel
+	if err := json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, models.ErrorResponseModel{Code: "FORBIDDEN", Message: "forbidden", RequestId: errorResponse.RequestId}, errorResponse)
+}
+
+func TestAdminHandler_AdjustBalance(t *testing.T) {
+	credit := `{
+		"user_id": 2,
+		"adjustment_id": 1,
+		"currency": "EUR",
+		"amount": "10.00",
+		"reason": "goodwill"
+	}`
+	expected := `{
+		"id": 1,
+		"user_id": 2,
+		"currency": "EUR",
+		"amount": "10.00",
+		"reason": "goodwill",
+		"actor": "finance",
+		"balance_before": "75.00",
+		"balance_after": "85.00",
+		"created_at": "2020-07-12T10:00:00Z"
+	}`
+
+	status, resBytes := adminCall(t, financeApiKey, "/user/adjust", credit)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, expected, string(resBytes))
+
+	status, resBytes = adminCall(t, financeApiKey, "/user/adjust", credit)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, expected, string(resBytes))
+
+	status, resBytes = adminCall(t, financeApiKey, "/user/adjust", `{
+		"user_id": 2,
+		"adjustment_id": 2,
+		"currency": "EUR",
+		"amount": "-10.00",
+		"reason": "goodwill reverted"
+	}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{
+		"id": 2,
+		"user_id": 2,
+		"currency": "EUR",
+		"amount": "-10.00",
+		"reason": "goodwill reverted",
+		"actor": "finance",
+		"balance_before": "85.00",
+		"balance_after": "75.00",
+		"created_at": "2020-07-12T10:00:00Z"
+	}`, string(resBytes))
+
+	status, resBytes = adminCall(t, financeApiKey, "/user/adjust", `{
+		"user_id": 2,
+		"adjustment_id": 1,
+		"currency": "EUR",
+		"amount": "20.00",
+		"reason": "goodwill"
+	}`)
+
+	var errorResponse models.ErrorResponseModel
+	if err := json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, models.ErrorResponseModel{Code: "CONFLICT", Message: "conflict", RequestId: errorResponse.RequestId}, errorResponse)
+}
+
+func TestAdminHandler_AdjustBalanceNotEnoughBalance(t *testing.T) {
+	status, resBytes := adminCall(t, financeApiKey, "/user/adjust", `{
+		"user_id": 2,
+		"adjustment_id": 3,
+		"currency": "EUR",
+		"amount": "-100.00",
+		"reason": "chargeback"
+	}`)
+
+	var errorResponse models.ErrorResponseModel
+	if err := json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, models.ErrorResponseModel{Code: "NOT_ENOUGH_BALANCE", Message: "not enough balance", RequestId: errorResponse.RequestId}, errorResponse)
+}
+
+func TestAdminHandler_Audit(t *testing.T) {
+	entries, err := service.AuditRepository.Find(models.AuditFilterModel{UserId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := make([]models.AuditModel, 0, len(entries))
+	for _, entry := range entries {
+		calls = append(calls, models.AuditModel{
+			Endpoint: entry.Endpoint,
+			Caller:   entry.Caller,
+			Actor:    entry.Actor,
+			Role:     entry.Role,
+			UserId:   entry.UserId,
+			Status:   entry.Status,
+			Result:   entry.Result,
+			Error:    entry.Error,
+		})
+	}
+
+	assert.Contains(t, calls, models.AuditModel{Endpoint: "/admin/user/adjust", Caller: models.CallerAdmin, Actor: "support", Role: models.RoleSupport, UserId: 2, Status: http.StatusForbidden, Result: models.AuditFailure, Error: "forbidden"})
+	assert.Contains(t, calls, models.AuditModel{Endpoint: "/admin/user/adjust", Caller: models.CallerAdmin, Actor: "finance", Role: models.RoleFinance, UserId: 2, Status: http.StatusOK, Result: models.AuditSuccess})
+	assert.Contains(t, calls, models.AuditModel{Endpoint: "/admin/user/adjust", Caller: models.CallerAdmin, Actor: "finance", Role: models.RoleFinance, UserId: 2, Status: http.StatusConflict, Result: models.AuditFailure, Error: "conflict"})
+	assert.Contains(t, calls, models.AuditModel{Endpoint: "/admin/bonus/grant", Caller: models.CallerAdmin, Actor: "admin", Role: models.RoleSuperuser, UserId: 2, Status: http.StatusOK, Result: models.AuditSuccess})
+}
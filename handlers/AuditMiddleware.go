@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"guru/models"
+	"guru/services"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+// maxAuditedBody bounds the body an audited call reads to hash it.
+const maxAuditedBody = 1 << 20
+
+// AuditTrail writes every call of the endpoints it wraps, failed ones included, to the audit trail.
+type AuditTrail struct {
+	service *services.UserService
+}
+
+func NewAuditTrail(service *services.UserService) *AuditTrail {
+	return &AuditTrail{service: service}
+}
+
+// Middleware audits the calls of one kind of caller. Actor names the caller when it is known up front, like
+// a provider; later middlewares and handlers complete the entry with recordActor, recordUser and recordError.
+// Calls outside of the RequestId middleware get their request id here.
+func (t *AuditTrail) Middleware(caller string, actor string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// A body over the limit is cut short and the call fails, audited with the hash of what was read.
+			body, readErr := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxAuditedBody))
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			var target struct {
+				UserId uint64 `json:"user_id"`
+			}
+			_ = json.Unmarshal(body, &target)
+			payloadHash := sha256.Sum256(body)
+
+			recorder := &auditRecorder{
+				ResponseWriter: w,
+				status:         http.StatusOK,
+				entry: models.AuditModel{
+					Endpoint:    req.URL.Path,
+					Caller:      caller,
+					Actor:       actor,
+					UserId:      target.UserId,
+					SourceIp:    sourceIp(req),
+					RequestId:   requestId(w, req),
+					PayloadHash: hex.EncodeToString(payloadHash[:]),
+				},
+			}
+			if readErr != nil {
+				recorder.Header().Add("Content-Type", "application/json")
+				writeError(recorder, services.InvalidRequest(readErr))
+			} else {
+				next.ServeHTTP(recorder, req)
+			}
+
+			for _, entry := range recorder.result() {
+				if err := t.service.Audit(entry); err != nil {
+					zap.L().Error(err.Error(), zap.String("endpoint", entry.Endpoint), zap.String("request_id", entry.RequestId))
+				}
+			}
+		})
+	}
+}
+
+// sourceIp returns the address of the peer of the connection; the app is reached without a proxy.
+func sourceIp(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// auditRecorder builds the audit entry of a call while it is served. It keeps the first status written,
+// as the response does, and the body of error responses to find their error.
+type auditRecorder struct {
+	http.ResponseWriter
+	entry       models.AuditModel
+	users       []uint64
+	status      int
+	wroteHeader bool
+	errorBody   []byte
+}
+
+func (r *auditRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *auditRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	if r.status >= http.StatusBadRequest {
+		r.errorBody = append(r.errorBody, data...)
+	}
+
+	return r.ResponseWriter.Write(data)
+}
+
+// result completes the entry with the outcome of the call, one entry per user of a call touching many.
+func (r *auditRecorder) result() []models.AuditModel {
+	entry := r.entry
+	entry.Status = r.status
+	if entry.Error == "" && len(r.errorBody) > 0 {
+		var errorResponse models.ErrorResponseModel
+		if err := json.Unmarshal(r.errorBody, &errorResponse); err == nil {
+			entry.Error = errorResponse.Message
+			entry.ErrorCode = errorResponse.Code
+		}
+	}
+
+	entry.Result = models.AuditSuccess
+	if entry.Status >= http.StatusBadRequest || entry.Error != "" {
+		entry.Result = models.AuditFailure
+	}
+
+	if len(r.users) == 0 {
+		return []models.AuditModel{entry}
+	}
+	entries := make([]models.AuditModel, len(r.users))
+	for i, userId := range r.users {
+		entries[i] = entry
+		entries[i].UserId = userId
+	}
+
+	return entries
+}
+
+// recordActor sets who made an audited call once it is authenticated.
+func recordActor(w http.ResponseWriter, actor string, role string) {
+	if r, ok := w.(*auditRecorder); ok {
+		r.entry.Actor = actor
+		r.entry.Role = role
+	}
+}
+
+// recordUser sets the users of an audited call whose request names them in another field than user_id; a
+// call of many users is audited once for each of them.
+func recordUser(w http.ResponseWriter, userIds ...uint64) {
+	if r, ok := w.(*auditRecorder); ok {
+		r.users = userIds
+	}
+}
+
+// recordError sets the error of an audited call whose response does not carry it as models.ErrorResponseModel.
+func recordError(w http.ResponseWriter, err error) {
+	if r, ok := w.(*auditRecorder); ok {
+		r.entry.Error = err.Error()
+		r.entry.ErrorCode = services.AsError(err).Code
+	}
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"guru/models"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAuditTrail_FailedDeposit(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 1,
+		"deposit_id": 90,
+		"currency": "EUR",
+		"amount": "10.00",
+		"token": "wrong"
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/user/deposit", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestIdHeader, "deposit-90")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "deposit-90", res.Header.Get(requestIdHeader))
+
+	status, resBytes := adminCall(t, financeApiKey, "/audit/search", `{"user_id": 1, "from": "2020-07-12T10:00:00Z", "limit": 100}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	var searchResponse models.SearchAuditResponseModel
+	if err := json.Unmarshal(resBytes, &searchResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry *models.AuditModel
+	for i := range searchResponse.Entries {
+		if searchResponse.Entries[i].RequestId == "deposit-90" {
+			entry = &searchResponse.Entries[i]
+		}
+	}
+	if !assert.NotNil(t, entry) {
+		return
+	}
+
+	payloadHash := sha256.Sum256(jsonStr)
+	assert.Equal(t, "/user/deposit", entry.Endpoint)
+	assert.Equal(t, models.CallerPlayer, entry.Caller)
+	assert.Equal(t, uint64(1), entry.UserId)
+	assert.Equal(t, "127.0.0.1", entry.SourceIp)
+	assert.Equal(t, hex.EncodeToString(payloadHash[:]), entry.PayloadHash)
+	assert.Equal(t, http.StatusBadRequest, entry.Status)
+	assert.Equal(t, models.AuditFailure, entry.Result)
+	assert.Equal(t, "wrong token", entry.Error)
+	assert.Equal(t, entry.ComputeHash(), entry.Hash)
+}
+
+func TestAuditTrail_Verify(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/admin/audit/verify", srv.URL), bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", financeApiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var verification models.AuditVerificationModel
+	if err = json.Unmarshal(resBytes, &verification); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, res.Header.Get(requestIdHeader), 32)
+	assert.True(t, verification.Valid)
+	assert.NotZero(t, verification.Checked)
+}
+
+func TestAuditTrail_Batch(t *testing.T) {
+	// The wrong token of user 2 aborts the batch, so the wallets are left as they are.
+	jsonStr := []byte(`{"mode": "all_or_nothing", "transactions": [
+		{"user_id": 1, "transaction_id": 93, "type": "Bet", "currency": "EUR", "amount": "1.00", "token": "sssss"},
+		{"user_id": 2, "transaction_id": 94, "type": "Bet", "currency": "EUR", "amount": "1.00", "token": "wrong"}
+	]}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/transaction/batch", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestIdHeader, "batch-93")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	for _, userId := range []uint64{1, 2} {
+		status, resBytes := adminCall(t, financeApiKey, "/audit/search", fmt.Sprintf(`{"user_id": %d, "limit": 100}`, userId))
+		assert.Equal(t, http.StatusOK, status)
+
+		var searchResponse models.SearchAuditResponseModel
+		if err := json.Unmarshal(resBytes, &searchResponse); err != nil {
+			t.Fatal(err)
+		}
+
+		var entries []models.AuditModel
+		for _, entry := range searchResponse.Entries {
+			if entry.RequestId == "batch-93" {
+				entries = append(entries, entry)
+			}
+		}
+		if assert.Len(t, entries, 1, userId) {
+			assert.Equal(t, "/transaction/batch", entries[0].Endpoint)
+			assert.Equal(t, userId, entries[0].UserId)
+		}
+	}
+}
+
+func TestAuditTrail_Users(t *testing.T) {
+	call := func(path string, apiKey string, requestId string, body string) int {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", srv.URL, path), bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(requestIdHeader, requestId)
+		req.Header.Set("X-Api-Key", apiKey)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		return res.StatusCode
+	}
+	audited := func(userId uint64, requestId string) *models.AuditModel {
+		status, resBytes := adminCall(t, financeApiKey, "/audit/search", fmt.Sprintf(`{"user_id": %d, "limit": 100}`, userId))
+		assert.Equal(t, http.StatusOK, status)
+
+		var searchResponse models.SearchAuditResponseModel
+		if err := json.Unmarshal(resBytes, &searchResponse); err != nil {
+			t.Fatal(err)
+		}
+		for i := range searchResponse.Entries {
+			if searchResponse.Entries[i].RequestId == requestId {
+				return &searchResponse.Entries[i]
+			}
+		}
+
+		return nil
+	}
+
+	// Calls naming their user in another field than user_id are audited for that user.
+	assert.Equal(t, http.StatusOK, call("/user/create", "", "create-95", `{"id": 95, "wallets": {"EUR": "10.00"}, "token": "token"}`))
+	assert.NotNil(t, audited(95, "create-95"))
+	assert.Equal(t, http.StatusOK, call("/user/withdrawal", "", "withdraw-95", `{"user_id": 95, "withdrawal_id": 95, "currency": "EUR", "amount": "1.00", "token": "token"}`))
+	assert.Equal(t, http.StatusOK, call("/withdrawal/settle", paymentApiKey, "settle-95", `{"withdrawal_id": 95, "status": "Rejected"}`))
+	assert.NotNil(t, audited(95, "settle-95"))
+
+	// A body over the limit fails the call, which is still audited.
+	oversized := fmt.Sprintf(`{"user_id": 95, "padding": "%s"}`, strings.Repeat("x", maxAuditedBody))
+	assert.Equal(t, http.StatusBadRequest, call("/user/deposit", "", "oversized-95", oversized))
+	if entry := audited(0, "oversized-95"); assert.NotNil(t, entry) {
+		assert.Equal(t, models.AuditFailure, entry.Result)
+	}
+}
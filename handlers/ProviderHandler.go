@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+	"guru/adapters"
+	"guru/models"
+	"guru/services"
+	"net/http"
+)
+
+// ProviderHandler serves the seamless-wallet calls of one game provider, translated by its adapter.
+type ProviderHandler struct {
+	service   *services.UserService
+	adapter   adapters.Adapter
+	validator *validator.Validate
+}
+
+func NewProviderHandler(service *services.UserService, adapter adapters.Adapter) *ProviderHandler {
+	return &ProviderHandler{
+		service:   service,
+		adapter:   adapter,
+		validator: validator.New(),
+	}
+}
+
+func (h *ProviderHandler) Adapter() adapters.Adapter {
+	return h.adapter
+}
+
+// Handle returns the handler of an action of models.ProviderActions.
+func (h *ProviderHandler) Handle(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		call, err := h.adapter.Decode(action, req)
+		if err == nil {
+			err = h.validate(call)
+		}
+		if err != nil {
+			if call == nil {
+				call = &models.ProviderRequestModel{Action: action}
+			}
+			zap.L().Error(err.Error())
+			recordError(w, services.InvalidRequest(err))
+			h.adapter.EncodeError(w, call, &adapters.RequestError{Err: err})
+			return
+		}
+		recordUser(w, call.UserId)
+
+		response, err := h.service.ProviderCall(h.adapter.Provider(), *call)
+		if err != nil {
+			zap.L().Error(err.Error())
+			recordError(w, err)
+			h.adapter.EncodeError(w, call, err)
+			return
+		}
+
+		h.adapter.Encode(w, call, response)
+	}
+}
+
+func (h *ProviderHandler) validate(call *models.ProviderRequestModel) error {
+	if err := h.validator.Struct(call); err != nil {
+		return err
+	}
+
+	switch call.Action {
+	case models.ProviderDebit, models.ProviderCredit, models.ProviderRollback:
+		return h.validator.Var(call.TransactionId, "required")
+	}
+
+	return nil
+}
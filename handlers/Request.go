@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"guru/services"
+	"net/http"
+)
+
+var errTrailingData = errors.New("unexpected data after the request")
+
+// decodeRequest decodes the JSON body of req into request and validates it. The body must hold a single
+// object without unknown fields. On the first failure it writes the error response and returns false, and the
+// handler must stop there.
+func decodeRequest(w http.ResponseWriter, req *http.Request, validate *validator.Validate, request interface{}) bool {
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(request); err != nil {
+		writeError(w, services.InvalidRequest(err))
+		return false
+	}
+	if decoder.More() {
+		writeError(w, services.InvalidRequest(errTrailingData))
+		return false
+	}
+
+	if err := validate.Struct(request); err != nil {
+		writeError(w, services.InvalidRequest(err))
+		return false
+	}
+
+	return true
+}
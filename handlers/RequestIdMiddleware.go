@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+const (
+	requestIdHeader    = "X-Request-Id"
+	maxRequestIdLength = 128
+)
+
+// RequestId gives every call a request id, taken from the X-Request-Id header or generated, and echoes it in
+// the X-Request-Id header of the response, where error responses and the audit trail find it.
+func RequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestId(w, req)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// requestId returns the request id of the call, setting it on the response when it is not yet.
+func requestId(w http.ResponseWriter, req *http.Request) string {
+	if id := w.Header().Get(requestIdHeader); id != "" {
+		return id
+	}
+
+	id := req.Header.Get(requestIdHeader)
+	if id == "" || len(id) > maxRequestIdLength {
+		id = newRequestId()
+	}
+	w.Header().Set(requestIdHeader, id)
+
+	return id
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		zap.L().Error(err.Error())
+	}
+
+	return hex.EncodeToString(id)
+}
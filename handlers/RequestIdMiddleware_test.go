@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"guru/models"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestId(t *testing.T) {
+	call := func(requestId string) (*http.Response, models.ErrorResponseModel) {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/user/get", srv.URL), bytes.NewBufferString(`{"id": 1, "token": "wrong"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if requestId != "" {
+			req.Header.Set("X-Request-Id", requestId)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		resBytes, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var errorResponse models.ErrorResponseModel
+		if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+			t.Fatal(err)
+		}
+
+		return res, errorResponse
+	}
+
+	// The id of the caller is echoed in the header and the error.
+	res, errorResponse := call("req-1")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "req-1", res.Header.Get("X-Request-Id"))
+	assert.Equal(t, models.ErrorResponseModel{Code: "WRONG_TOKEN", Message: "wrong token", RequestId: "req-1"}, errorResponse)
+
+	// A missing or oversized id is replaced with a generated one.
+	for _, requestId := range []string{"", strings.Repeat("x", 129)} {
+		res, errorResponse = call(requestId)
+		assert.Len(t, res.Header.Get("X-Request-Id"), 32)
+		assert.Equal(t, res.Header.Get("X-Request-Id"), errorResponse.RequestId)
+	}
+}
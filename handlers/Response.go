@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"guru/models"
+	"guru/services"
+	"net/http"
+)
+
+// errorStatuses maps the kinds of domain errors to HTTP statuses.
+var errorStatuses = map[services.ErrorKind]int{
+	services.KindInternal:     http.StatusInternalServerError,
+	services.KindInvalid:      http.StatusBadRequest,
+	services.KindUnauthorized: http.StatusUnauthorized,
+	services.KindForbidden:    http.StatusForbidden,
+	services.KindNotFound:     http.StatusNotFound,
+	services.KindConflict:     http.StatusConflict,
+}
+
+// writeError writes the response to err. Errors that are not domain errors are reported as services.ErrInternal,
+// their details are only logged.
+func writeError(w http.ResponseWriter, err error) {
+	zap.L().Error(err.Error())
+	domainError := services.AsError(err)
+
+	w.WriteHeader(errorStatuses[domainError.Kind])
+	errorResponse := models.ErrorResponseModel{
+		Code:      domainError.Code,
+		Message:   domainError.Message,
+		RequestId: w.Header().Get(requestIdHeader),
+	}
+	if err := json.NewEncoder(w).Encode(errorResponse); err != nil {
+		zap.L().Error(err.Error())
+	}
+}
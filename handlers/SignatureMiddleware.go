@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gorilla/mux"
+	"guru/services"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	signatureHeader = "X-Signature"
+	timestampHeader = "X-Timestamp"
+)
+
+var (
+	errMissingSignature = &services.Error{Kind: services.KindUnauthorized, Code: "MISSING_SIGNATURE", Message: "missing signature"}
+	errInvalidTimestamp = &services.Error{Kind: services.KindUnauthorized, Code: "INVALID_TIMESTAMP", Message: "invalid timestamp"}
+	errRequestExpired   = &services.Error{Kind: services.KindUnauthorized, Code: "REQUEST_EXPIRED", Message: "request expired"}
+	errInvalidSignature = &services.Error{Kind: services.KindUnauthorized, Code: "INVALID_SIGNATURE", Message: "invalid signature"}
+	errRequestReplayed  = &services.Error{Kind: services.KindUnauthorized, Code: "REQUEST_REPLAYED", Message: "request replayed"}
+)
+
+// SignatureVerifier authenticates provider calls signed with a per-provider secret. A call carries the Unix time
+// of signing in X-Timestamp and the hex HMAC-SHA256 of the timestamp, a dot and the raw body in X-Signature.
+// Calls signed outside the window around the current time are rejected, and so are repeated calls within it.
+type SignatureVerifier struct {
+	secrets map[string][]byte
+	window  time.Duration
+	clock   func() time.Time
+
+	sync.Mutex
+	// seen holds the signatures accepted within the window, keyed by provider and signature, with their timestamps.
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewSignatureVerifier creates a verifier of the providers in secrets. Calls of a provider without a secret
+// are rejected.
+func NewSignatureVerifier(secrets map[string]string, window time.Duration, clock func() time.Time) *SignatureVerifier {
+	v := &SignatureVerifier{
+		secrets: make(map[string][]byte, len(secrets)),
+		window:  window,
+		clock:   clock,
+		seen:    make(map[string]time.Time),
+	}
+	for provider, secret := range secrets {
+		if secret != "" {
+			v.secrets[provider] = []byte(secret)
+		}
+	}
+
+	return v
+}
+
+// Middleware returns the middleware checking the calls of provider.
+func (v *SignatureVerifier) Middleware(provider string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				w.Header().Add("Content-Type", "application/json")
+				writeError(w, services.InvalidRequest(err))
+				return
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			if err := v.verify(provider, req.Header.Get(timestampHeader), req.Header.Get(signatureHeader), body); err != nil {
+				w.Header().Add("Content-Type", "application/json")
+				writeError(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func (v *SignatureVerifier) verify(provider string, timestamp string, signature string, body []byte) error {
+	secret, ok := v.secrets[provider]
+	if !ok {
+		return services.ErrUnauthorized
+	}
+	if timestamp == "" || signature == "" {
+		return errMissingSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errInvalidTimestamp
+	}
+	signedAt := time.Unix(seconds, 0)
+	now := v.clock()
+	if signedAt.Before(now.Add(-v.window)) || signedAt.After(now.Add(v.window)) {
+		return errRequestExpired
+	}
+
+	expected := hmac.New(sha256.New, secret)
+	expected.Write([]byte(timestamp))
+	expected.Write([]byte("."))
+	expected.Write(body)
+	actual, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(actual, expected.Sum(nil)) {
+		return errInvalidSignature
+	}
+
+	return v.remember(provider+":"+hex.EncodeToString(actual), signedAt, now)
+}
+
+// remember records an accepted signature and fails when it was accepted before. Signatures leave the record
+// once their timestamp falls out of the window, when they can no longer pass the expiry check.
+func (v *SignatureVerifier) remember(key string, signedAt time.Time, now time.Time) error {
+	v.Lock()
+	defer v.Unlock()
+
+	if now.Sub(v.pruned) > v.window {
+		for seenKey, seenAt := range v.seen {
+			if seenAt.Before(now.Add(-v.window)) {
+				delete(v.seen, seenKey)
+			}
+		}
+		v.pruned = now
+	}
+
+	if _, ok := v.seen[key]; ok {
+		return errRequestReplayed
+	}
+	v.seen[key] = signedAt
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"guru/models"
+	"guru/services"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSignatureVerifier_Middleware(t *testing.T) {
+	verifier := NewSignatureVerifier(map[string]string{"acme": "secret", "empty": ""}, 5*time.Minute, func() time.Time { return now })
+
+	r := mux.NewRouter()
+	for _, provider := range []string{"acme", "empty", "unknown"} {
+		p := r.PathPrefix("/provider/" + provider).Subrouter()
+		p.Use(verifier.Middleware(provider))
+		p.HandleFunc("/echo", func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			w.Write(body)
+		}).Methods(http.MethodPost)
+	}
+	signed := httptest.NewServer(r)
+	defer signed.Close()
+
+	call := func(provider string, timestamp string, signature string, body []byte) (int, []byte) {
+		req, err := http.NewRequest(http.MethodPost, signed.URL+"/provider/"+provider+"/echo", bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timestamp != "" {
+			req.Header.Set(timestampHeader, timestamp)
+		}
+		if signature != "" {
+			req.Header.Set(signatureHeader, signature)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		resBytes, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return res.StatusCode, resBytes
+	}
+	assertError := func(expected *services.Error, status int, resBytes []byte) {
+		t.Helper()
+		var errorResponse models.ErrorResponseModel
+		if err := json.Unmarshal(resBytes, &errorResponse); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, expected.Code, errorResponse.Code)
+		assert.Equal(t, expected.Message, errorResponse.Message)
+	}
+
+	body := []byte(`{"player_id": 1}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	status, resBytes := call("acme", timestamp, sign("secret", timestamp, body), body)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, body, resBytes)
+
+	status, resBytes = call("acme", timestamp, sign("secret", timestamp, body), body)
+	assertError(errRequestReplayed, status, resBytes)
+
+	status, resBytes = call("acme", timestamp, sign("other", timestamp, body), body)
+	assertError(errInvalidSignature, status, resBytes)
+
+	status, resBytes = call("acme", timestamp, sign("secret", timestamp, body), []byte(`{"player_id": 2}`))
+	assertError(errInvalidSignature, status, resBytes)
+
+	status, resBytes = call("acme", timestamp, "not hex", body)
+	assertError(errInvalidSignature, status, resBytes)
+
+	status, resBytes = call("acme", "", "", body)
+	assertError(errMissingSignature, status, resBytes)
+
+	stale := strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10)
+	status, resBytes = call("acme", stale, sign("secret", stale, body), body)
+	assertError(errRequestExpired, status, resBytes)
+
+	status, resBytes = call("acme", "yesterday", sign("secret", "yesterday", body), body)
+	assertError(errInvalidTimestamp, status, resBytes)
+
+	status, resBytes = call("empty", timestamp, sign("", timestamp, body), body)
+	assertError(services.ErrUnauthorized, status, resBytes)
+
+	status, resBytes = call("unknown", timestamp, sign("secret", timestamp, body), body)
+	assertError(services.ErrUnauthorized, status, resBytes)
+}
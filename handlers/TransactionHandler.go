@@ -23,47 +23,62 @@ func NewTransactionHandler(service *services.UserService) *TransactionHandler {
 
 func (h *TransactionHandler) Transaction(w http.ResponseWriter, req *http.Request) {
 	var transactionRequest models.TransactionRequestModel
-	var errorResponse models.ErrorResponseModel
 
-	err := json.NewDecoder(req.Body).Decode(&transactionRequest)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		errorResponse.Error = err.Error()
-		zap.L().Error(err.Error())
+	w.Header().Add("Content-Type", "application/json")
+	if !decodeRequest(w, req, h.validator, &transactionRequest) {
+		return
 	}
 
-	err = h.validator.Struct(&transactionRequest)
+	transactionResponse, err := h.service.Transaction(transactionRequest)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		errorResponse.Error = err.Error()
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(transactionResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
+}
 
-	transactionResponse, err := h.service.Transaction(transactionRequest)
+// BatchTransaction answers 200 with a result per transaction once the batch is validated, whichever of its
+// transactions failed.
+func (h *TransactionHandler) BatchTransaction(w http.ResponseWriter, req *http.Request) {
+	var batchRequest models.BatchTransactionRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !decodeRequest(w, req, h.validator, &batchRequest) {
+		return
+	}
+	recordUser(w, batchRequest.UserIds()...)
+
+	batchResponse, err := h.service.BatchTransaction(batchRequest)
 	if err != nil {
-		switch err.Error() {
-		case "wrong token", "not enough balance":
-			w.WriteHeader(http.StatusBadRequest)
-		case "not found":
-			w.WriteHeader(http.StatusNotFound)
-		default:
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+		writeError(w, err)
+		return
+	}
 
-		errorResponse.Error = err.Error()
+	if err := json.NewEncoder(w).Encode(batchResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
+}
+
+func (h *TransactionHandler) CloseRound(w http.ResponseWriter, req *http.Request) {
+	var closeRequest models.CloseRoundRequestModel
 
 	w.Header().Add("Content-Type", "application/json")
-	if errorResponse.Error != "" {
-		if err := json.NewEncoder(w).Encode(errorResponse); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			zap.L().Error(err.Error())
-		}
+	if !decodeRequest(w, req, h.validator, &closeRequest) {
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(transactionResponse); err != nil {
+	roundResponse, err := h.service.CloseRound(closeRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(roundResponse); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
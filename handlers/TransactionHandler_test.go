@@ -2,13 +2,10 @@ package handlers
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"guru/models"
 	"guru/repositories"
 	"guru/services"
@@ -17,72 +14,153 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"os/signal"
-	"syscall"
 	"testing"
 	"time"
 )
 
-var srv *httptest.Server
-
-func TestMain(m *testing.M) {
-	credential := options.Credential{
-		Username: "mongo",
-		Password: "mongo",
-	}
-	clientOpts := options.Client().ApplyURI("mongodb://localhost:27017").SetAuth(credential)
-	client, err := mongo.Connect(context.TODO(), clientOpts)
-	if err != nil {
-		log.Fatal(err)
-	}
+var (
+	srv     *httptest.Server
+	service *services.UserService
+)
 
-	if err = client.Ping(context.TODO(), nil); err != nil {
-		log.Fatal(err.Error())
-	}
+const (
+	paymentApiKey = "payment-key"
+	adminApiKey   = "admin-key"
+	financeApiKey = "finance-key"
+	supportApiKey = "support-key"
+)
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		<-stop
-		log.Printf("[WARN] interrupt signal")
-		cancel()
-	}()
+var (
+	depositTime     = time.Date(2020, 7, 11, 7, 57, 6, 0, time.UTC)
+	transactionTime = time.Date(2020, 7, 11, 8, 57, 6, 0, time.UTC)
+	now             = time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+)
 
-	db := client.Database("test_guru")
-	service := &services.UserService{
-		UserRepository:        repositories.UserRepository{DB: db},
-		DepositRepository:     repositories.DepositRepository{DB: db},
-		TransactionRepository: repositories.TransactionRepository{DB: db},
-		Ticker:                time.NewTicker(10 * time.Second),
+func TestMain(m *testing.M) {
+	service = &services.UserService{
+		UserRepository: repositories.NewMemoryUserRepository(
+			models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 5000}, Token: "sssss"},
+			models.UserModel{Id: 2, Wallets: models.Wallets{"EUR": 7500, "JPY": 1000}, OpeningWallets: models.Wallets{"JPY": 1000}, Token: "ddddd"},
+		),
+		DepositRepository: repositories.NewMemoryDepositRepository(
+			models.DepositModel{Id: 1, UserId: 1, Currency: "EUR", Amount: 10000, BalanceBefore: 0, BalanceAfter: 10000, CreatedAt: depositTime},
+			models.DepositModel{Id: 3, UserId: 1, Currency: "EUR", Amount: 10000, BalanceBefore: 0, BalanceAfter: 10000, CreatedAt: depositTime},
+			models.DepositModel{Id: 2, UserId: 2, Currency: "EUR", Amount: 5000, BalanceBefore: 0, BalanceAfter: 5000, CreatedAt: depositTime},
+		),
+		TransactionRepository: repositories.NewMemoryTransactionRepository(
+			models.TransactionModel{Id: 1, UserId: 1, Currency: "EUR", Amount: 5000, Type: models.TypeBet, BalanceBefore: 10000, BalanceAfter: 5000, CreatedAt: transactionTime},
+			models.TransactionModel{Id: 2, UserId: 2, Currency: "EUR", Amount: 2500, Type: models.TypeBet, BalanceBefore: 5000, BalanceAfter: 2500, CreatedAt: transactionTime},
+			models.TransactionModel{Id: 3, UserId: 2, Currency: "EUR", Amount: 5000, Type: models.TypeWin, BalanceBefore: 2500, BalanceAfter: 7500, CreatedAt: transactionTime},
+		),
+		WithdrawalRepository: repositories.NewMemoryWithdrawalRepository(),
+		BonusRepository:      repositories.NewMemoryBonusRepository(),
+		LimitRepository:      repositories.NewMemoryLimitRepository(),
+		RoundRepository:      repositories.NewMemoryRoundRepository(),
+		AdjustmentRepository: repositories.NewMemoryAdjustmentRepository(),
+		AuditRepository:      repositories.NewMemoryAuditRepository(),
+		Journal:              repositories.NewMemoryJournalRepository(),
+		Ticker:               time.NewTicker(10 * time.Second),
+		Clock:                func() time.Time { return now },
 	}
 
+	if err := service.Load(); err != nil {
+		log.Fatal(err)
+	}
 
 	userHandler := NewUserHandler(service)
 	transactionHandler := NewTransactionHandler(service)
+	withdrawalHandler := NewWithdrawalHandler(service, paymentApiKey)
+	auditTrail := NewAuditTrail(service)
+	adminHandler := NewAdminHandler(service, map[string]models.AdminKeyModel{
+		adminApiKey:   {Name: "admin", Role: models.RoleSuperuser},
+		financeApiKey: {Name: "finance", Role: models.RoleFinance},
+		supportApiKey: {Name: "support", Role: models.RoleSupport},
+	})
 
 	r := mux.NewRouter()
+	r.Use(RequestId)
+
+	player := auditTrail.Middleware(models.CallerPlayer, "")
+	payment := auditTrail.Middleware(models.CallerPayment, "")
 
 	s := r.PathPrefix("/user").Subrouter()
-	s.HandleFunc("/create", userHandler.Create).Methods(http.MethodPost)
+	s.Handle("/create", player(http.HandlerFunc(userHandler.Create))).Methods(http.MethodPost)
 	s.HandleFunc("/get", userHandler.Get).Methods(http.MethodPost)
-	s.HandleFunc("/deposit", userHandler.AddDeposit).Methods(http.MethodPost)
-
-	r.HandleFunc("/transaction", transactionHandler.Transaction).Methods(http.MethodPost)
-
-	service.Run(ctx, r)
+	s.Handle("/deposit", player(http.HandlerFunc(userHandler.AddDeposit))).Methods(http.MethodPost)
+	s.HandleFunc("/history", userHandler.History).Methods(http.MethodPost)
+	s.HandleFunc("/statistics", userHandler.Statistics).Methods(http.MethodPost)
+	s.HandleFunc("/limits", userHandler.SetLimit).Methods(http.MethodPost)
+	s.HandleFunc("/status", userHandler.SetStatus).Methods(http.MethodPost)
+	s.HandleFunc("/token", userHandler.RotateToken).Methods(http.MethodPost)
+	s.Handle("/withdrawal", player(http.HandlerFunc(withdrawalHandler.Withdraw))).Methods(http.MethodPost)
+
+	r.Handle("/transaction", player(http.HandlerFunc(transactionHandler.Transaction))).Methods(http.MethodPost)
+	r.Handle("/transaction/batch", player(http.HandlerFunc(transactionHandler.BatchTransaction))).Methods(http.MethodPost)
+	r.HandleFunc("/round/close", transactionHandler.CloseRound).Methods(http.MethodPost)
+	r.Handle("/withdrawal/settle", payment(http.HandlerFunc(withdrawalHandler.Settle))).Methods(http.MethodPost)
+
+	a := r.PathPrefix("/admin").Subrouter()
+	a.Use(auditTrail.Middleware(models.CallerAdmin, ""))
+	a.Use(adminHandler.Middleware)
+	a.HandleFunc("/reconcile", adminHandler.Reconcile).Methods(http.MethodPost)
+	a.HandleFunc("/bonus/grant", adminHandler.GrantBonus).Methods(http.MethodPost)
+	a.HandleFunc("/users/search", adminHandler.SearchUsers).Methods(http.MethodPost)
+	a.HandleFunc("/user/get", adminHandler.GetUser).Methods(http.MethodPost)
+	a.HandleFunc("/user/history", adminHandler.History).Methods(http.MethodPost)
+	a.HandleFunc("/user/adjust", adminHandler.AdjustBalance).Methods(http.MethodPost)
+	a.HandleFunc("/user/freeze", adminHandler.Freeze).Methods(http.MethodPost)
+	a.HandleFunc("/user/status", adminHandler.ChangeStatus).Methods(http.MethodPost)
+	a.HandleFunc("/audit/search", adminHandler.SearchAudit).Methods(http.MethodPost)
+	a.HandleFunc("/audit/verify", adminHandler.VerifyAudit).Methods(http.MethodPost)
 
 	srv = httptest.NewServer(r)
-	defer srv.Close()
+	code := m.Run()
+	srv.Close()
 
-	os.Exit(m.Run())
+	os.Exit(code)
 }
 
 func TestTransactionHandler_Transaction(t *testing.T) {
 	jsonStr := []byte(`{
  		"user_id": 1,
-		"transaction_id": 1,
+		"transaction_id": 4,
+		"type": "Win",
+		"currency": "EUR",
+		"amount": 25,
+		"token": "sssss"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/transaction", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var transactionResponse models.TransactionResponseModel
+	if err = json.Unmarshal(resBytes, &transactionResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, models.TransactionResponseModel{Currency: "EUR", Balance: 7500}, transactionResponse)
+}
+
+func TestTransactionHandler_TransactionRepeated(t *testing.T) {
+	jsonStr := []byte(`{
+ 		"user_id": 1,
+		"transaction_id": 4,
 		"type": "Win",
+		"currency": "EUR",
 		"amount": 25,
 		"token": "sssss"
 	}`)
@@ -109,7 +187,42 @@ func TestTransactionHandler_Transaction(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusOK, res.StatusCode)
-	assert.Equal(t, models.TransactionResponseModel{Error: "", Balance: 75}, transactionResponse)
+	assert.Equal(t, models.TransactionResponseModel{Currency: "EUR", Balance: 7500}, transactionResponse)
+}
+
+func TestTransactionHandler_TransactionConflict(t *testing.T) {
+	jsonStr := []byte(`{
+ 		"user_id": 1,
+		"transaction_id": 4,
+		"type": "Win",
+		"currency": "EUR",
+		"amount": 30,
+		"token": "sssss"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/transaction", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Equal(t, models.ErrorResponseModel{Code: "CONFLICT", Message: "conflict", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
 }
 
 func TestTransactionHandler_TransactionWrongToken(t *testing.T) {
@@ -117,6 +230,7 @@ func TestTransactionHandler_TransactionWrongToken(t *testing.T) {
  		"user_id": 1,
 		"transaction_id": 1,
 		"type": "Win",
+		"currency": "EUR",
 		"amount": 25,
 		"token": "ttttt"
 	}`)
@@ -143,7 +257,31 @@ func TestTransactionHandler_TransactionWrongToken(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
-	assert.Equal(t, models.ErrorResponseModel{Error: "wrong token"}, errorResponse)
+	assert.Equal(t, models.ErrorResponseModel{Code: "WRONG_TOKEN", Message: "wrong token", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestTransactionHandler_TransactionInvalid(t *testing.T) {
+	// Requests that fail to decode or validate stop before the service checks the token.
+	for _, body := range []string{
+		`{"user_id": 1, "transaction_id": 90, "type": "Bet", "currency": "EUR", "amount": 25, "token": "wrong", "bonus": true}`,
+		`{"user_id": 1, "transaction_id": 90, "type": "Cashback", "currency": "EUR", "amount": 25, "token": "wrong"}`,
+		`{"user_id": 1, "transaction_id": 90, "type": "Bet", "currency": "EUR", "amount": "1.001", "token": "wrong"}`,
+	} {
+		res, err := http.Post(fmt.Sprintf("%s/transaction", srv.URL), "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var errorResponse models.ErrorResponseModel
+		err = json.NewDecoder(res.Body).Decode(&errorResponse)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, body)
+		assert.NotEqual(t, "WRONG_TOKEN", errorResponse.Code, body)
+	}
 }
 
 func TestTransactionHandler_TransactionNotFound(t *testing.T) {
@@ -151,6 +289,7 @@ func TestTransactionHandler_TransactionNotFound(t *testing.T) {
  		"user_id": 5,
 		"transaction_id": 1,
 		"type": "Win",
+		"currency": "EUR",
 		"amount": 25,
 		"token": "ttttt"
 	}`)
@@ -177,14 +316,15 @@ func TestTransactionHandler_TransactionNotFound(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusNotFound, res.StatusCode)
-	assert.Equal(t, models.ErrorResponseModel{Error: "not found"}, errorResponse)
+	assert.Equal(t, models.ErrorResponseModel{Code: "NOT_FOUND", Message: "not found", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
 }
 
 func TestTransactionHandler_TransactionNotEnoughBalance(t *testing.T) {
 	jsonStr := []byte(`{
  		"user_id": 1,
-		"transaction_id": 1,
+		"transaction_id": 5,
 		"type": "Bet",
+		"currency": "EUR",
 		"amount": 300,
 		"token": "sssss"
 	}`)
@@ -211,5 +351,170 @@ func TestTransactionHandler_TransactionNotEnoughBalance(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
-	assert.Equal(t, models.ErrorResponseModel{Error: "not enough balance"}, errorResponse)
+	assert.Equal(t, models.ErrorResponseModel{Code: "NOT_ENOUGH_BALANCE", Message: "not enough balance", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestTransactionHandler_TransactionRollback(t *testing.T) {
+	jsonStr := []byte(`{
+ 		"user_id": 2,
+		"transaction_id": 6,
+		"type": "Rollback",
+		"currency": "EUR",
+		"amount": 25,
+		"original_transaction_id": 2,
+		"token": "ddddd"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/transaction", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var transactionResponse models.TransactionResponseModel
+	if err = json.Unmarshal(resBytes, &transactionResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, models.TransactionResponseModel{Currency: "EUR", Balance: 10000}, transactionResponse)
+}
+
+func TestTransactionHandler_TransactionAlreadyRolledBack(t *testing.T) {
+	jsonStr := []byte(`{
+ 		"user_id": 2,
+		"transaction_id": 7,
+		"type": "Rollback",
+		"currency": "EUR",
+		"amount": 25,
+		"original_transaction_id": 2,
+		"token": "ddddd"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/transaction", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Equal(t, models.ErrorResponseModel{Code: "ALREADY_ROLLED_BACK", Message: "already rolled back", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestTransactionHandler_CloseRoundNotFound(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 1,
+		"token": "sssss",
+		"provider": "acme",
+		"round_id": "unknown"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/round/close", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, models.ErrorResponseModel{Code: "ROUND_NOT_FOUND", Message: "round not found", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestTransactionHandler_BatchTransaction(t *testing.T) {
+	// Transaction 1 is a replay, so the batches leave the wallets as they are.
+	transactions := `[
+		{"user_id": 1, "transaction_id": 1, "type": "Bet", "currency": "EUR", "amount": "50.00", "token": "sssss"},
+		{"user_id": 1, "transaction_id": 91, "type": "Bet", "currency": "EUR", "amount": "1000.00", "token": "sssss"}
+	]`
+
+	for _, test := range []struct {
+		mode     string
+		expected string
+	}{
+		{models.BatchBestEffort, `{"applied": 1, "results": [
+			{"transaction_id": 1, "currency": "EUR", "balance": "50.00"},
+			{"transaction_id": 91, "currency": "EUR", "code": "NOT_ENOUGH_BALANCE", "message": "not enough balance"}
+		]}`},
+		{models.BatchAllOrNothing, `{"applied": 0, "results": [
+			{"transaction_id": 1, "currency": "EUR", "code": "BATCH_ABORTED", "message": "not applied, another transaction of the batch failed"},
+			{"transaction_id": 91, "currency": "EUR", "code": "NOT_ENOUGH_BALANCE", "message": "not enough balance"}
+		]}`},
+	} {
+		jsonStr := []byte(fmt.Sprintf(`{"mode": %q, "transactions": %s}`, test.mode, transactions))
+		res, err := http.Post(fmt.Sprintf("%s/transaction/batch", srv.URL), "application/json", bytes.NewBuffer(jsonStr))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resBytes, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, http.StatusOK, res.StatusCode, test.mode)
+		assert.JSONEq(t, test.expected, string(resBytes), test.mode)
+	}
+}
+
+func TestTransactionHandler_BatchTransactionInvalid(t *testing.T) {
+	for _, body := range []string{
+		`{"mode": "best_effort", "transactions": []}`,
+		`{"mode": "some", "transactions": [{"user_id": 1, "transaction_id": 92, "type": "Win", "currency": "EUR", "amount": 25, "token": "sssss"}]}`,
+		`{"mode": "best_effort", "transactions": [{"user_id": 1, "transaction_id": 92, "type": "Win", "currency": "EUR", "amount": 25}]}`,
+		`{"mode": "best_effort", "transactions": [{"user_id": 1, "transaction_id": 92, "type": "Win", "currency": "EUR", "amount": 25, "token": "sssss", "bonus": true}]}`,
+	} {
+		res, err := http.Post(fmt.Sprintf("%s/transaction/batch", srv.URL), "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var errorResponse models.ErrorResponseModel
+		err = json.NewDecoder(res.Body).Decode(&errorResponse)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, body)
+		assert.Equal(t, services.ErrInvalidRequest.Code, errorResponse.Code, body)
+	}
 }
@@ -23,119 +23,160 @@ func NewUserHandler(service *services.UserService) *UserHandler {
 
 func (h *UserHandler) Get(w http.ResponseWriter, req *http.Request) {
 	var userRequest models.GetUserRequestModel
-	var errorResponse models.ErrorResponseModel
-	err := json.NewDecoder(req.Body).Decode(&userRequest)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		errorResponse.Error = err.Error()
-		zap.L().Error(err.Error())
+
+	w.Header().Add("Content-Type", "application/json")
+	if !decodeRequest(w, req, h.validator, &userRequest) {
+		return
 	}
 
-	err = h.validator.Struct(&userRequest)
+	userResponse, err := h.service.GetUser(userRequest)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		errorResponse.Error = err.Error()
-		zap.L().Error(err.Error())
+		writeError(w, err)
+		return
 	}
 
-	userResponse, err := h.service.GetUser(userRequest.Id, userRequest.Token)
-	if err != nil {
-		if err.Error() == "not found" {
-			w.WriteHeader(http.StatusNotFound)
-		}
-		if err.Error() == "wrong token" {
-			w.WriteHeader(http.StatusBadRequest)
-		}
-
-		errorResponse.Error = err.Error()
+	if err := json.NewEncoder(w).Encode(userResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
+}
+
+func (h *UserHandler) Create(w http.ResponseWriter, req *http.Request) {
+	var userRequest models.UserModel
 
 	w.Header().Add("Content-Type", "application/json")
-	if errorResponse.Error != "" {
-		if err := json.NewEncoder(w).Encode(errorResponse); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			zap.L().Error(err.Error())
-		}
+	if !decodeRequest(w, req, h.validator, &userRequest) {
 		return
 	}
+	recordUser(w, userRequest.Id)
 
-	if err := json.NewEncoder(w).Encode(userResponse); err != nil {
+	user := models.UserModel{Id: userRequest.Id, Wallets: userRequest.Wallets, Token: userRequest.Token}
+	if err := h.service.CreateUser(userRequest.Id, user); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(models.ErrorResponseModel{}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
 }
 
-func (h *UserHandler) Create(w http.ResponseWriter, req *http.Request) {
-	var userRequest models.UserModel
-	var res models.ErrorResponseModel
-	err := json.NewDecoder(req.Body).Decode(&userRequest)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		res.Error = err.Error()
-		zap.L().Error(err.Error())
+func (h *UserHandler) AddDeposit(w http.ResponseWriter, req *http.Request) {
+	var depositRequest models.DepositRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !decodeRequest(w, req, h.validator, &depositRequest) {
+		return
 	}
 
-	err = h.validator.Struct(&userRequest)
+	depositResponse, err := h.service.AddDeposit(depositRequest)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		res.Error = err.Error()
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(depositResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
+}
 
-	user := models.UserModel{Id: userRequest.Id, Balance: userRequest.Balance, Token: userRequest.Token}
-	h.service.CreateUser(userRequest.Id, user)
+func (h *UserHandler) SetLimit(w http.ResponseWriter, req *http.Request) {
+	var limitRequest models.SetLimitRequestModel
 
 	w.Header().Add("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(res); err != nil {
+	if !decodeRequest(w, req, h.validator, &limitRequest) {
+		return
+	}
+
+	limitResponse, err := h.service.SetLimit(limitRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(limitResponse); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
 }
 
-func (h *UserHandler) AddDeposit(w http.ResponseWriter, req *http.Request) {
-	var depositRequest models.DepositRequestModel
-	var errorResponse models.ErrorResponseModel
+func (h *UserHandler) SetStatus(w http.ResponseWriter, req *http.Request) {
+	var statusRequest models.SetStatusRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !decodeRequest(w, req, h.validator, &statusRequest) {
+		return
+	}
 
-	err := json.NewDecoder(req.Body).Decode(&depositRequest)
+	statusResponse, err := h.service.SetStatus(statusRequest)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		errorResponse.Error = err.Error()
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(statusResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
+}
+
+func (h *UserHandler) RotateToken(w http.ResponseWriter, req *http.Request) {
+	var rotateRequest models.RotateTokenRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !decodeRequest(w, req, h.validator, &rotateRequest) {
+		return
+	}
 
-	err = h.validator.Struct(&depositRequest)
+	rotateResponse, err := h.service.RotateToken(rotateRequest)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		errorResponse.Error = err.Error()
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(rotateResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
+}
 
-	depositResponse, err := h.service.AddDeposit(depositRequest)
+func (h *UserHandler) History(w http.ResponseWriter, req *http.Request) {
+	var historyRequest models.HistoryRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !decodeRequest(w, req, h.validator, &historyRequest) {
+		return
+	}
+
+	historyResponse, err := h.service.History(historyRequest)
 	if err != nil {
-		switch err.Error() {
-		case "wrong token":
-			w.WriteHeader(http.StatusBadRequest)
-		case "not found":
-			w.WriteHeader(http.StatusNotFound)
-		default:
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-
-		errorResponse.Error = err.Error()
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(historyResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
+}
+
+func (h *UserHandler) Statistics(w http.ResponseWriter, req *http.Request) {
+	var statisticRequest models.StatisticRequestModel
 
 	w.Header().Add("Content-Type", "application/json")
-	if errorResponse.Error != "" {
-		if err := json.NewEncoder(w).Encode(errorResponse); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			zap.L().Error(err.Error())
-		}
+	if !decodeRequest(w, req, h.validator, &statisticRequest) {
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(depositResponse); err != nil {
+	statisticResponse, err := h.service.Statistics(statisticRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(statisticResponse); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		zap.L().Error(err.Error())
 	}
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"guru/models"
+	"guru/services"
 	"io/ioutil"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestUserHandler_Get(t *testing.T) {
@@ -40,14 +42,20 @@ func TestUserHandler_Get(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, res.StatusCode)
 	assert.Equal(t, models.GetUserResponseModel{
-		Id:           1,
-		Balance:      75,
-		DepositCount: 2,
-		DepositSum:   200,
-		BetCount:     1,
-		BetSum:       50,
-		WinCount:     1,
-		WinSum:       25,
+		Id:     1,
+		Status: models.StatusActive,
+		Wallets: []models.WalletResponseModel{
+			{
+				Currency:     "EUR",
+				Balance:      7500,
+				DepositCount: 2,
+				DepositSum:   20000,
+				BetCount:     1,
+				BetSum:       5000,
+				WinCount:     1,
+				WinSum:       2500,
+			},
+		},
 	}, getUserResponse)
 }
 
@@ -79,7 +87,7 @@ func TestUserHandler_GetWrongToken(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
-	assert.Equal(t, models.ErrorResponseModel{Error: "wrong token"}, errorResponse)
+	assert.Equal(t, models.ErrorResponseModel{Code: "WRONG_TOKEN", Message: "wrong token", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
 }
 
 func TestUserHandler_GetNotFound(t *testing.T) {
@@ -109,13 +117,190 @@ func TestUserHandler_GetNotFound(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusNotFound, res.StatusCode)
-	assert.Equal(t, models.ErrorResponseModel{Error: "not found"}, errorResponse)
+	assert.Equal(t, models.ErrorResponseModel{Code: "NOT_FOUND", Message: "not found", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestUserHandler_History(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 1,
+		"token": "sssss",
+		"limit": 3
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/history", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var historyResponse models.HistoryResponseModel
+	if err = json.Unmarshal(resBytes, &historyResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, []models.HistoryEntryModel{
+		{Id: 4, Type: models.TypeWin, Currency: "EUR", Amount: 2500, BalanceBefore: 5000, BalanceAfter: 7500, CreatedAt: now},
+		{Id: 1, Type: models.TypeBet, Currency: "EUR", Amount: 5000, BalanceBefore: 10000, BalanceAfter: 5000, CreatedAt: transactionTime},
+		{Id: 3, Type: models.TypeDeposit, Currency: "EUR", Amount: 10000, BalanceBefore: 0, BalanceAfter: 10000, CreatedAt: depositTime},
+	}, historyResponse.Entries)
+	assert.NotEmpty(t, historyResponse.NextCursor)
+
+	jsonStr = []byte(fmt.Sprintf(`{
+		"user_id": 1,
+		"token": "sssss",
+		"limit": 3,
+		"cursor": %q
+	}`, historyResponse.NextCursor))
+
+	res, err = http.Post(
+		fmt.Sprintf("%s/user/history", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	historyResponse = models.HistoryResponseModel{}
+	if err = json.Unmarshal(resBytes, &historyResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, models.HistoryResponseModel{
+		Entries: []models.HistoryEntryModel{
+			{Id: 1, Type: models.TypeDeposit, Currency: "EUR", Amount: 10000, BalanceBefore: 0, BalanceAfter: 10000, CreatedAt: depositTime},
+		},
+	}, historyResponse)
+}
+
+func TestUserHandler_HistoryFilter(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 1,
+		"token": "sssss",
+		"types": ["Bet", "Deposit"],
+		"currency": "EUR",
+		"min_amount": "60.00"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/history", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var historyResponse models.HistoryResponseModel
+	if err = json.Unmarshal(resBytes, &historyResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, models.HistoryResponseModel{
+		Entries: []models.HistoryEntryModel{
+			{Id: 3, Type: models.TypeDeposit, Currency: "EUR", Amount: 10000, BalanceBefore: 0, BalanceAfter: 10000, CreatedAt: depositTime},
+			{Id: 1, Type: models.TypeDeposit, Currency: "EUR", Amount: 10000, BalanceBefore: 0, BalanceAfter: 10000, CreatedAt: depositTime},
+		},
+	}, historyResponse)
+
+	// Amount filters need the currency they are in.
+	res, err = http.Post(
+		fmt.Sprintf("%s/user/history", srv.URL),
+		"application/json",
+		bytes.NewBufferString(`{"user_id": 1, "token": "sssss", "min_amount": "60.00"}`),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.NewDecoder(res.Body).Decode(&errorResponse); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, "CURRENCY_REQUIRED", errorResponse.Code)
+}
+
+func TestUserHandler_Statistics(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 1,
+		"token": "sssss",
+		"from": "2020-07-11T00:00:00Z",
+		"to": "2020-07-13T00:00:00Z",
+		"granularity": "day"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/statistics", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statisticResponse models.StatisticResponseModel
+	if err = json.Unmarshal(resBytes, &statisticResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, models.StatisticResponseModel{
+		Granularity: models.GranularityDay,
+		Buckets: []models.StatisticBucketModel{
+			{
+				Start:        time.Date(2020, 7, 11, 0, 0, 0, 0, time.UTC),
+				Currency:     "EUR",
+				DepositCount: 2,
+				DepositSum:   20000,
+				BetCount:     1,
+				BetSum:       5000,
+			},
+			{
+				Start:    time.Date(2020, 7, 12, 0, 0, 0, 0, time.UTC),
+				Currency: "EUR",
+				WinCount: 1,
+				WinSum:   2500,
+			},
+		},
+	}, statisticResponse)
 }
 
 func TestUserHandler_Create(t *testing.T) {
 	jsonStr := []byte(`{
 		"id": 3,
-		"balance": 75,
+		"wallets": {"EUR": 75, "KWD": "1.250"},
 		"token": "string"
 	}`)
 
@@ -140,14 +325,52 @@ func TestUserHandler_Create(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusOK, res.StatusCode)
-	assert.Equal(t, models.ErrorResponseModel{Error: ""}, errorResponse)
+	assert.Equal(t, models.ErrorResponseModel{}, errorResponse)
+}
+
+func TestUserHandler_CreateInvalid(t *testing.T) {
+	post := func(body string) (int, models.ErrorResponseModel) {
+		res, err := http.Post(fmt.Sprintf("%s/user/create", srv.URL), "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var errorResponse models.ErrorResponseModel
+		if err = json.NewDecoder(res.Body).Decode(&errorResponse); err != nil {
+			t.Fatal(err)
+		}
+
+		return res.StatusCode, errorResponse
+	}
+
+	for _, body := range []string{
+		`{"id": 4, "wallets": {"EUR": 10}`,
+		`{"id": 4, "wallets": {"EUR": 10}, "token": "string", "status": "Closed"}`,
+		`{"id": 4, "wallets": {"EUR": 10}, "token": "string"} {}`,
+		`{"id": 4, "wallets": {"EUR": 10}}`,
+	} {
+		status, errorResponse := post(body)
+		assert.Equal(t, http.StatusBadRequest, status, body)
+		assert.Equal(t, "INVALID_REQUEST", errorResponse.Code, body)
+	}
+	_, err := service.GetUser(models.GetUserRequestModel{Id: 4, Token: "string"})
+	assert.Equal(t, services.ErrNotFound, err)
+
+	// An existing user is not overwritten.
+	status, errorResponse := post(`{"id": 3, "wallets": {"EUR": 0}, "token": "other"}`)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "USER_ALREADY_EXISTS", errorResponse.Code)
+	_, err = service.GetUser(models.GetUserRequestModel{Id: 3, Token: "other"})
+	assert.Equal(t, services.ErrWrongToken, err)
 }
 
 func TestUserHandler_AddDeposit(t *testing.T) {
 	jsonStr := []byte(`{
 		"user_id": 3,
-		"deposit_id": 1,
-		"amount": 50,
+		"deposit_id": 4,
+		"currency": "EUR",
+		"amount": "50.00",
 		"token": "string"
 	}`)
 
@@ -172,13 +395,14 @@ func TestUserHandler_AddDeposit(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusOK, res.StatusCode)
-	assert.Equal(t, models.TransactionResponseModel{Error: "", Balance: 125}, transactionResponse)
+	assert.Equal(t, models.TransactionResponseModel{Currency: "EUR", Balance: 12500}, transactionResponse)
 }
 
 func TestUserHandler_AddDepositWrongToken(t *testing.T) {
 	jsonStr := []byte(`{
 		"user_id": 3,
-		"deposit_id": 1,
+		"deposit_id": 4,
+		"currency": "EUR",
 		"amount": 50,
 		"token": "ttttt"
 	}`)
@@ -204,13 +428,14 @@ func TestUserHandler_AddDepositWrongToken(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
-	assert.Equal(t, models.ErrorResponseModel{Error: "wrong token"}, errorResponse)
+	assert.Equal(t, models.ErrorResponseModel{Code: "WRONG_TOKEN", Message: "wrong token", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
 }
 
 func TestUserHandler_AddDepositNotFound(t *testing.T) {
 	jsonStr := []byte(`{
 		"user_id": 5,
-		"deposit_id": 1,
+		"deposit_id": 4,
+		"currency": "EUR",
 		"amount": 50,
 		"token": "string"
 	}`)
@@ -236,5 +461,230 @@ func TestUserHandler_AddDepositNotFound(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusNotFound, res.StatusCode)
-	assert.Equal(t, models.ErrorResponseModel{Error: "not found"}, errorResponse)
+	assert.Equal(t, models.ErrorResponseModel{Code: "NOT_FOUND", Message: "not found", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestUserHandler_AddDepositWalletNotFound(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 1,
+		"deposit_id": 6,
+		"currency": "USD",
+		"amount": 50,
+		"token": "sssss"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/deposit", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, models.ErrorResponseModel{Code: "WALLET_NOT_FOUND", Message: "wallet not found", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestUserHandler_AddDepositZeroExponentCurrency(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 2,
+		"deposit_id": 5,
+		"currency": "JPY",
+		"amount": "500",
+		"token": "ddddd"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/deposit", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{"currency": "JPY", "balance": "1500"}`, string(resBytes))
+}
+
+func TestUserHandler_SetLimit(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 3,
+		"token": "string",
+		"currency": "EUR",
+		"type": "deposit",
+		"period": "day",
+		"amount": "60.00"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/limits", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{
+		"user_id": 3,
+		"currency": "EUR",
+		"type": "deposit",
+		"period": "day",
+		"amount": "60.00",
+		"updated_at": "2020-07-12T10:00:00Z"
+	}`, string(resBytes))
+}
+
+func TestUserHandler_AddDepositLimitExceeded(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 3,
+		"deposit_id": 7,
+		"currency": "EUR",
+		"amount": "20.00",
+		"token": "string"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/deposit", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.JSONEq(t, fmt.Sprintf(`{"code": "DEPOSIT_LIMIT_EXCEEDED", "message": "deposit limit exceeded", "request_id": "%s"}`, res.Header.Get("X-Request-Id")), string(resBytes))
+}
+
+func TestUserHandler_SetStatus(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 3,
+		"token": "string",
+		"status": "SelfExcluded",
+		"reason": "taking a break",
+		"excluded_until": "2020-08-12T10:00:00Z"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/status", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.JSONEq(t, `{
+		"user_id": 3,
+		"status": "SelfExcluded",
+		"reason": "taking a break",
+		"excluded_until": "2020-08-12T10:00:00Z",
+		"changed_at": "2020-07-12T10:00:00Z"
+	}`, string(resBytes))
+}
+
+func TestUserHandler_AddDepositSelfExcluded(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 3,
+		"deposit_id": 8,
+		"currency": "EUR",
+		"amount": "1.00",
+		"token": "string"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/deposit", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.JSONEq(t, fmt.Sprintf(`{"code": "ACCOUNT_SELF_EXCLUDED", "message": "account self-excluded", "request_id": "%s"}`, res.Header.Get("X-Request-Id")), string(resBytes))
+}
+
+func TestUserHandler_RotateToken(t *testing.T) {
+	post := func(path string, body string) (int, []byte) {
+		res, err := http.Post(fmt.Sprintf("%s%s", srv.URL, path), "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		resBytes, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return res.StatusCode, resBytes
+	}
+
+	status, resBytes := post("/user/token", `{"user_id": 3, "token": "string", "grace_period": 3600}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	var rotateResponse models.RotateTokenResponseModel
+	if err := json.Unmarshal(resBytes, &rotateResponse); err != nil {
+		t.Fatal(err)
+	}
+	previousTokenExpiresAt := now.Add(time.Hour)
+	assert.Equal(t, uint64(3), rotateResponse.UserId)
+	assert.Len(t, rotateResponse.Token, 64)
+	assert.Equal(t, &previousTokenExpiresAt, rotateResponse.PreviousTokenExpiresAt)
+
+	// The old token works during the grace period but can no longer rotate.
+	status, _ = post("/user/get", `{"id": 3, "token": "string"}`)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = post("/user/get", fmt.Sprintf(`{"id": 3, "token": "%s"}`, rotateResponse.Token))
+	assert.Equal(t, http.StatusOK, status)
+	status, resBytes = post("/user/token", `{"user_id": 3, "token": "string"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, string(resBytes), `"code":"WRONG_TOKEN","message":"wrong token"`)
 }
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+	"guru/models"
+	"guru/services"
+	"net/http"
+)
+
+const apiKeyHeader = "X-Api-Key"
+
+type WithdrawalHandler struct {
+	service   *services.UserService
+	validator *validator.Validate
+	apiKey    string
+}
+
+// NewWithdrawalHandler creates the withdrawal handler. apiKey authenticates the
+// operator or payment provider that settles withdrawals; settlement is disabled when it is empty.
+func NewWithdrawalHandler(service *services.UserService, apiKey string) *WithdrawalHandler {
+	return &WithdrawalHandler{
+		service:   service,
+		validator: validator.New(),
+		apiKey:    apiKey,
+	}
+}
+
+func (h *WithdrawalHandler) Withdraw(w http.ResponseWriter, req *http.Request) {
+	var withdrawalRequest models.WithdrawalRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	if !decodeRequest(w, req, h.validator, &withdrawalRequest) {
+		return
+	}
+
+	withdrawalResponse, err := h.service.Withdraw(withdrawalRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(withdrawalResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
+
+func (h *WithdrawalHandler) Settle(w http.ResponseWriter, req *http.Request) {
+	var settleRequest models.SettleWithdrawalRequestModel
+
+	w.Header().Add("Content-Type", "application/json")
+	key := req.Header.Get(apiKeyHeader)
+	if h.apiKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(h.apiKey)) != 1 {
+		writeError(w, services.ErrUnauthorized)
+		return
+	}
+
+	if !decodeRequest(w, req, h.validator, &settleRequest) {
+		return
+	}
+	if userId, err := h.service.WithdrawalOwner(settleRequest.WithdrawalId); err == nil {
+		recordUser(w, userId)
+	}
+
+	settleResponse, err := h.service.SettleWithdrawal(settleRequest)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(settleResponse); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		zap.L().Error(err.Error())
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"guru/models"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestWithdrawalHandler_Withdraw(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 2,
+		"withdrawal_id": 1,
+		"currency": "EUR",
+		"amount": "40.00",
+		"token": "ddddd"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/withdrawal", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var withdrawalResponse models.WithdrawalResponseModel
+	if err = json.Unmarshal(resBytes, &withdrawalResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, models.WithdrawalResponseModel{
+		Id:       1,
+		UserId:   2,
+		Currency: "EUR",
+		Amount:   4000,
+		Status:   models.WithdrawalPending,
+		Balance:  6000,
+	}, withdrawalResponse)
+}
+
+func TestWithdrawalHandler_WithdrawNotEnoughBalance(t *testing.T) {
+	jsonStr := []byte(`{
+		"user_id": 2,
+		"withdrawal_id": 2,
+		"currency": "EUR",
+		"amount": "1000.00",
+		"token": "ddddd"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/user/withdrawal", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, models.ErrorResponseModel{Code: "NOT_ENOUGH_BALANCE", Message: "not enough balance", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestWithdrawalHandler_SettleUnauthorized(t *testing.T) {
+	jsonStr := []byte(`{
+		"withdrawal_id": 1,
+		"status": "Approved"
+	}`)
+
+	res, err := http.Post(
+		fmt.Sprintf("%s/withdrawal/settle", srv.URL),
+		"application/json",
+		bytes.NewBuffer(jsonStr),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Equal(t, models.ErrorResponseModel{Code: "UNAUTHORIZED", Message: "unauthorized", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
+
+func TestWithdrawalHandler_Settle(t *testing.T) {
+	jsonStr := []byte(`{
+		"withdrawal_id": 1,
+		"status": "Approved"
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/withdrawal/settle", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", paymentApiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var withdrawalResponse models.WithdrawalResponseModel
+	if err = json.Unmarshal(resBytes, &withdrawalResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, models.WithdrawalResponseModel{
+		Id:       1,
+		UserId:   2,
+		Currency: "EUR",
+		Amount:   4000,
+		Status:   models.WithdrawalApproved,
+		Balance:  6000,
+	}, withdrawalResponse)
+}
+
+func TestWithdrawalHandler_SettleAlreadySettled(t *testing.T) {
+	jsonStr := []byte(`{
+		"withdrawal_id": 1,
+		"status": "Rejected"
+	}`)
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/withdrawal/settle", srv.URL), bytes.NewBuffer(jsonStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", paymentApiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	resBytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorResponse models.ErrorResponseModel
+	if err = json.Unmarshal(resBytes, &errorResponse); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Equal(t, models.ErrorResponseModel{Code: "WITHDRAWAL_ALREADY_SETTLED", Message: "withdrawal already settled", RequestId: res.Header.Get("X-Request-Id")}, errorResponse)
+}
@@ -6,12 +6,18 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"guru/adapters"
 	"guru/handlers"
+	"guru/models"
 	"guru/repositories"
+	"guru/rpc"
 	"guru/services"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -34,6 +40,158 @@ func main() {
 	undo := zap.ReplaceGlobals(logger)
 	defer undo()
 
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		zap.L().Warn("interrupt signal")
+		cancel()
+	}()
+
+	service := &services.UserService{}
+	reconciling := len(os.Args) > 1 && os.Args[1] == "reconcile"
+
+	consistency, exist := os.LookupEnv("CONSISTENCY")
+	transactional := exist && consistency == "transactional"
+	if !transactional {
+		service.Ticker = time.NewTicker(10 * time.Second)
+	}
+
+	strictRounds, exist := os.LookupEnv("STRICT_ROUNDS")
+	service.StrictRounds = exist && strictRounds == "true"
+
+	storage, exist := os.LookupEnv("STORAGE")
+	if exist && storage == "memory" {
+		users := repositories.NewMemoryUserRepository()
+		deposits := repositories.NewMemoryDepositRepository()
+		transactions := repositories.NewMemoryTransactionRepository()
+		withdrawals := repositories.NewMemoryWithdrawalRepository()
+		bonuses := repositories.NewMemoryBonusRepository()
+		limits := repositories.NewMemoryLimitRepository()
+		rounds := repositories.NewMemoryRoundRepository()
+		adjustments := repositories.NewMemoryAdjustmentRepository()
+		service.UserRepository = users
+		service.DepositRepository = deposits
+		service.TransactionRepository = transactions
+		service.WithdrawalRepository = withdrawals
+		service.BonusRepository = bonuses
+		service.LimitRepository = limits
+		service.RoundRepository = rounds
+		service.AdjustmentRepository = adjustments
+		service.AuditRepository = repositories.NewMemoryAuditRepository()
+		if transactional {
+			service.Ledger = repositories.NewMemoryLedgerRepository(users, deposits, transactions, withdrawals, bonuses, adjustments)
+		}
+	} else {
+		db := connectMongo()
+		// The report only reads the users, the running server hashes their plaintext tokens.
+		service.UserRepository = &repositories.MongoUserRepository{DB: db, ReadOnly: reconciling}
+		service.DepositRepository = &repositories.MongoDepositRepository{DB: db}
+		service.TransactionRepository = &repositories.MongoTransactionRepository{DB: db}
+		service.WithdrawalRepository = &repositories.MongoWithdrawalRepository{DB: db}
+		service.BonusRepository = &repositories.MongoBonusRepository{DB: db}
+		service.LimitRepository = &repositories.MongoLimitRepository{DB: db}
+		service.RoundRepository = &repositories.MongoRoundRepository{DB: db}
+		service.AdjustmentRepository = &repositories.MongoAdjustmentRepository{DB: db}
+		service.AuditRepository = &repositories.MongoAuditRepository{DB: db}
+
+		if transactional {
+			service.Ledger = &repositories.MongoLedgerRepository{DB: db}
+		} else {
+			journalPath, exist := os.LookupEnv("JOURNAL_PATH")
+			if !exist {
+				journalPath = "guru.journal"
+			}
+			openJournal := repositories.NewFileJournalRepository
+			if reconciling {
+				// The server may be running and owns the journal; the report only reads it.
+				openJournal = repositories.NewFileJournalReader
+			}
+			journal, err := openJournal(journalPath)
+			if err != nil {
+				zap.L().Fatal(err.Error())
+			}
+			defer journal.Close()
+			service.Journal = journal
+		}
+	}
+
+	if reconciling {
+		code := reconcile(service, os.Args[2:])
+		logger.Sync()
+		os.Exit(code)
+	}
+
+	r := router{
+		userHandler:        handlers.NewUserHandler(service),
+		transactionHandler: handlers.NewTransactionHandler(service),
+		withdrawalHandler:  handlers.NewWithdrawalHandler(service, os.Getenv("PAYMENT_API_KEY")),
+		adminHandler:       handlers.NewAdminHandler(service, adminKeys(os.Getenv("ADMIN_API_KEYS"), os.Getenv("ADMIN_API_KEY"))),
+		signatureVerifier:  handlers.NewSignatureVerifier(providerSecrets(os.Getenv("PROVIDER_SECRETS")), 5*time.Minute, time.Now),
+		auditTrail:         handlers.NewAuditTrail(service),
+	}
+	for _, adapter := range adapters.All() {
+		r.providerHandlers = append(r.providerHandlers, handlers.NewProviderHandler(service, adapter))
+	}
+
+	grpcPort, exist := os.LookupEnv("GRPC_PORT")
+	if !exist {
+		grpcPort = "9090"
+	}
+	listener, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		zap.L().Fatal(err.Error())
+	}
+
+	service.Run(ctx, r.InitRouter(), grpcServer{Server: rpc.NewServer(service), listener: listener})
+}
+
+// grpcServer serves the gRPC API on its listener.
+type grpcServer struct {
+	*grpc.Server
+	listener net.Listener
+}
+
+func (s grpcServer) Serve() error {
+	return s.Server.Serve(s.listener)
+}
+
+// adminKeys parses the admin API keys from a comma-separated list of name:role:key entries. The single key
+// of ADMIN_API_KEY, if set, is kept as a superuser key.
+func adminKeys(value string, superuserKey string) map[string]models.AdminKeyModel {
+	keys := make(map[string]models.AdminKeyModel)
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 || parts[2] == "" {
+			continue
+		}
+		if _, ok := models.RoleRanks[parts[1]]; !ok {
+			zap.L().Fatal("unknown admin role", zap.String("name", parts[0]), zap.String("role", parts[1]))
+		}
+		keys[parts[2]] = models.AdminKeyModel{Name: parts[0], Role: parts[1]}
+	}
+	if superuserKey != "" {
+		keys[superuserKey] = models.AdminKeyModel{Name: "admin", Role: models.RoleSuperuser}
+	}
+
+	return keys
+}
+
+// providerSecrets parses the signing secrets of the providers from a comma-separated list of provider=secret pairs.
+func providerSecrets(value string) map[string]string {
+	secrets := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) == 2 {
+			secrets[parts[0]] = parts[1]
+		}
+	}
+
+	return secrets
+}
+
+func connectMongo() *mongo.Database {
 	mongoUser, exist := os.LookupEnv("MONGO_INITDB_ROOT_USERNAME")
 	if !exist {
 		mongoUser = "mongo"
@@ -75,27 +233,5 @@ func main() {
 		os.Exit(1)
 	}
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		<-stop
-		zap.L().Warn("interrupt signal")
-		cancel()
-	}()
-
-	db := client.Database(mongoDb)
-	service := &services.UserService{
-		UserRepository:        repositories.UserRepository{DB: db},
-		DepositRepository:     repositories.DepositRepository{DB: db},
-		TransactionRepository: repositories.TransactionRepository{DB: db},
-		Ticker:                time.NewTicker(10 * time.Second),
-	}
-
-	r := router{
-		userHandler:        handlers.NewUserHandler(service),
-		transactionHandler: handlers.NewTransactionHandler(service),
-	}
-
-	service.Run(ctx, r.InitRouter())
+	return client.Database(mongoDb)
 }
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// AdjustmentModel is a manual balance correction made by an operator. Amount is signed: credits are positive
+// and debits negative. A repair moves a stored balance to the one rebuilt from the ledger by reconciliation.
+type AdjustmentModel struct {
+	Id            uint64    `json:"id" bson:"id"`
+	UserId        uint64    `json:"user_id" bson:"user_id"`
+	Currency      string    `json:"currency" bson:"currency"`
+	Amount        Money     `json:"amount" bson:"amount"`
+	Reason        string    `json:"reason" bson:"reason"`
+	Actor         string    `json:"actor" bson:"actor"`
+	Repair        bool      `json:"repair,omitempty" bson:"repair,omitempty"`
+	BalanceBefore Money     `json:"balance_before" bson:"balance_before"`
+	BalanceAfter  Money     `json:"balance_after" bson:"balance_after"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+	// Sequence numbers the ledger rows of the user in the order they changed the balance; repairs have none.
+	Sequence uint64 `json:"-" bson:"sequence,omitempty"`
+}
+
+func (a AdjustmentModel) MarshalJSON() ([]byte, error) {
+	type adjustment AdjustmentModel
+	return marshalAmounts(adjustment(a), a.Currency, amountField("amount", &a.Amount), amountField("balance_before", &a.BalanceBefore), amountField("balance_after", &a.BalanceAfter))
+}
@@ -0,0 +1,25 @@
+package models
+
+const (
+	RoleSupport   = "support"
+	RoleFinance   = "finance"
+	RoleSuperuser = "superuser"
+)
+
+// RoleRanks orders the admin roles; a role may call every endpoint open to a lower ranked one.
+var RoleRanks = map[string]int{
+	RoleSupport:   1,
+	RoleFinance:   2,
+	RoleSuperuser: 3,
+}
+
+// AdminKeyModel is the operator behind an admin API key and the role the key grants.
+type AdminKeyModel struct {
+	Name string
+	Role string
+}
+
+// Allows reports whether the role of the key is at least role.
+func (k AdminKeyModel) Allows(role string) bool {
+	return RoleRanks[k.Role] >= RoleRanks[role]
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+const (
+	CallerPlayer   = "player"
+	CallerPayment  = "payment"
+	CallerProvider = "provider"
+	CallerAdmin    = "admin"
+
+	AuditSuccess = "Success"
+	AuditFailure = "Failure"
+)
+
+// AuditModel records one call of a balance-affecting or admin endpoint with its outcome. Actor names the
+// provider or the admin key behind the call; calls rejected for a missing or unknown key have none. Status is
+// the HTTP status of the call, or the gRPC code for calls of the gRPC API, whose Endpoint is the full method name.
+// Entries form a hash chain in Sequence order: Hash covers every other field, PreviousHash included,
+// so editing, removing or reordering entries breaks the chain.
+type AuditModel struct {
+	Sequence     uint64    `json:"seq" bson:"seq"`
+	Endpoint     string    `json:"endpoint" bson:"endpoint"`
+	Caller       string    `json:"caller" bson:"caller"`
+	Actor        string    `json:"actor,omitempty" bson:"actor,omitempty"`
+	Role         string    `json:"role,omitempty" bson:"role,omitempty"`
+	UserId       uint64    `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	SourceIp     string    `json:"source_ip" bson:"source_ip"`
+	RequestId    string    `json:"request_id" bson:"request_id"`
+	PayloadHash  string    `json:"payload_hash" bson:"payload_hash"`
+	Status       int       `json:"status" bson:"status"`
+	Result       string    `json:"result" bson:"result"`
+	Error        string    `json:"error,omitempty" bson:"error,omitempty"`
+	ErrorCode    string    `json:"error_code,omitempty" bson:"error_code,omitempty"`
+	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
+	PreviousHash string    `json:"previous_hash" bson:"previous_hash"`
+	Hash         string    `json:"hash" bson:"hash"`
+}
+
+// ComputeHash returns the SHA-256 in hex of the entry without its Hash. CreatedAt is hashed in UTC and must
+// already be truncated to the millisecond precision of the storage.
+func (a AuditModel) ComputeHash() string {
+	a.Hash = ""
+	a.CreatedAt = a.CreatedAt.UTC()
+	data, _ := json.Marshal(a)
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditFilterModel selects the audit entries of one user, or of every user when UserId is zero,
+// created in [From, To) and after the AfterSequence entry.
+type AuditFilterModel struct {
+	UserId        uint64
+	From          time.Time
+	To            time.Time
+	AfterSequence uint64
+	Limit         int
+}
+
+// AuditVerificationModel reports the check of the audit hash chain; BrokenAt is the first entry that does not
+// follow from the previous one and Dropped counts the entries this instance failed to append.
+type AuditVerificationModel struct {
+	Checked  uint64 `json:"checked"`
+	Valid    bool   `json:"valid"`
+	BrokenAt uint64 `json:"broken_at,omitempty"`
+	Dropped  uint64 `json:"dropped"`
+}
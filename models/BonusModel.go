@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	BonusActive    = "Active"
+	BonusConverted = "Converted"
+	BonusExpired   = "Expired"
+)
+
+// BonusModel is a grant of bonus funds to one wallet of a user. Bets spend the bonus balance once the real
+// balance is used up and count towards the wagering target. When the target is met the remaining bonus
+// balance is converted to real money; when the bonus expires first the balance is forfeited.
+type BonusModel struct {
+	Id             uint64 `json:"id" bson:"id"`
+	UserId         uint64 `json:"user_id" bson:"user_id"`
+	Currency       string `json:"currency" bson:"currency"`
+	Amount         Money  `json:"amount" bson:"amount"`
+	Balance        Money  `json:"balance" bson:"balance"`
+	WageringTarget Money  `json:"wagering_target" bson:"wagering_target"`
+	Wagered        Money  `json:"wagered" bson:"wagered"`
+	// WageredBonus is the part of Wagered paid from the bonus balance; wins are split in the same proportion.
+	WageredBonus Money  `json:"wagered_bonus" bson:"wagered_bonus"`
+	Status       string `json:"status" bson:"status"`
+	// BalanceBefore and BalanceAfter are the real balance around the conversion of the bonus.
+	BalanceBefore Money     `json:"balance_before" bson:"balance_before"`
+	BalanceAfter  Money     `json:"balance_after" bson:"balance_after"`
+	ExpiresAt     time.Time `json:"expires_at" bson:"expires_at"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+	SettledAt     time.Time `json:"settled_at" bson:"settled_at"`
+	// Sequence numbers the ledger rows of the user in the order they changed the balance; it is set on conversion.
+	Sequence uint64 `json:"-" bson:"sequence,omitempty"`
+}
+
+// BonusResponseModel reports the balance and the wagering progress of a bonus.
+type BonusResponseModel struct {
+	Id             uint64    `json:"id"`
+	Currency       string    `json:"currency"`
+	Amount         Money     `json:"amount"`
+	Balance        Money     `json:"balance"`
+	WageringTarget Money     `json:"wagering_target"`
+	Wagered        Money     `json:"wagered"`
+	Status         string    `json:"status"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+func (r BonusResponseModel) MarshalJSON() ([]byte, error) {
+	type bonus BonusResponseModel
+	return marshalAmounts(bonus(r), r.Currency, amountField("amount", &r.Amount), amountField("balance", &r.Balance), amountField("wagering_target", &r.WageringTarget), amountField("wagered", &r.Wagered))
+}
+
+func (r *BonusResponseModel) UnmarshalJSON(data []byte) error {
+	type bonus BonusResponseModel
+	return unmarshalAmounts(data, json.Unmarshal, (*bonus)(r), func() string { return r.Currency }, amountField("amount", &r.Amount), amountField("balance", &r.Balance), amountField("wagering_target", &r.WageringTarget), amountField("wagered", &r.Wagered))
+}
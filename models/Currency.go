@@ -0,0 +1,209 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// CurrencyExponent returns the number of minor unit digits of a supported currency.
+func CurrencyExponent(currency string) (int, error) {
+	exponent, ok := CurrencyExponents[currency]
+	if !ok {
+		return 0, ErrUnknownCurrency
+	}
+
+	return exponent, nil
+}
+
+// FormatCurrency formats the amount in major units of the currency; unknown currencies use the default exponent.
+func (m Money) FormatCurrency(currency string) string {
+	exponent, err := CurrencyExponent(currency)
+	if err != nil {
+		exponent = CurrencyExponents[DefaultCurrency]
+	}
+
+	return m.Format(exponent)
+}
+
+// parseAmount decodes a JSON amount given as a decimal string or number in major units of the currency.
+// An absent or null amount decodes to zero.
+func parseAmount(data json.RawMessage, currency string) (Money, error) {
+	value := strings.Trim(string(data), `"`)
+	if value == "null" {
+		return 0, nil
+	}
+
+	return ParseAmount(value, currency)
+}
+
+// jsonAmount is an amount of a model under its JSON name. An omitted amount is left out of the JSON.
+type jsonAmount struct {
+	name   string
+	amount *Money
+	omit   bool
+}
+
+func amountField(name string, amount *Money) jsonAmount {
+	return jsonAmount{name: name, amount: amount}
+}
+
+// marshalAmounts encodes v, a model converted to a type without its MarshalJSON, with the amounts formatted in
+// major units of the currency. The amounts keep their place among the fields of v.
+func marshalAmounts(v interface{}, currency string, amounts ...jsonAmount) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	write := func(name string, value []byte) {
+		if buffer.Len() > 0 {
+			buffer.WriteByte(',')
+		}
+		key, _ := json.Marshal(name)
+		buffer.Write(key)
+		buffer.WriteByte(':')
+		buffer.Write(value)
+	}
+	format := func(amount jsonAmount) []byte {
+		value, _ := json.Marshal(amount.amount.FormatCurrency(currency))
+		return value
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	written := make([]bool, len(amounts))
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+
+		name, _ := token.(string)
+		omit := false
+		for i, amount := range amounts {
+			if amount.name == name {
+				value, omit = format(amount), amount.omit
+				written[i] = true
+			}
+		}
+		if !omit {
+			write(name, value)
+		}
+	}
+	// Amounts left out of v by omitempty are still written, unless omitted.
+	for i, amount := range amounts {
+		if !written[i] && !amount.omit {
+			write(amount.name, format(amount))
+		}
+	}
+
+	return append(append([]byte{'{'}, buffer.Bytes()...), '}'), nil
+}
+
+// unmarshalAmounts decodes data with decode into v, a pointer to a model converted to a type without its
+// UnmarshalJSON, then parses the amounts in major units of the currency of the decoded model. Names match
+// case-insensitively like the other fields; an absent amount is zero.
+func unmarshalAmounts(data []byte, decode func([]byte, interface{}) error, v interface{}, currency func() string, amounts ...jsonAmount) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	values := make([]json.RawMessage, len(amounts))
+	for i, amount := range amounts {
+		for name, value := range fields {
+			if strings.EqualFold(name, amount.name) {
+				if values[i] == nil || name == amount.name {
+					values[i] = value
+				}
+				delete(fields, name)
+			}
+		}
+	}
+
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if err := decode(rest, v); err != nil {
+		return err
+	}
+
+	for i, amount := range amounts {
+		if *amount.amount, err = parseAmount(values[i], currency()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseAmount parses a decimal amount in major units of the currency. An empty amount parses to zero.
+func ParseAmount(value string, currency string) (Money, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	exponent, err := CurrencyExponent(currency)
+	if err != nil {
+		return 0, err
+	}
+
+	return ParseMoney(value, exponent)
+}
+
+// Wallets maps a currency to the balance of the user in it. JSON amounts are in major units of each currency.
+type Wallets map[string]Money
+
+// Copy returns wallets that do not share storage with w.
+func (w Wallets) Copy() Wallets {
+	wallets := make(Wallets, len(w))
+	for currency, balance := range w {
+		wallets[currency] = balance
+	}
+
+	return wallets
+}
+
+func (w Wallets) MarshalJSON() ([]byte, error) {
+	formatted := make(map[string]string, len(w))
+	for currency, balance := range w {
+		formatted[currency] = balance.FormatCurrency(currency)
+	}
+
+	return json.Marshal(formatted)
+}
+
+func (w *Wallets) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		return nil
+	}
+
+	wallets := make(Wallets, len(raw))
+	for currency, amount := range raw {
+		if _, err := CurrencyExponent(currency); err != nil {
+			return err
+		}
+
+		balance, err := parseAmount(amount, currency)
+		if err != nil {
+			return err
+		}
+		wallets[currency] = balance
+	}
+	*w = wallets
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAmountsJSON(t *testing.T) {
+	entry := HistoryEntryModel{Id: 1, Type: TypeBet, Currency: "KWD", Amount: 1234, BalanceBefore: 5000, BalanceAfter: 3766}
+	data, err := json.Marshal(entry)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":1,"type":"Bet","currency":"KWD","amount":"1.234","balance_before":"5.000","balance_after":"3.766","created_at":"0001-01-01T00:00:00Z"}`, string(data))
+
+	var decoded HistoryEntryModel
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, entry, decoded)
+
+	// Omitted amounts are left out, amounts dropped by omitempty are still written.
+	data, err = json.Marshal(BatchTransactionResultModel{TransactionId: 1, Currency: "EUR", Balance: 500, Code: "NOT_ENOUGH_BALANCE"})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"transaction_id": 1, "currency": "EUR", "code": "NOT_ENOUGH_BALANCE"}`, string(data))
+	data, err = json.Marshal(LimitModel{Currency: "JPY", Amount: 1000, PendingAt: time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"user_id": 0, "currency": "JPY", "type": "", "period": "", "amount": "1000", "pending_amount": "0", "pending_at": "2020-07-12T10:00:00Z", "updated_at": "0001-01-01T00:00:00Z"}`, string(data))
+
+	// Requests read their amounts in their own currency and still reject unknown fields.
+	var deposit DepositRequestModel
+	assert.Nil(t, json.Unmarshal([]byte(`{"user_id": 1, "currency": "KWD", "Amount": "1.5"}`), &deposit))
+	assert.Equal(t, Money(1500), deposit.Amount)
+	assert.Equal(t, ErrInvalidMoney, json.Unmarshal([]byte(`{"user_id": 1, "currency": "EUR", "amount": "1.505"}`), &deposit))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"user_id": 1, "currency": "EUR", "amount": "1", "bonus": true}`), &deposit))
+
+	var history HistoryRequestModel
+	assert.Nil(t, json.Unmarshal([]byte(`{"user_id": 1, "currency": "JPY", "min_amount": "250"}`), &history))
+	assert.Equal(t, Money(250), history.MinAmount)
+	assert.Equal(t, ErrCurrencyRequired, json.Unmarshal([]byte(`{"user_id": 1, "min_amount": "2.50"}`), &HistoryRequestModel{}))
+}
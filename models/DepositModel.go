@@ -3,10 +3,13 @@ package models
 import "time"
 
 type DepositModel struct {
-	Id            uint64    `json:"id"`
-	UserId        uint64    `json:"user_id"`
-	Amount        float64   `json:"amount"`
-	BalanceBefore float64   `json:"balance_before"`
-	BalanceAfter  float64   `json:"balance_after"`
-	CreatedAt     time.Time `json:"created_at"`
+	Id            uint64    `json:"id" bson:"id"`
+	UserId        uint64    `json:"user_id" bson:"user_id"`
+	Currency      string    `json:"currency" bson:"currency"`
+	Amount        Money     `json:"amount" bson:"amount"`
+	BalanceBefore Money     `json:"balance_before" bson:"balance_before"`
+	BalanceAfter  Money     `json:"balance_after" bson:"balance_after"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+	// Sequence numbers the ledger rows of the user in the order they changed the balance.
+	Sequence uint64 `json:"-" bson:"sequence,omitempty"`
 }
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	TypeDeposit = "Deposit"
+	// The admin history also lists withdrawals, the release of rejected ones, adjustments and bonus conversions.
+	TypeWithdrawal        = "Withdrawal"
+	TypeWithdrawalRelease = "WithdrawalRelease"
+	TypeAdjustment        = "Adjustment"
+	TypeBonusConversion   = "BonusConversion"
+
+	HistorySourceDeposit     = "deposit"
+	HistorySourceTransaction = "transaction"
+	HistorySourceWithdrawal  = "withdrawal"
+	HistorySourceBonus       = "bonus"
+	HistorySourceAdjustment  = "adjustment"
+)
+
+var (
+	ErrInvalidCursor    = errors.New("invalid cursor")
+	ErrCurrencyRequired = errors.New("currency required")
+)
+
+// HistoryCursorModel is the position of a ledger entry. Entries are ordered by
+// CreatedAt, then Source, then Id, newest first. The admin history orders them by
+// Sequence first.
+type HistoryCursorModel struct {
+	CreatedAt time.Time `json:"t"`
+	Source    string    `json:"s"`
+	Id        uint64    `json:"i"`
+	Sequence  uint64    `json:"q,omitempty"`
+}
+
+// Follows reports whether the entry at the given position comes after the cursor.
+func (c HistoryCursorModel) Follows(createdAt time.Time, source string, id uint64) bool {
+	if !createdAt.Equal(c.CreatedAt) {
+		return createdAt.Before(c.CreatedAt)
+	}
+	if source != c.Source {
+		return source < c.Source
+	}
+
+	return id < c.Id
+}
+
+func (c HistoryCursorModel) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeHistoryCursor(value string) (*HistoryCursorModel, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor HistoryCursorModel
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+type HistoryFilterModel struct {
+	UserId    uint64
+	Currency  string
+	Types     []string
+	From      time.Time
+	To        time.Time
+	MinAmount Money
+	MaxAmount Money
+	After     *HistoryCursorModel
+	Limit     int
+}
+
+// HasType reports whether entries of the given type pass the type filter.
+func (f HistoryFilterModel) HasType(entryType string) bool {
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == entryType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type HistoryEntryModel struct {
+	Id                    uint64    `json:"id"`
+	Type                  string    `json:"type"`
+	Currency              string    `json:"currency"`
+	Amount                Money     `json:"amount"`
+	OriginalTransactionId uint64    `json:"original_transaction_id,omitempty"`
+	BalanceBefore         Money     `json:"balance_before"`
+	BalanceAfter          Money     `json:"balance_after"`
+	CreatedAt             time.Time `json:"created_at"`
+	// Sequence is the position of the entry in the ledger of the user, set in the admin history.
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+func (e HistoryEntryModel) MarshalJSON() ([]byte, error) {
+	type entry HistoryEntryModel
+	return marshalAmounts(entry(e), e.Currency, amountField("amount", &e.Amount), amountField("balance_before", &e.BalanceBefore), amountField("balance_after", &e.BalanceAfter))
+}
+
+func (e *HistoryEntryModel) UnmarshalJSON(data []byte) error {
+	type entry HistoryEntryModel
+	return unmarshalAmounts(data, json.Unmarshal, (*entry)(e), func() string { return e.Currency }, amountField("amount", &e.Amount), amountField("balance_before", &e.BalanceBefore), amountField("balance_after", &e.BalanceAfter))
+}
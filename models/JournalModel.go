@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+const (
+	JournalCreate      = "create"
+	JournalDeposit     = "deposit"
+	JournalTransaction = "transaction"
+	JournalWithdrawal  = "withdrawal"
+	JournalSettlement  = "settlement"
+	JournalRepair      = "repair"
+	JournalBonus       = "bonus"
+	JournalStatus      = "status"
+	JournalToken       = "token"
+	JournalAdjustment  = "adjustment"
+)
+
+// JournalEntryModel records the balance of one wallet of a user right after a mutation, so
+// replaying entries in sequence order restores the latest balances. Create entries carry every wallet and
+// the token hash, status entries carry the account status and token entries the rotated token hashes
+// instead of a balance.
+type JournalEntryModel struct {
+	Sequence               uint64     `json:"seq"`
+	Operation              string     `json:"op"`
+	UserId                 uint64     `json:"user_id"`
+	ReferenceId            uint64     `json:"ref,omitempty"`
+	Currency               string     `json:"currency,omitempty"`
+	Balance                Money      `json:"balance"`
+	Wallets                Wallets    `json:"wallets,omitempty"`
+	TokenHash              string     `json:"token_hash,omitempty"`
+	PreviousTokenHash      string     `json:"previous_token_hash,omitempty"`
+	PreviousTokenExpiresAt *time.Time `json:"previous_token_expires_at,omitempty"`
+	Status                 string     `json:"status,omitempty"`
+	Reason                 string     `json:"reason,omitempty"`
+	ExcludedUntil          *time.Time `json:"excluded_until,omitempty"`
+	CreatedAt              time.Time  `json:"created_at"`
+}
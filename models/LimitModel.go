@@ -0,0 +1,99 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	LimitDeposit = "deposit"
+	LimitLoss    = "loss"
+
+	LimitDay   = "day"
+	LimitWeek  = "week"
+	LimitMonth = "month"
+)
+
+// LimitPeriods lists the limit periods from the shortest rolling window to the longest.
+var LimitPeriods = []string{LimitDay, LimitWeek, LimitMonth}
+
+// LimitWindow returns the length of the rolling window of a limit period.
+func LimitWindow(period string) time.Duration {
+	switch period {
+	case LimitWeek:
+		return 7 * 24 * time.Hour
+	case LimitMonth:
+		return 30 * 24 * time.Hour
+	}
+
+	return 24 * time.Hour
+}
+
+// LimitKeyModel identifies a limit of one wallet of a user.
+type LimitKeyModel struct {
+	Currency string
+	Type     string
+	Period   string
+}
+
+// LimitModel caps the deposits or the net real money losses of a user in one currency over a rolling window.
+// A raised or removed limit keeps its Amount until PendingAt, when PendingAmount replaces it or,
+// with PendingRemoval set, the limit is dropped.
+type LimitModel struct {
+	UserId         uint64    `json:"user_id" bson:"user_id"`
+	Currency       string    `json:"currency" bson:"currency"`
+	Type           string    `json:"type" bson:"type"`
+	Period         string    `json:"period" bson:"period"`
+	Amount         Money     `json:"amount" bson:"amount"`
+	PendingAmount  Money     `json:"pending_amount,omitempty" bson:"pending_amount"`
+	PendingRemoval bool      `json:"pending_removal,omitempty" bson:"pending_removal"`
+	PendingAt      time.Time `json:"pending_at,omitempty" bson:"pending_at"`
+	UpdatedAt      time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+func (l LimitModel) Key() LimitKeyModel {
+	return LimitKeyModel{Currency: l.Currency, Type: l.Type, Period: l.Period}
+}
+
+// IsPending reports whether a raise or removal of the limit waits for its cooling-off delay.
+func (l LimitModel) IsPending() bool {
+	return !l.PendingAt.IsZero()
+}
+
+// Effective returns the limit as it stands at now, with a pending change applied once it is due.
+// It returns false when the limit has been removed.
+func (l LimitModel) Effective(now time.Time) (LimitModel, bool) {
+	if !l.IsPending() || now.Before(l.PendingAt) {
+		return l, true
+	}
+	if l.PendingRemoval {
+		return l, false
+	}
+
+	l.UpdatedAt = l.PendingAt
+	l.Amount = l.PendingAmount
+	l.PendingAmount = 0
+	l.PendingAt = time.Time{}
+
+	return l, true
+}
+
+func (l LimitModel) MarshalJSON() ([]byte, error) {
+	type limit LimitModel
+	response := struct {
+		limit
+		PendingAt *time.Time `json:"pending_at,omitempty"`
+	}{limit: limit(l)}
+	if l.IsPending() {
+		response.PendingAt = &l.PendingAt
+	}
+	pendingAmount := amountField("pending_amount", &l.PendingAmount)
+	pendingAmount.omit = !l.IsPending() || l.PendingRemoval
+
+	return marshalAmounts(response, l.Currency, amountField("amount", &l.Amount), pendingAmount)
+}
+
+func (l *LimitModel) UnmarshalJSON(data []byte) error {
+	type limit LimitModel
+	return unmarshalAmounts(data, json.Unmarshal, (*limit)(l), func() string { return l.Currency }, amountField("amount", &l.Amount), amountField("pending_amount", &l.PendingAmount))
+}
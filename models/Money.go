@@ -0,0 +1,118 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const DefaultCurrency = "EUR"
+
+// CurrencyExponents holds the number of minor unit digits for each supported currency.
+var CurrencyExponents = map[string]int{
+	"EUR": 2,
+	"USD": 2,
+	"GBP": 2,
+	"JPY": 0,
+	"KWD": 3,
+}
+
+var (
+	ErrInvalidMoney    = errors.New("invalid money amount")
+	ErrUnknownCurrency = errors.New("unknown currency")
+)
+
+// Money is an amount stored as integer minor units of its currency. It does not know its currency, so models
+// format their amounts in major units of their currency, e.g. "12.50", and a bare Money encodes as minor units.
+type Money int64
+
+func ParseMoney(value string, exponent int) (Money, error) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+
+	parts := strings.Split(value, ".")
+	if len(parts) > 2 || parts[0] == "" {
+		return 0, ErrInvalidMoney
+	}
+
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = strings.TrimRight(parts[1], "0")
+	}
+	if len(fraction) > exponent {
+		return 0, ErrInvalidMoney
+	}
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	units, err := strconv.ParseInt(parts[0]+fraction, 10, 64)
+	if err != nil || strings.ContainsAny(parts[0]+fraction, "+-") {
+		return 0, ErrInvalidMoney
+	}
+	if negative {
+		units = -units
+	}
+
+	return Money(units), nil
+}
+
+func (m Money) Format(exponent int) string {
+	units := int64(m)
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		if units == math.MinInt64 {
+			return sign + formatUnits(strconv.FormatUint(uint64(math.MaxInt64)+1, 10), exponent)
+		}
+		units = -units
+	}
+
+	return sign + formatUnits(strconv.FormatInt(units, 10), exponent)
+}
+
+// Share returns the part numerator/denominator of m, rounded towards zero. The product is computed
+// without overflow; the result fits as long as numerator does not exceed denominator.
+func (m Money) Share(numerator Money, denominator Money) Money {
+	product := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(int64(numerator)))
+
+	return Money(product.Quo(product, big.NewInt(int64(denominator))).Int64())
+}
+
+// Add returns m+n, failing with ErrInvalidMoney when the sum does not fit.
+func (m Money) Add(n Money) (Money, error) {
+	sum := m + n
+	if (n > 0 && sum < m) || (n < 0 && sum > m) {
+		return 0, ErrInvalidMoney
+	}
+
+	return sum, nil
+}
+
+// UnmarshalJSON decodes minor units.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+
+	units, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return ErrInvalidMoney
+	}
+	*m = Money(units)
+
+	return nil
+}
+
+func formatUnits(digits string, exponent int) string {
+	if exponent == 0 {
+		return digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+
+	return digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	for _, test := range []struct {
+		value    string
+		exponent int
+		expected Money
+		err      error
+	}{
+		{"12.50", 2, 1250, nil},
+		{"12.5", 2, 1250, nil},
+		{"12", 2, 1200, nil},
+		{" 12.50 ", 2, 1250, nil},
+		{"0.01", 2, 1, nil},
+		{"1000", 0, 1000, nil},
+		{"1.234", 3, 1234, nil},
+		// Trailing zeros are not precision, other excess digits are rejected rather than rounded.
+		{"12.5000", 2, 1250, nil},
+		{"12.505", 2, 0, ErrInvalidMoney},
+		{"12.999", 2, 0, ErrInvalidMoney},
+		{"1.5", 0, 0, ErrInvalidMoney},
+		{"-12.50", 2, -1250, nil},
+		{"-0.01", 2, -1, nil},
+		{"--12", 2, 0, ErrInvalidMoney},
+		{"-+12", 2, 0, ErrInvalidMoney},
+		{"+12", 2, 0, ErrInvalidMoney},
+		{"12.+5", 2, 0, ErrInvalidMoney},
+		{".50", 2, 0, ErrInvalidMoney},
+		{"-.50", 2, 0, ErrInvalidMoney},
+		{"1.2.3", 2, 0, ErrInvalidMoney},
+		{"", 2, 0, ErrInvalidMoney},
+		{"1e3", 2, 0, ErrInvalidMoney},
+		{"92233720368547758.07", 2, math.MaxInt64, nil},
+		{"-92233720368547758.07", 2, -math.MaxInt64, nil},
+		{"92233720368547758.08", 2, 0, ErrInvalidMoney},
+		{"9223372036854775808", 0, 0, ErrInvalidMoney},
+		{"100000000000000000", 2, 0, ErrInvalidMoney},
+	} {
+		parsed, err := ParseMoney(test.value, test.exponent)
+		assert.Equal(t, test.err, err, test.value)
+		assert.Equal(t, test.expected, parsed, test.value)
+	}
+}
+
+func TestMoney_Format(t *testing.T) {
+	for _, test := range []struct {
+		money    Money
+		exponent int
+		expected string
+	}{
+		{1250, 2, "12.50"},
+		{1, 2, "0.01"},
+		{0, 2, "0.00"},
+		{-1, 2, "-0.01"},
+		{-1250, 2, "-12.50"},
+		{1000, 0, "1000"},
+		{1234, 3, "1.234"},
+		{math.MaxInt64, 2, "92233720368547758.07"},
+		{math.MinInt64, 2, "-92233720368547758.08"},
+	} {
+		assert.Equal(t, test.expected, test.money.Format(test.exponent), test.expected)
+	}
+
+	// Amounts are formatted in their own currency.
+	assert.Equal(t, "1000", Money(1000).FormatCurrency("JPY"))
+	assert.Equal(t, "1.000", Money(1000).FormatCurrency("KWD"))
+	assert.Equal(t, "10.00", Money(1000).FormatCurrency("EUR"))
+}
+
+func TestMoney_Add(t *testing.T) {
+	sum, err := Money(1250).Add(-250)
+	assert.Nil(t, err)
+	assert.Equal(t, Money(1000), sum)
+
+	_, err = Money(math.MaxInt64).Add(1)
+	assert.Equal(t, ErrInvalidMoney, err)
+	_, err = Money(math.MinInt64).Add(-1)
+	assert.Equal(t, ErrInvalidMoney, err)
+}
+
+func TestMoney_JSON(t *testing.T) {
+	data, err := json.Marshal(Money(1000))
+	assert.Nil(t, err)
+	assert.Equal(t, "1000", string(data))
+
+	for _, test := range []struct {
+		data     string
+		expected Money
+		err      error
+	}{
+		{`1000`, 1000, nil},
+		{`-1000`, -1000, nil},
+		{`null`, 0, nil},
+		{`12.5`, 0, ErrInvalidMoney},
+		{`9223372036854775808`, 0, ErrInvalidMoney},
+	} {
+		var money Money
+		err := json.Unmarshal([]byte(test.data), &money)
+		if test.err == nil {
+			assert.Nil(t, err, test.data)
+		} else {
+			assert.True(t, err == test.err, test.data)
+		}
+		assert.Equal(t, test.expected, money, test.data)
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+const (
+	ProviderAuthenticate = "authenticate"
+	ProviderBalance      = "balance"
+	ProviderDebit        = "debit"
+	ProviderCredit       = "credit"
+	ProviderRollback     = "rollback"
+)
+
+// ProviderActions lists the seamless-wallet calls every provider adapter serves.
+var ProviderActions = []string{ProviderAuthenticate, ProviderBalance, ProviderDebit, ProviderCredit, ProviderRollback}
+
+// ProviderRequestModel is a seamless-wallet call of a game provider, decoded from the provider protocol by its adapter.
+// Debit, credit and rollback calls book a bet, a win and a rollback of the provider.
+type ProviderRequestModel struct {
+	Action   string `validate:"required,oneof=authenticate balance debit credit rollback"`
+	UserId   uint64 `validate:"required"`
+	Token    string `validate:"required"`
+	Currency string `validate:"required"`
+	// TransactionId identifies a debit, credit or rollback among the calls of the provider; OriginalTransactionId
+	// references the debit or credit reversed by a rollback.
+	TransactionId         string `validate:"max=64"`
+	OriginalTransactionId string `validate:"max=64"`
+	Amount                Money  `validate:"min=0"`
+	RoundId               string
+	GameId                string
+	CloseRound            bool
+}
+
+// ProviderResponseModel is the wallet state reported back to the provider after a call.
+type ProviderResponseModel struct {
+	UserId   uint64
+	Currency string
+	Balance  Money
+}
+
+// ProviderTransactionIdBase is where the wallet ids of provider transactions start. They are numbered from a
+// sequence of their own above it and found again by the provider and its id; the ids below it are left to clients.
+const ProviderTransactionIdBase uint64 = 1 << 62
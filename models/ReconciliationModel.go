@@ -0,0 +1,41 @@
+package models
+
+import "encoding/json"
+
+const (
+	DiscrepancyChainBreak      = "chain_break"
+	DiscrepancyAmountMismatch  = "amount_mismatch"
+	DiscrepancyBalanceMismatch = "balance_mismatch"
+	DiscrepancySequenceGap     = "sequence_gap"
+)
+
+// DiscrepancyModel describes one inconsistency between the ledger and the stored balance of a user wallet.
+// For ledger entries Source and EntryId identify the row; for balance mismatches Expected is the
+// balance rebuilt from the ledger and Actual is the stored one. A sequence gap reports the first row after
+// missing or repeated sequences with its Sequence.
+type DiscrepancyModel struct {
+	UserId   uint64 `json:"user_id"`
+	Kind     string `json:"kind"`
+	Currency string `json:"currency"`
+	Source   string `json:"source,omitempty"`
+	EntryId  uint64 `json:"entry_id,omitempty"`
+	Sequence uint64 `json:"sequence,omitempty"`
+	Expected Money  `json:"expected"`
+	Actual   Money  `json:"actual"`
+	Repaired bool   `json:"repaired,omitempty"`
+}
+
+type ReconciliationReportModel struct {
+	CheckedUsers  int                `json:"checked_users"`
+	Discrepancies []DiscrepancyModel `json:"discrepancies"`
+}
+
+func (d DiscrepancyModel) MarshalJSON() ([]byte, error) {
+	type discrepancy DiscrepancyModel
+	return marshalAmounts(discrepancy(d), d.Currency, amountField("expected", &d.Expected), amountField("actual", &d.Actual))
+}
+
+func (d *DiscrepancyModel) UnmarshalJSON(data []byte) error {
+	type discrepancy DiscrepancyModel
+	return unmarshalAmounts(data, json.Unmarshal, (*discrepancy)(d), func() string { return d.Currency }, amountField("expected", &d.Expected), amountField("actual", &d.Actual))
+}
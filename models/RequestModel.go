@@ -1,21 +1,262 @@
 package models
 
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
 type GetUserRequestModel struct {
-	Id    uint64 `json:"id" validate:"required"`
-	Token string `json:"token" validate:"required"`
+	Id          uint64    `json:"id" validate:"required"`
+	Token       string    `json:"token" validate:"required"`
+	From        time.Time `json:"from" validate:"required_with=Granularity"`
+	To          time.Time `json:"to"`
+	Granularity string    `json:"granularity" validate:"omitempty,oneof=hour day month"`
+}
+
+type StatisticRequestModel struct {
+	UserId      uint64    `json:"user_id" validate:"required"`
+	Token       string    `json:"token" validate:"required"`
+	From        time.Time `json:"from" validate:"required"`
+	To          time.Time `json:"to"`
+	Granularity string    `json:"granularity" validate:"required,oneof=hour day month"`
 }
 
 type DepositRequestModel struct {
-	UserId    uint64  `json:"user_id" validate:"required"`
-	DepositId uint64  `json:"deposit_id" validate:"required"`
-	Amount    float64 `json:"amount" validate:"required,min=0"`
-	Token     string  `json:"token" validate:"required"`
+	UserId    uint64 `json:"user_id" validate:"required"`
+	DepositId uint64 `json:"deposit_id" validate:"required"`
+	Currency  string `json:"currency" validate:"required"`
+	Amount    Money  `json:"amount" validate:"required,min=0"`
+	Token     string `json:"token" validate:"required"`
 }
 
 type TransactionRequestModel struct {
-	UserId        uint64  `json:"user_id" validate:"required"`
-	TransactionId uint64  `json:"transaction_id" validate:"required"`
-	Type          string  `json:"type" validate:"required"`
-	Amount        float64 `json:"amount" validate:"required,min=0"`
-	Token         string  `json:"token" validate:"required"`
+	UserId        uint64 `json:"user_id" validate:"required"`
+	TransactionId uint64 `json:"transaction_id" validate:"required"`
+	Type          string `json:"type" validate:"required,oneof=Win Bet Rollback"`
+	Currency      string `json:"currency" validate:"required"`
+	Amount        Money  `json:"amount" validate:"required,min=0"`
+	Token         string `json:"token" validate:"required"`
+	// OriginalTransactionId references the Bet or Win reversed by a Rollback.
+	OriginalTransactionId uint64 `json:"original_transaction_id"`
+	// RoundId, GameId and Provider optionally tie the transaction to a game round, which CloseRound closes after it.
+	RoundId    string `json:"round_id" validate:"required_with=CloseRound"`
+	GameId     string `json:"game_id"`
+	Provider   string `json:"provider"`
+	CloseRound bool   `json:"close_round"`
+	// ExternalId is the id of a provider call the transaction books.
+	ExternalId string `json:"-"`
+}
+
+const (
+	BatchAllOrNothing = "all_or_nothing"
+	BatchBestEffort   = "best_effort"
+)
+
+// BatchTransactionRequestModel carries an ordered list of transactions of one or many users. In the all_or_nothing
+// mode one failed transaction rejects the batch, in the best_effort mode every transaction stands on its own.
+type BatchTransactionRequestModel struct {
+	Mode         string                    `json:"mode" validate:"required,oneof=all_or_nothing best_effort"`
+	Transactions []TransactionRequestModel `json:"transactions" validate:"required,min=1,max=500,dive"`
+}
+
+// UserIds returns the distinct users of the batch in the order they first appear.
+func (r BatchTransactionRequestModel) UserIds() []uint64 {
+	var userIds []uint64
+	seen := make(map[uint64]bool)
+	for _, transaction := range r.Transactions {
+		if !seen[transaction.UserId] {
+			seen[transaction.UserId] = true
+			userIds = append(userIds, transaction.UserId)
+		}
+	}
+
+	return userIds
+}
+
+type CloseRoundRequestModel struct {
+	UserId   uint64 `json:"user_id" validate:"required"`
+	Token    string `json:"token" validate:"required"`
+	Provider string `json:"provider"`
+	RoundId  string `json:"round_id" validate:"required"`
+}
+
+type WithdrawalRequestModel struct {
+	UserId       uint64 `json:"user_id" validate:"required"`
+	WithdrawalId uint64 `json:"withdrawal_id" validate:"required"`
+	Currency     string `json:"currency" validate:"required"`
+	Amount       Money  `json:"amount" validate:"required,min=0"`
+	Token        string `json:"token" validate:"required"`
+}
+
+type SettleWithdrawalRequestModel struct {
+	WithdrawalId uint64 `json:"withdrawal_id" validate:"required"`
+	Status       string `json:"status" validate:"required,oneof=Approved Rejected"`
+}
+
+type HistoryRequestModel struct {
+	UserId   uint64    `json:"user_id" validate:"required"`
+	Token    string    `json:"token" validate:"required"`
+	Types    []string  `json:"types" validate:"dive,oneof=Deposit Bet Win Rollback"`
+	Currency string    `json:"currency"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	// MinAmount and MaxAmount are in major units of Currency, which they require.
+	MinAmount Money  `json:"min_amount" validate:"min=0"`
+	MaxAmount Money  `json:"max_amount" validate:"min=0"`
+	Cursor    string `json:"cursor"`
+	Limit     int    `json:"limit" validate:"min=0,max=100"`
+}
+
+type ReconcileRequestModel struct {
+	UserIds []uint64 `json:"user_ids"`
+	Repair  bool     `json:"repair"`
+}
+
+// GrantBonusRequestModel grants Amount of bonus funds that convert to real money once bets
+// worth WageringMultiplier times Amount have been placed before ExpiresAt.
+type GrantBonusRequestModel struct {
+	UserId             uint64    `json:"user_id" validate:"required"`
+	BonusId            uint64    `json:"bonus_id" validate:"required"`
+	Currency           string    `json:"currency" validate:"required"`
+	Amount             Money     `json:"amount" validate:"required,min=0"`
+	WageringMultiplier int64     `json:"wagering_multiplier" validate:"required,min=1,max=1000"`
+	ExpiresAt          time.Time `json:"expires_at" validate:"required"`
+}
+
+// SetLimitRequestModel sets a deposit or loss limit of a wallet, or removes it when Remove is set.
+type SetLimitRequestModel struct {
+	UserId   uint64 `json:"user_id" validate:"required"`
+	Token    string `json:"token" validate:"required"`
+	Currency string `json:"currency" validate:"required"`
+	Type     string `json:"type" validate:"required,oneof=deposit loss"`
+	Period   string `json:"period" validate:"required,oneof=day week month"`
+	Amount   Money  `json:"amount" validate:"min=0"`
+	Remove   bool   `json:"remove"`
+}
+
+// SetStatusRequestModel lets a player self-exclude until ExcludedUntil or close the account.
+type SetStatusRequestModel struct {
+	UserId        uint64    `json:"user_id" validate:"required"`
+	Token         string    `json:"token" validate:"required"`
+	Status        string    `json:"status" validate:"required,oneof=SelfExcluded Closed"`
+	Reason        string    `json:"reason" validate:"required"`
+	ExcludedUntil time.Time `json:"excluded_until"`
+}
+
+// RotateTokenRequestModel replaces the token of a player by a new random token. The old token is still accepted
+// for GracePeriod seconds, up to a day.
+type RotateTokenRequestModel struct {
+	UserId      uint64 `json:"user_id" validate:"required"`
+	Token       string `json:"token" validate:"required"`
+	GracePeriod int64  `json:"grace_period" validate:"min=0,max=86400"`
+}
+
+// ChangeStatusRequestModel moves an account to any status on behalf of the operator.
+type ChangeStatusRequestModel struct {
+	UserId        uint64    `json:"user_id" validate:"required"`
+	Status        string    `json:"status" validate:"required,oneof=Active Suspended SelfExcluded Closed"`
+	Reason        string    `json:"reason" validate:"required"`
+	ExcludedUntil time.Time `json:"excluded_until"`
+}
+
+// SearchUsersRequestModel pages through the users in id order, optionally only the given ids, the users
+// in a status or the users holding a wallet in a currency.
+type SearchUsersRequestModel struct {
+	UserIds  []uint64 `json:"user_ids"`
+	Status   string   `json:"status" validate:"omitempty,oneof=Active Suspended SelfExcluded Closed"`
+	Currency string   `json:"currency"`
+	AfterId  uint64   `json:"after_id"`
+	Limit    int      `json:"limit" validate:"min=0,max=100"`
+}
+
+type AdminUserRequestModel struct {
+	UserId uint64 `json:"user_id" validate:"required"`
+}
+
+// AdminHistoryRequestModel is HistoryRequestModel on behalf of the operator, without the user token and
+// over every balance change of the user.
+type AdminHistoryRequestModel struct {
+	UserId   uint64    `json:"user_id" validate:"required"`
+	Types    []string  `json:"types" validate:"dive,oneof=Deposit Bet Win Rollback Withdrawal WithdrawalRelease Adjustment BonusConversion"`
+	Currency string    `json:"currency"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Cursor   string    `json:"cursor"`
+	Limit    int       `json:"limit" validate:"min=0,max=100"`
+}
+
+// AdjustBalanceRequestModel credits a positive or debits a negative Amount to a wallet by hand.
+// AdjustmentId makes retries safe.
+type AdjustBalanceRequestModel struct {
+	UserId       uint64 `json:"user_id" validate:"required"`
+	AdjustmentId uint64 `json:"adjustment_id" validate:"required"`
+	Currency     string `json:"currency" validate:"required"`
+	Amount       Money  `json:"amount" validate:"required"`
+	Reason       string `json:"reason" validate:"required"`
+}
+
+// FreezeRequestModel suspends an account on behalf of the operator.
+type FreezeRequestModel struct {
+	UserId uint64 `json:"user_id" validate:"required"`
+	Reason string `json:"reason" validate:"required"`
+}
+
+// SearchAuditRequestModel pages through the audit trail of one user, or of every user when UserId is empty,
+// optionally only the entries created in [From, To).
+type SearchAuditRequestModel struct {
+	UserId        uint64    `json:"user_id"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	AfterSequence uint64    `json:"after_seq"`
+	Limit         int       `json:"limit" validate:"min=0,max=100"`
+}
+
+// unmarshalRequest decodes a request that unmarshals itself, rejecting unknown fields: the decoder of the
+// caller does not pass DisallowUnknownFields on to UnmarshalJSON.
+func unmarshalRequest(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+func (r *DepositRequestModel) UnmarshalJSON(data []byte) error {
+	type deposit DepositRequestModel
+	return unmarshalAmounts(data, unmarshalRequest, (*deposit)(r), func() string { return r.Currency }, amountField("amount", &r.Amount))
+}
+
+func (r *TransactionRequestModel) UnmarshalJSON(data []byte) error {
+	type transaction TransactionRequestModel
+	return unmarshalAmounts(data, unmarshalRequest, (*transaction)(r), func() string { return r.Currency }, amountField("amount", &r.Amount))
+}
+
+func (r *WithdrawalRequestModel) UnmarshalJSON(data []byte) error {
+	type withdrawal WithdrawalRequestModel
+	return unmarshalAmounts(data, unmarshalRequest, (*withdrawal)(r), func() string { return r.Currency }, amountField("amount", &r.Amount))
+}
+
+func (r *HistoryRequestModel) UnmarshalJSON(data []byte) error {
+	type history HistoryRequestModel
+	err := unmarshalAmounts(data, unmarshalRequest, (*history)(r), func() string { return r.Currency }, amountField("min_amount", &r.MinAmount), amountField("max_amount", &r.MaxAmount))
+	if err == ErrUnknownCurrency && r.Currency == "" {
+		return ErrCurrencyRequired
+	}
+
+	return err
+}
+
+func (r *GrantBonusRequestModel) UnmarshalJSON(data []byte) error {
+	type grant GrantBonusRequestModel
+	return unmarshalAmounts(data, unmarshalRequest, (*grant)(r), func() string { return r.Currency }, amountField("amount", &r.Amount))
+}
+
+func (r *SetLimitRequestModel) UnmarshalJSON(data []byte) error {
+	type limit SetLimitRequestModel
+	return unmarshalAmounts(data, unmarshalRequest, (*limit)(r), func() string { return r.Currency }, amountField("amount", &r.Amount))
+}
+
+func (r *AdjustBalanceRequestModel) UnmarshalJSON(data []byte) error {
+	type adjustment AdjustBalanceRequestModel
+	return unmarshalAmounts(data, unmarshalRequest, (*adjustment)(r), func() string { return r.Currency }, amountField("amount", &r.Amount))
 }
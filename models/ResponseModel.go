@@ -1,21 +1,153 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
+// ErrorResponseModel is the body of every error response. Code is a stable machine code of the error and
+// RequestId the id of the call it answers.
 type ErrorResponseModel struct {
-	Error string `json:"error"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestId string `json:"request_id,omitempty"`
 }
 
 type GetUserResponseModel struct {
-	Id           uint64  `json:"id"`
-	Balance      float64 `json:"balance"`
-	DepositCount int     `json:"deposit_count"`
-	DepositSum   float64 `json:"deposit_sum"`
-	BetCount     int     `json:"bet_count"`
-	BetSum       float64 `json:"bet_sum"`
-	WinCount     int     `json:"win_count"`
-	WinSum       float64 `json:"win_sum"`
+	Id            uint64                 `json:"id"`
+	Status        string                 `json:"status"`
+	ExcludedUntil *time.Time             `json:"excluded_until,omitempty"`
+	Wallets       []WalletResponseModel  `json:"wallets"`
+	Limits        []LimitModel           `json:"limits,omitempty"`
+	Buckets       []StatisticBucketModel `json:"buckets,omitempty"`
+}
+
+type SearchUsersResponseModel struct {
+	Users []GetUserResponseModel `json:"users"`
+	// NextAfterId continues the search on the next page; it is empty on the last page.
+	NextAfterId uint64 `json:"next_after_id,omitempty"`
+}
+
+type SearchAuditResponseModel struct {
+	Entries []AuditModel `json:"entries"`
+	// NextAfterSequence continues the search on the next page; it is empty on the last page.
+	NextAfterSequence uint64 `json:"next_after_seq,omitempty"`
+}
+
+// StatusResponseModel reports the account status in force and why it was set.
+type StatusResponseModel struct {
+	UserId        uint64     `json:"user_id"`
+	Status        string     `json:"status"`
+	Reason        string     `json:"reason"`
+	ExcludedUntil *time.Time `json:"excluded_until,omitempty"`
+	ChangedAt     time.Time  `json:"changed_at"`
+}
+
+// RotateTokenResponseModel hands out the new token of a player; only its hash is kept.
+type RotateTokenResponseModel struct {
+	UserId                 uint64     `json:"user_id"`
+	Token                  string     `json:"token"`
+	PreviousTokenExpiresAt *time.Time `json:"previous_token_expires_at,omitempty"`
+}
+
+// WalletResponseModel reports the balance and the statistic of one currency wallet.
+type WalletResponseModel struct {
+	Currency             string `json:"currency"`
+	Balance              Money  `json:"balance"`
+	DepositCount         int    `json:"deposit_count"`
+	DepositSum           Money  `json:"deposit_sum"`
+	BetCount             int    `json:"bet_count"`
+	BetSum               Money  `json:"bet_sum"`
+	WinCount             int    `json:"win_count"`
+	WinSum               Money  `json:"win_sum"`
+	WithdrawalCount      int    `json:"withdrawal_count"`
+	WithdrawalSum        Money  `json:"withdrawal_sum"`
+	PendingWithdrawalSum Money  `json:"pending_withdrawal_sum"`
+	// Bonus is the active bonus of the wallet, if any.
+	Bonus *BonusResponseModel `json:"bonus,omitempty"`
 }
 
 type TransactionResponseModel struct {
-	Error   string  `json:"error"`
-	Balance float64 `json:"balance"`
+	Currency string `json:"currency"`
+	Balance  Money  `json:"balance"`
+}
+
+// BatchTransactionResponseModel reports the transactions of a batch in request order; Applied counts those applied.
+type BatchTransactionResponseModel struct {
+	Applied int                           `json:"applied"`
+	Results []BatchTransactionResultModel `json:"results"`
+}
+
+// BatchTransactionResultModel is the balance of the wallet after an applied transaction, or the error of a
+// transaction that was not applied.
+type BatchTransactionResultModel struct {
+	TransactionId uint64 `json:"transaction_id"`
+	Currency      string `json:"currency"`
+	Balance       Money  `json:"balance"`
+	Code          string `json:"code,omitempty"`
+	Message       string `json:"message,omitempty"`
+}
+
+type WithdrawalResponseModel struct {
+	Id       uint64 `json:"id"`
+	UserId   uint64 `json:"user_id"`
+	Currency string `json:"currency"`
+	Amount   Money  `json:"amount"`
+	Status   string `json:"status"`
+	Balance  Money  `json:"balance"`
+}
+
+type HistoryResponseModel struct {
+	Entries    []HistoryEntryModel `json:"entries"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type StatisticResponseModel struct {
+	Granularity string                 `json:"granularity"`
+	Buckets     []StatisticBucketModel `json:"buckets"`
+	Games       []GameStatisticModel   `json:"games,omitempty"`
+	Providers   []GameStatisticModel   `json:"providers,omitempty"`
+}
+
+func (r WalletResponseModel) MarshalJSON() ([]byte, error) {
+	type wallet WalletResponseModel
+	return marshalAmounts(wallet(r), r.Currency, amountField("balance", &r.Balance), amountField("deposit_sum", &r.DepositSum), amountField("bet_sum", &r.BetSum), amountField("win_sum", &r.WinSum), amountField("withdrawal_sum", &r.WithdrawalSum), amountField("pending_withdrawal_sum", &r.PendingWithdrawalSum))
+}
+
+func (r *WalletResponseModel) UnmarshalJSON(data []byte) error {
+	type wallet WalletResponseModel
+	return unmarshalAmounts(data, json.Unmarshal, (*wallet)(r), func() string { return r.Currency }, amountField("balance", &r.Balance), amountField("deposit_sum", &r.DepositSum), amountField("bet_sum", &r.BetSum), amountField("win_sum", &r.WinSum), amountField("withdrawal_sum", &r.WithdrawalSum), amountField("pending_withdrawal_sum", &r.PendingWithdrawalSum))
+}
+
+func (r TransactionResponseModel) MarshalJSON() ([]byte, error) {
+	type transactionResponse TransactionResponseModel
+	return marshalAmounts(transactionResponse(r), r.Currency, amountField("balance", &r.Balance))
+}
+
+func (r *TransactionResponseModel) UnmarshalJSON(data []byte) error {
+	type transactionResponse TransactionResponseModel
+	return unmarshalAmounts(data, json.Unmarshal, (*transactionResponse)(r), func() string { return r.Currency }, amountField("balance", &r.Balance))
+}
+
+func (r BatchTransactionResultModel) MarshalJSON() ([]byte, error) {
+	type batchTransactionResult BatchTransactionResultModel
+	balance := amountField("balance", &r.Balance)
+	balance.omit = r.Code != ""
+
+	return marshalAmounts(batchTransactionResult(r), r.Currency, balance)
+}
+
+func (r *BatchTransactionResultModel) UnmarshalJSON(data []byte) error {
+	type batchTransactionResult BatchTransactionResultModel
+	return unmarshalAmounts(data, json.Unmarshal, (*batchTransactionResult)(r), func() string { return r.Currency }, amountField("balance", &r.Balance))
+}
+
+func (r WithdrawalResponseModel) MarshalJSON() ([]byte, error) {
+	type withdrawalResponse WithdrawalResponseModel
+	return marshalAmounts(withdrawalResponse(r), r.Currency, amountField("amount", &r.Amount), amountField("balance", &r.Balance))
+}
+
+func (r *WithdrawalResponseModel) UnmarshalJSON(data []byte) error {
+	type withdrawalResponse WithdrawalResponseModel
+	return unmarshalAmounts(data, json.Unmarshal, (*withdrawalResponse)(r), func() string { return r.Currency }, amountField("amount", &r.Amount), amountField("balance", &r.Balance))
 }
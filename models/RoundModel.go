@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+const (
+	RoundOpen   = "Open"
+	RoundClosed = "Closed"
+)
+
+// RoundModel is a game round of a user at a provider. The first transaction of the round opens it
+// and a transaction with CloseRound set, or a close request, closes it.
+type RoundModel struct {
+	Provider  string    `json:"provider" bson:"provider"`
+	RoundId   string    `json:"round_id" bson:"round_id"`
+	UserId    uint64    `json:"user_id" bson:"user_id"`
+	GameId    string    `json:"game_id,omitempty" bson:"game_id,omitempty"`
+	Currency  string    `json:"currency" bson:"currency"`
+	Status    string    `json:"status" bson:"status"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	ClosedAt  time.Time `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+}
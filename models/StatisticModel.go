@@ -1,11 +1,116 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	GranularityHour  = "hour"
+	GranularityDay   = "day"
+	GranularityMonth = "month"
+)
+
+// StatisticKeyModel identifies the statistic of one wallet of a user.
+type StatisticKeyModel struct {
+	UserId   uint64 `bson:"user_id"`
+	Currency string `bson:"currency"`
+}
+
 type StatisticModel struct {
-	Id           uint64  `bson:"_id"`
-	DepositCount int     `bson:"deposit_count"`
-	DepositSum   float64 `bson:"deposit_sum"`
-	BetCount     int     `bson:"bet_count"`
-	BetSum       float64 `bson:"bet_sum"`
-	WinCount     int     `bson:"win_count"`
-	WinSum       float64 `bson:"win_sum"`
+	Id                   StatisticKeyModel `bson:"_id"`
+	DepositCount         int               `bson:"deposit_count"`
+	DepositSum           Money             `bson:"deposit_sum"`
+	BetCount             int               `bson:"bet_count"`
+	BetSum               Money             `bson:"bet_sum"`
+	WinCount             int               `bson:"win_count"`
+	WinSum               Money             `bson:"win_sum"`
+	WithdrawalCount      int               `bson:"withdrawal_count"`
+	WithdrawalSum        Money             `bson:"withdrawal_sum"`
+	PendingWithdrawalSum Money             `bson:"pending_withdrawal_sum"`
+}
+
+// StatisticBucketKeyModel identifies a time bucket of one currency by the Unix time of its start.
+type StatisticBucketKeyModel struct {
+	Start    int64
+	Currency string
+}
+
+// StatisticBucketModel holds the activity of one user in one currency within a single time bucket starting at Start.
+type StatisticBucketModel struct {
+	Start        time.Time `json:"start" bson:"start"`
+	Currency     string    `json:"currency" bson:"currency"`
+	DepositCount int       `json:"deposit_count" bson:"deposit_count"`
+	DepositSum   Money     `json:"deposit_sum" bson:"deposit_sum"`
+	BetCount     int       `json:"bet_count" bson:"bet_count"`
+	BetSum       Money     `json:"bet_sum" bson:"bet_sum"`
+	WinCount     int       `json:"win_count" bson:"win_count"`
+	WinSum       Money     `json:"win_sum" bson:"win_sum"`
+}
+
+func (b StatisticBucketModel) Key() StatisticBucketKeyModel {
+	return StatisticBucketKeyModel{Start: b.Start.Unix(), Currency: b.Currency}
+}
+
+func (b StatisticBucketModel) MarshalJSON() ([]byte, error) {
+	type bucket StatisticBucketModel
+	return marshalAmounts(bucket(b), b.Currency, amountField("deposit_sum", &b.DepositSum), amountField("bet_sum", &b.BetSum), amountField("win_sum", &b.WinSum))
+}
+
+func (b *StatisticBucketModel) UnmarshalJSON(data []byte) error {
+	type bucket StatisticBucketModel
+	return unmarshalAmounts(data, json.Unmarshal, (*bucket)(b), func() string { return b.Currency }, amountField("deposit_sum", &b.DepositSum), amountField("bet_sum", &b.BetSum), amountField("win_sum", &b.WinSum))
+}
+
+// GameStatisticKeyModel identifies the activity of a user in one currency on one game of a provider.
+type GameStatisticKeyModel struct {
+	Provider string
+	GameId   string
+	Currency string
+}
+
+// GameStatisticModel holds the bets and wins of a user in one currency on one game of a provider.
+// Provider totals leave GameId empty.
+type GameStatisticModel struct {
+	Provider string `json:"provider" bson:"provider"`
+	GameId   string `json:"game_id,omitempty" bson:"game_id"`
+	Currency string `json:"currency" bson:"currency"`
+	BetCount int    `json:"bet_count" bson:"bet_count"`
+	BetSum   Money  `json:"bet_sum" bson:"bet_sum"`
+	WinCount int    `json:"win_count" bson:"win_count"`
+	WinSum   Money  `json:"win_sum" bson:"win_sum"`
+}
+
+func (g GameStatisticModel) Key() GameStatisticKeyModel {
+	return GameStatisticKeyModel{Provider: g.Provider, GameId: g.GameId, Currency: g.Currency}
+}
+
+func (g GameStatisticModel) MarshalJSON() ([]byte, error) {
+	type game GameStatisticModel
+	return marshalAmounts(game(g), g.Currency, amountField("bet_sum", &g.BetSum), amountField("win_sum", &g.WinSum))
+}
+
+func (g *GameStatisticModel) UnmarshalJSON(data []byte) error {
+	type game GameStatisticModel
+	return unmarshalAmounts(data, json.Unmarshal, (*game)(g), func() string { return g.Currency }, amountField("bet_sum", &g.BetSum), amountField("win_sum", &g.WinSum))
+}
+
+type StatisticFilterModel struct {
+	UserId      uint64
+	From        time.Time
+	To          time.Time
+	Granularity string
+}
+
+// TruncateTime returns the start of the UTC bucket of the given granularity containing t.
+func TruncateTime(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	switch granularity {
+	case GranularityHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.UTC)
+	case GranularityDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
 }
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+const tokenSaltSize = 16
+
+// NewToken returns a random token of 32 bytes in hex.
+func NewToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
+
+// NewTokenHash returns the salted hash of a token as "{salt}${hash}" in hex. Tokens are checked on every call,
+// so the hash is a single HMAC-SHA256 keyed by a random salt rather than a slow password hash.
+func NewTokenHash(token string) (string, error) {
+	salt := make([]byte, tokenSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(salt) + "$" + hex.EncodeToString(tokenMac(salt, token)), nil
+}
+
+// MatchTokenHash reports whether token hashes to tokenHash, comparing in constant time.
+func MatchTokenHash(tokenHash string, token string) bool {
+	parts := strings.SplitN(tokenHash, "$", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	hash, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(hash, tokenMac(salt, token))
+}
+
+func tokenMac(salt []byte, token string) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(token))
+	return mac.Sum(nil)
+}
+
+// HashToken replaces a plaintext Token by its hash in TokenHash. Users without a plaintext token are unchanged.
+func (u *UserModel) HashToken() error {
+	if u.Token == "" {
+		return nil
+	}
+
+	tokenHash, err := NewTokenHash(u.Token)
+	if err != nil {
+		return err
+	}
+	u.TokenHash = tokenHash
+	u.Token = ""
+
+	return nil
+}
+
+// Authenticate reports whether token is the token of the user, or the token it replaced while
+// the grace period of the rotation lasts at now.
+func (u *UserModel) Authenticate(token string, now time.Time) bool {
+	if MatchTokenHash(u.TokenHash, token) {
+		return true
+	}
+
+	return u.PreviousTokenHash != "" && now.Before(u.PreviousTokenExpiresAt) && MatchTokenHash(u.PreviousTokenHash, token)
+}
@@ -3,16 +3,31 @@ package models
 import "time"
 
 const (
-	TypeWin = "Win"
-	TypeBet = "Bet"
+	TypeWin      = "Win"
+	TypeBet      = "Bet"
+	TypeRollback = "Rollback"
 )
 
+// TransactionModel is a Bet, Win or Rollback. BonusId is the bonus that was active when it was made and
+// BonusAmount the part of Amount paid from or credited to that bonus; the rest moved the real balance.
+// RoundId, GameId and Provider tie the transaction to a game round. ExternalId is the id the provider booked
+// the transaction under, unique per provider.
 type TransactionModel struct {
-	Id            uint64    `json:"id"`
-	UserId        uint64    `json:"user_id"`
-	Amount        float64   `json:"amount"`
-	Type          string    `json:"type"`
-	BalanceBefore float64   `json:"balance_before"`
-	BalanceAfter  float64   `json:"balance_after"`
-	CreatedAt     time.Time `json:"created_at"`
+	Id                    uint64    `json:"id" bson:"id"`
+	UserId                uint64    `json:"user_id" bson:"user_id"`
+	Currency              string    `json:"currency" bson:"currency"`
+	Amount                Money     `json:"amount" bson:"amount"`
+	Type                  string    `json:"type" bson:"type"`
+	OriginalTransactionId uint64    `json:"original_transaction_id,omitempty" bson:"original_transaction_id,omitempty"`
+	BonusId               uint64    `json:"bonus_id,omitempty" bson:"bonus_id,omitempty"`
+	BonusAmount           Money     `json:"bonus_amount,omitempty" bson:"bonus_amount,omitempty"`
+	RoundId               string    `json:"round_id,omitempty" bson:"round_id,omitempty"`
+	GameId                string    `json:"game_id,omitempty" bson:"game_id,omitempty"`
+	Provider              string    `json:"provider,omitempty" bson:"provider,omitempty"`
+	ExternalId            string    `json:"external_id,omitempty" bson:"external_id,omitempty"`
+	BalanceBefore         Money     `json:"balance_before" bson:"balance_before"`
+	BalanceAfter          Money     `json:"balance_after" bson:"balance_after"`
+	CreatedAt             time.Time `json:"created_at" bson:"created_at"`
+	// Sequence numbers the ledger rows of the user in the order they changed the balance.
+	Sequence uint64 `json:"-" bson:"sequence,omitempty"`
 }
@@ -1,13 +1,49 @@
 package models
 
+import "time"
+
+const (
+	StatusActive       = "Active"
+	StatusSuspended    = "Suspended"
+	StatusSelfExcluded = "SelfExcluded"
+	StatusClosed       = "Closed"
+)
+
 const (
-	StatusNew      = "New"
-	StatusModified = "Modified"
+	SyncNew      = "New"
+	SyncModified = "Modified"
+	SyncSaved    = "Saved"
 )
 
 type UserModel struct {
-	Id      uint64  `json:"id" validate:"required"`
-	Balance float64 `json:"balance" validate:"min=0"`
-	Token   string  `json:"token" validate:"required"`
-	Status  string
+	Id      uint64  `json:"id" bson:"id" validate:"required"`
+	Wallets Wallets `json:"wallets" bson:"wallets" validate:"required,min=1,dive,min=0"`
+	// Token is the plaintext token of a new user; stored users keep only its hash in TokenHash. PreviousTokenHash
+	// is still accepted until PreviousTokenExpiresAt after a rotation.
+	Token                  string    `json:"token" bson:"token,omitempty" validate:"required"`
+	TokenHash              string    `json:"-" bson:"token_hash"`
+	PreviousTokenHash      string    `json:"-" bson:"previous_token_hash"`
+	PreviousTokenExpiresAt time.Time `json:"-" bson:"previous_token_expires_at"`
+	// Status is the account state; ExcludedUntil ends a self-exclusion.
+	Status          string    `json:"-" bson:"status"`
+	StatusReason    string    `json:"-" bson:"status_reason"`
+	ExcludedUntil   time.Time `json:"-" bson:"excluded_until"`
+	StatusChangedAt time.Time `json:"-" bson:"status_changed_at"`
+	// OpeningWallets are the balances the user was created with, where the ledger of each wallet starts.
+	OpeningWallets Wallets `json:"-" bson:"opening_wallets,omitempty"`
+	// Sync tells whether the user differs from its stored copy; it is not stored.
+	Sync string `json:"-" bson:"-"`
+}
+
+// AccountStatus returns the status in force at now. A self-exclusion lapses at ExcludedUntil,
+// and users stored before account statuses existed are active.
+func (u *UserModel) AccountStatus(now time.Time) string {
+	switch {
+	case u.Status == "":
+		return StatusActive
+	case u.Status == StatusSelfExcluded && !now.Before(u.ExcludedUntil):
+		return StatusActive
+	}
+
+	return u.Status
 }
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+const (
+	WithdrawalPending  = "Pending"
+	WithdrawalApproved = "Approved"
+	WithdrawalRejected = "Rejected"
+)
+
+type WithdrawalModel struct {
+	Id            uint64    `json:"id" bson:"id"`
+	UserId        uint64    `json:"user_id" bson:"user_id"`
+	Currency      string    `json:"currency" bson:"currency"`
+	Amount        Money     `json:"amount" bson:"amount"`
+	Status        string    `json:"status" bson:"status"`
+	BalanceBefore Money     `json:"balance_before" bson:"balance_before"`
+	BalanceAfter  Money     `json:"balance_after" bson:"balance_after"`
+	CreatedAt     time.Time `json:"created_at" bson:"created_at"`
+	SettledAt     time.Time `json:"settled_at" bson:"settled_at"`
+	// Sequence numbers the ledger rows of the user in the order they changed the balance; a rejection
+	// releasing the hold takes ReleaseSequence.
+	Sequence        uint64 `json:"-" bson:"sequence,omitempty"`
+	ReleaseSequence uint64 `json:"-" bson:"release_sequence,omitempty"`
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"go.uber.org/zap"
+	"guru/models"
+	"guru/services"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// reconcile runs the ledger reconciliation once and prints the report as JSON.
+// It returns the process exit code: 0 when the ledger matches the stored
+// balances, 1 when discrepancies were found and 2 on failure. It only reports
+// and loads the service without writing to the database: a running server
+// holds the balances and the journal, so repairs go through POST
+// /admin/reconcile of that server.
+func reconcile(service *services.UserService, args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	users := flags.String("users", "", "comma separated user ids, all users when empty")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var reconcileRequest models.ReconcileRequestModel
+	for _, value := range strings.Split(*users, ",") {
+		if value == "" {
+			continue
+		}
+
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			zap.L().Error("invalid user id", zap.String("id", value))
+			return 2
+		}
+		reconcileRequest.UserIds = append(reconcileRequest.UserIds, id)
+	}
+
+	if err := service.Load(); err != nil {
+		zap.L().Error(err.Error())
+		return 2
+	}
+
+	report, err := service.Reconcile(reconcileRequest)
+	if err != nil {
+		zap.L().Error(err.Error())
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		zap.L().Error(err.Error())
+		return 2
+	}
+
+	if len(report.Discrepancies) > 0 {
+		return 1
+	}
+
+	return 0
+}
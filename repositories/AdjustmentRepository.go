@@ -0,0 +1,97 @@
+package repositories
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"guru/models"
+	"time"
+)
+
+const adjustmentCollection = "adjustment"
+
+// AdjustmentRepository stores the adjustments of operators and the repairs of reconciliation. Repairs have ids of
+// their own, apart from the ids operators choose.
+type AdjustmentRepository interface {
+	// FindById finds the adjustment of an operator.
+	FindById(id uint64) (*models.AdjustmentModel, error)
+	FindByUserId(userId uint64) ([]models.AdjustmentModel, error)
+	// FindLastSequences raises the last ledger sequence of every user in sequences to the highest of its rows.
+	FindLastSequences(sequences map[uint64]uint64) error
+	// FindLastRepairId returns the highest id of the repairs, 0 when there are none.
+	FindLastRepairId() (uint64, error)
+	Insert(adjustmentModel models.AdjustmentModel) error
+}
+
+type MongoAdjustmentRepository struct {
+	DB *mongo.Database
+}
+
+func (r *MongoAdjustmentRepository) FindById(id uint64) (*models.AdjustmentModel, error) {
+	collection := r.DB.Collection(adjustmentCollection)
+
+	var result models.AdjustmentModel
+	err := collection.FindOne(context.TODO(), bson.M{"id": id, "repair": bson.M{"$ne": true}}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *MongoAdjustmentRepository) FindByUserId(userId uint64) ([]models.AdjustmentModel, error) {
+	collection := r.DB.Collection(adjustmentCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make([]models.AdjustmentModel, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *MongoAdjustmentRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	return findLastSequences(r.DB.Collection(adjustmentCollection), "$sequence", sequences)
+}
+
+func (r *MongoAdjustmentRepository) FindLastRepairId() (uint64, error) {
+	collection := r.DB.Collection(adjustmentCollection)
+
+	var result models.AdjustmentModel
+	findOptions := options.FindOne().SetSort(bson.M{"id": -1})
+	err := collection.FindOne(context.TODO(), bson.M{"repair": true}, findOptions).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Id, nil
+}
+
+func (r *MongoAdjustmentRepository) Insert(adjustmentModel models.AdjustmentModel) error {
+	collection := r.DB.Collection(adjustmentCollection)
+
+	_, err := collection.InsertOne(context.TODO(), adjustmentModel)
+	if isDuplicateKey(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"guru/models"
+	"time"
+)
+
+const auditCollection = "audit"
+
+// AuditRepository stores the audit trail. It is append-only: entries are never updated or deleted.
+type AuditRepository interface {
+	// FindLast returns the entry with the highest sequence, or ErrNotFound when the trail is empty.
+	FindLast() (*models.AuditModel, error)
+	// Find returns the entries matching the filter in sequence order.
+	Find(filter models.AuditFilterModel) ([]models.AuditModel, error)
+	// Insert fails with ErrDuplicate when the sequence is taken.
+	Insert(auditModel models.AuditModel) error
+}
+
+type MongoAuditRepository struct {
+	DB *mongo.Database
+}
+
+func (r *MongoAuditRepository) FindLast() (*models.AuditModel, error) {
+	collection := r.DB.Collection(auditCollection)
+
+	var result models.AuditModel
+	err := collection.FindOne(context.TODO(), bson.M{"seq": bson.M{"$gt": 0}}, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *MongoAuditRepository) Find(filter models.AuditFilterModel) ([]models.AuditModel, error) {
+	collection := r.DB.Collection(auditCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := bson.M{"seq": bson.M{"$gt": filter.AfterSequence}}
+	if filter.UserId != 0 {
+		query["user_id"] = filter.UserId
+	}
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lt"] = filter.To
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	cur, err := collection.Find(ctx, query, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(int64(filter.Limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make([]models.AuditModel, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *MongoAuditRepository) Insert(auditModel models.AuditModel) error {
+	collection := r.DB.Collection(auditCollection)
+
+	_, err := collection.InsertOne(context.TODO(), auditModel)
+	if isDuplicateKey(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"guru/models"
+	"time"
+)
+
+const bonusCollection = "bonus"
+
+type BonusRepository interface {
+	FindActive() ([]models.BonusModel, error)
+	FindById(id uint64) (*models.BonusModel, error)
+	FindByUserId(userId uint64) ([]models.BonusModel, error)
+	// FindLastSequences raises the last ledger sequence of every user in sequences to the highest of its rows.
+	FindLastSequences(sequences map[uint64]uint64) error
+	Insert(bonusModel models.BonusModel) error
+	Update(bonusModel models.BonusModel) error
+}
+
+type MongoBonusRepository struct {
+	DB *mongo.Database
+}
+
+func (r *MongoBonusRepository) FindActive() ([]models.BonusModel, error) {
+	return r.find(bson.M{"status": models.BonusActive})
+}
+
+func (r *MongoBonusRepository) FindById(id uint64) (*models.BonusModel, error) {
+	collection := r.DB.Collection(bonusCollection)
+
+	var result models.BonusModel
+	err := collection.FindOne(context.TODO(), bson.M{"id": id}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *MongoBonusRepository) FindByUserId(userId uint64) ([]models.BonusModel, error) {
+	return r.find(bson.M{"user_id": userId})
+}
+
+func (r *MongoBonusRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	return findLastSequences(r.DB.Collection(bonusCollection), "$sequence", sequences)
+}
+
+func (r *MongoBonusRepository) Insert(bonusModel models.BonusModel) error {
+	collection := r.DB.Collection(bonusCollection)
+
+	_, err := collection.InsertOne(context.TODO(), bonusModel)
+	if isDuplicateKey(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *MongoBonusRepository) Update(bonusModel models.BonusModel) error {
+	collection := r.DB.Collection(bonusCollection)
+	filter := bson.M{"id": bonusModel.Id}
+
+	result, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": bonusModel})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *MongoBonusRepository) find(filter bson.M) ([]models.BonusModel, error) {
+	collection := r.DB.Collection(bonusCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make([]models.BonusModel, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
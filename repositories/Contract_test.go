@@ -0,0 +1,215 @@
+package repositories
+
+import (
+	"github.com/stretchr/testify/assert"
+	"guru/models"
+	"testing"
+	"time"
+)
+
+// The contract tests describe the behaviour every backend of a repository shares. They run against the memory
+// backend here and against MongoDB with the integration build tag, see make test-integration.
+
+func TestMemoryRepositories(t *testing.T) {
+	t.Run("user", func(t *testing.T) { testUserRepository(t, NewMemoryUserRepository()) })
+	t.Run("deposit", func(t *testing.T) { testDepositRepository(t, NewMemoryDepositRepository()) })
+	t.Run("transaction", func(t *testing.T) { testTransactionRepository(t, NewMemoryTransactionRepository()) })
+	t.Run("withdrawal", func(t *testing.T) { testWithdrawalRepository(t, NewMemoryWithdrawalRepository()) })
+	t.Run("adjustment", func(t *testing.T) { testAdjustmentRepository(t, NewMemoryAdjustmentRepository()) })
+	t.Run("audit", func(t *testing.T) { testAuditRepository(t, NewMemoryAuditRepository()) })
+}
+
+// contractTime is truncated to milliseconds, the precision MongoDB stores.
+var contractTime = time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+
+func testUserRepository(t *testing.T, r UserRepository) {
+	err := r.Insert([]models.UserModel{
+		{Id: 1, Wallets: models.Wallets{"EUR": 1000}, Token: "sssss"},
+		{Id: 2, Wallets: models.Wallets{"EUR": 0, "JPY": 500}, Token: "ddddd"},
+	})
+	assert.Nil(t, err)
+
+	users := make(map[uint64]*models.UserModel)
+	assert.Nil(t, r.FindAll(users))
+	if !assert.Len(t, users, 2) {
+		return
+	}
+	assert.Equal(t, models.Wallets{"EUR": 1000}, users[1].Wallets)
+	assert.Equal(t, models.Wallets{"EUR": 0, "JPY": 500}, users[2].Wallets)
+	// Tokens are stored as their hash only.
+	assert.Empty(t, users[1].Token)
+	assert.NotEmpty(t, users[1].TokenHash)
+
+	users[1].Wallets["EUR"] = 400
+	assert.Nil(t, r.Update(users[1]))
+
+	users = make(map[uint64]*models.UserModel)
+	assert.Nil(t, r.FindAll(users))
+	assert.Equal(t, models.Wallets{"EUR": 400}, users[1].Wallets)
+}
+
+func testDepositRepository(t *testing.T, r DepositRepository) {
+	deposit := models.DepositModel{Id: 1, UserId: 1, Currency: "EUR", Amount: 500, BalanceBefore: 1000, BalanceAfter: 1500, CreatedAt: contractTime, Sequence: 1}
+	assert.Nil(t, r.Insert(deposit))
+	assert.Nil(t, r.Insert(models.DepositModel{Id: 2, UserId: 1, Currency: "EUR", Amount: 100, BalanceBefore: 1500, BalanceAfter: 1600, CreatedAt: contractTime.Add(time.Hour), Sequence: 2}))
+	assert.Equal(t, ErrDuplicate, r.Insert(models.DepositModel{Id: 1, UserId: 2, Currency: "EUR", Amount: 100, CreatedAt: contractTime}))
+
+	stored, err := r.FindById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, &deposit, stored)
+	_, err = r.FindById(3)
+	assert.Equal(t, ErrNotFound, err)
+
+	sum, err := r.FindDepositSum(1, "EUR", contractTime.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(100), sum)
+
+	sequences := map[uint64]uint64{1: 0, 2: 0}
+	assert.Nil(t, r.FindLastSequences(sequences))
+	assert.Equal(t, map[uint64]uint64{1: 2, 2: 0}, sequences)
+}
+
+func testTransactionRepository(t *testing.T, r TransactionRepository) {
+	bet := models.TransactionModel{Id: 1, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 300, BalanceBefore: 1000, BalanceAfter: 700, CreatedAt: contractTime, Sequence: 1}
+	assert.Nil(t, r.Insert(bet))
+	assert.Equal(t, ErrDuplicate, r.Insert(models.TransactionModel{Id: 1, UserId: 2, Currency: "EUR", Type: models.TypeWin, Amount: 100, CreatedAt: contractTime}))
+
+	stored, err := r.FindById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, &bet, stored)
+	_, err = r.FindRollback(1)
+	assert.Equal(t, ErrNotFound, err)
+
+	// A bulk insert stops at the first transaction that fails.
+	count, err := r.InsertMany([]models.TransactionModel{
+		{Id: 2, UserId: 1, Currency: "EUR", Type: models.TypeRollback, Amount: 300, OriginalTransactionId: 1, BalanceBefore: 700, BalanceAfter: 1000, CreatedAt: contractTime, Sequence: 2},
+		{Id: 1, UserId: 1, Currency: "EUR", Type: models.TypeWin, Amount: 100, CreatedAt: contractTime},
+		{Id: 3, UserId: 2, Currency: "EUR", Type: models.TypeWin, Amount: 100, BalanceBefore: 0, BalanceAfter: 100, CreatedAt: contractTime, Sequence: 1},
+	})
+	assert.Equal(t, ErrDuplicate, err)
+	assert.Equal(t, 1, count)
+	_, err = r.FindById(3)
+	assert.Equal(t, ErrNotFound, err)
+	count, err = r.InsertMany([]models.TransactionModel{
+		{Id: 3, UserId: 2, Currency: "EUR", Type: models.TypeWin, Amount: 100, BalanceBefore: 0, BalanceAfter: 100, CreatedAt: contractTime, Sequence: 1},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	rollback, err := r.FindRollback(1)
+	if assert.Nil(t, err) {
+		assert.Equal(t, uint64(2), rollback.Id)
+	}
+
+	sequences := map[uint64]uint64{1: 0, 2: 0}
+	assert.Nil(t, r.FindLastSequences(sequences))
+	assert.Equal(t, map[uint64]uint64{1: 2, 2: 1}, sequences)
+
+	// Provider transactions are found by the provider and its id, which is unique per provider.
+	lastProviderId, err := r.FindLastProviderId()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), lastProviderId)
+	debit := models.TransactionModel{Id: 10, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 100, Provider: "alpha", ExternalId: "1", CreatedAt: contractTime}
+	assert.Nil(t, r.Insert(debit))
+	assert.Nil(t, r.Insert(models.TransactionModel{Id: 11, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 100, Provider: "beta", ExternalId: "1", CreatedAt: contractTime}))
+	assert.Equal(t, ErrDuplicate, r.Insert(models.TransactionModel{Id: 12, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 100, Provider: "alpha", ExternalId: "1", CreatedAt: contractTime}))
+	stored, err = r.FindByExternalId("alpha", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, &debit, stored)
+	_, err = r.FindByExternalId("alpha", "2")
+	assert.Equal(t, ErrNotFound, err)
+	lastProviderId, err = r.FindLastProviderId()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(11), lastProviderId)
+}
+
+func testWithdrawalRepository(t *testing.T, r WithdrawalRepository) {
+	withdrawal := models.WithdrawalModel{Id: 1, UserId: 1, Currency: "EUR", Amount: 200, Status: models.WithdrawalPending, BalanceBefore: 1000, BalanceAfter: 800, CreatedAt: contractTime, Sequence: 1}
+	assert.Nil(t, r.Insert(withdrawal))
+	assert.Equal(t, ErrDuplicate, r.Insert(withdrawal))
+
+	// A withdrawal is settled once.
+	withdrawal.Status = models.WithdrawalRejected
+	withdrawal.SettledAt = contractTime.Add(time.Hour)
+	withdrawal.ReleaseSequence = 2
+	assert.Nil(t, r.Update(withdrawal))
+	withdrawal.Status = models.WithdrawalApproved
+	assert.Equal(t, ErrNotFound, r.Update(withdrawal))
+	assert.Equal(t, ErrNotFound, r.Update(models.WithdrawalModel{Id: 2, Status: models.WithdrawalApproved}))
+
+	stored, err := r.FindById(1)
+	if assert.Nil(t, err) {
+		assert.Equal(t, models.WithdrawalRejected, stored.Status)
+		assert.Equal(t, contractTime.Add(time.Hour), stored.SettledAt)
+	}
+
+	withdrawals, err := r.FindByUserId(1)
+	assert.Nil(t, err)
+	assert.Len(t, withdrawals, 1)
+
+	sequences := map[uint64]uint64{1: 0}
+	assert.Nil(t, r.FindLastSequences(sequences))
+	assert.Equal(t, map[uint64]uint64{1: 2}, sequences)
+}
+
+func testAdjustmentRepository(t *testing.T, r AdjustmentRepository) {
+	adjustment := models.AdjustmentModel{Id: 1, UserId: 1, Currency: "EUR", Amount: -100, Reason: "chargeback", Actor: "admin", BalanceBefore: 1000, BalanceAfter: 900, CreatedAt: contractTime, Sequence: 3}
+	assert.Nil(t, r.Insert(adjustment))
+	assert.Equal(t, ErrDuplicate, r.Insert(adjustment))
+
+	stored, err := r.FindById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, &adjustment, stored)
+	_, err = r.FindById(2)
+	assert.Equal(t, ErrNotFound, err)
+
+	adjustments, err := r.FindByUserId(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []models.AdjustmentModel{adjustment}, adjustments)
+
+	sequences := map[uint64]uint64{1: 0}
+	assert.Nil(t, r.FindLastSequences(sequences))
+	assert.Equal(t, map[uint64]uint64{1: 3}, sequences)
+
+	// Repairs have ids of their own.
+	last, err := r.FindLastRepairId()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), last)
+	repair := models.AdjustmentModel{Id: 1, UserId: 1, Currency: "EUR", Amount: 100, Reason: "reconciliation repair", Actor: "reconcile", Repair: true, BalanceBefore: 900, BalanceAfter: 1000, CreatedAt: contractTime}
+	assert.Nil(t, r.Insert(repair))
+	assert.Equal(t, ErrDuplicate, r.Insert(repair))
+	last, err = r.FindLastRepairId()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), last)
+	stored, err = r.FindById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, &adjustment, stored)
+}
+
+func testAuditRepository(t *testing.T, r AuditRepository) {
+	_, err := r.FindLast()
+	assert.Equal(t, ErrNotFound, err)
+
+	for sequence := uint64(1); sequence <= 3; sequence++ {
+		assert.Nil(t, r.Insert(models.AuditModel{Sequence: sequence, Endpoint: "/transaction", UserId: sequence % 2, CreatedAt: contractTime.Add(time.Duration(sequence) * time.Minute)}))
+	}
+	assert.Equal(t, ErrDuplicate, r.Insert(models.AuditModel{Sequence: 2, Endpoint: "/user/deposit", CreatedAt: contractTime}))
+
+	last, err := r.FindLast()
+	if assert.Nil(t, err) {
+		assert.Equal(t, uint64(3), last.Sequence)
+	}
+
+	entries, err := r.Find(models.AuditFilterModel{UserId: 1})
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, uint64(1), entries[0].Sequence)
+		assert.Equal(t, uint64(3), entries[1].Sequence)
+	}
+
+	entries, err = r.Find(models.AuditFilterModel{AfterSequence: 1, Limit: 1})
+	assert.Nil(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, uint64(2), entries[0].Sequence)
+	}
+}
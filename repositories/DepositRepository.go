@@ -11,20 +11,32 @@ import (
 
 const depositCollection = "deposit"
 
-type DepositRepository struct {
+type DepositRepository interface {
+	FindAllDeposit(statistic map[models.StatisticKeyModel]*models.StatisticModel) error
+	FindDepositBuckets(filter models.StatisticFilterModel, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error
+	FindDepositSum(userId uint64, currency string, from time.Time) (models.Money, error)
+	FindById(id uint64) (*models.DepositModel, error)
+	FindHistory(filter models.HistoryFilterModel) ([]models.DepositModel, error)
+	// FindLastSequences raises the last ledger sequence of every user in sequences to the highest of its rows.
+	FindLastSequences(sequences map[uint64]uint64) error
+	Insert(depositModel models.DepositModel) error
+}
+
+type MongoDepositRepository struct {
 	DB *mongo.Database
 }
 
-func (r *DepositRepository) FindAllDeposit(statistic map[uint64]*models.StatisticModel) error {
+func (r *MongoDepositRepository) FindAllDeposit(statistic map[models.StatisticKeyModel]*models.StatisticModel) error {
 	collection := r.DB.Collection(depositCollection)
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
 	groupStage := bson.D{{
-		"$group",
-		bson.D{
-			{"_id", "$user_id"},
-			{"deposit_sum", bson.D{{"$sum", "$amount"}}},
-			{"deposit_count", bson.D{{"$sum", 1}}},
+		Key: "$group",
+		Value: bson.M{
+			"_id":           statisticKey(),
+			"deposit_sum":   bson.M{"$sum": "$amount"},
+			"deposit_count": bson.M{"$sum": 1},
 		},
 	}}
 
@@ -33,7 +45,7 @@ func (r *DepositRepository) FindAllDeposit(statistic map[uint64]*models.Statisti
 		return err
 	}
 
-	results := make(map[uint64]*models.StatisticModel)
+	results := make(map[models.StatisticKeyModel]*models.StatisticModel)
 	for cur.Next(ctx) {
 		var result models.StatisticModel
 		if err := cur.Decode(&result); err != nil {
@@ -53,10 +65,79 @@ func (r *DepositRepository) FindAllDeposit(statistic map[uint64]*models.Statisti
 	return nil
 }
 
-func (r *DepositRepository) Insert(depositModel models.DepositModel) error {
+func (r *MongoDepositRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	return findLastSequences(r.DB.Collection(depositCollection), "$sequence", sequences)
+}
+
+func (r *MongoDepositRepository) FindDepositBuckets(filter models.StatisticFilterModel, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error {
+	collection := r.DB.Collection(depositCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	matchStage := bson.D{{Key: "$match", Value: bucketMatch(filter)}}
+	groupStage := bson.D{{
+		Key: "$group",
+		Value: bson.M{
+			"_id":           bucketKey(filter),
+			"deposit_sum":   bson.M{"$sum": "$amount"},
+			"deposit_count": bson.M{"$sum": 1},
+		},
+	}}
+
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	if err != nil {
+		return err
+	}
+
+	return decodeBuckets(ctx, cur, buckets)
+}
+
+func (r *MongoDepositRepository) FindById(id uint64) (*models.DepositModel, error) {
+	collection := r.DB.Collection(depositCollection)
+
+	var result models.DepositModel
+	err := collection.FindOne(context.TODO(), bson.M{"id": id}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *MongoDepositRepository) FindDepositSum(userId uint64, currency string, from time.Time) (models.Money, error) {
+	return windowSum(r.DB.Collection(depositCollection), userId, currency, from, "$amount")
+}
+
+func (r *MongoDepositRepository) FindHistory(filter models.HistoryFilterModel) ([]models.DepositModel, error) {
+	collection := r.DB.Collection(depositCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := historyQuery(filter, models.HistorySourceDeposit)
+	cur, err := collection.Find(ctx, query, historyOptions(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make([]models.DepositModel, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *MongoDepositRepository) Insert(depositModel models.DepositModel) error {
 	collection := r.DB.Collection(depositCollection)
 
 	_, err := collection.InsertOne(context.TODO(), depositModel)
+	if isDuplicateKey(err) {
+		return ErrDuplicate
+	}
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,36 @@
+package repositories
+
+import (
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrDuplicate    = errors.New("duplicate key")
+	ErrBalanceGuard = errors.New("balance guard failed")
+)
+
+const duplicateKeyCode = 11000
+
+func isDuplicateKey(err error) bool {
+	var writeException mongo.WriteException
+	if errors.As(err, &writeException) {
+		for _, writeError := range writeException.WriteErrors {
+			if writeError.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+
+	var bulkWriteException mongo.BulkWriteException
+	if errors.As(err, &bulkWriteException) {
+		for _, writeError := range bulkWriteException.WriteErrors {
+			if writeError.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+
+	return false
+}
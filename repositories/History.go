@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"guru/models"
+	"time"
+)
+
+// historyQuery builds the filter selecting one user's ledger entries of a single source.
+func historyQuery(filter models.HistoryFilterModel, source string) bson.M {
+	query := bson.M{"user_id": filter.UserId}
+	if filter.Currency != "" {
+		query["currency"] = filter.Currency
+	}
+
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lt"] = filter.To
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	amount := bson.M{}
+	if filter.MinAmount > 0 {
+		amount["$gte"] = filter.MinAmount
+	}
+	if filter.MaxAmount > 0 {
+		amount["$lte"] = filter.MaxAmount
+	}
+	if len(amount) > 0 {
+		query["amount"] = amount
+	}
+
+	if after := filter.After; after != nil {
+		positions := bson.A{bson.M{"created_at": bson.M{"$lt": after.CreatedAt}}}
+		if source < after.Source {
+			positions = append(positions, bson.M{"created_at": after.CreatedAt})
+		}
+		if source == after.Source {
+			positions = append(positions, bson.M{"created_at": after.CreatedAt, "id": bson.M{"$lt": after.Id}})
+		}
+		query["$or"] = positions
+	}
+
+	return query
+}
+
+func historyOptions(filter models.HistoryFilterModel) *options.FindOptions {
+	return options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "id", Value: -1}}).
+		SetLimit(int64(filter.Limit))
+}
+
+// matchesHistory is the in-memory counterpart of historyQuery.
+func matchesHistory(filter models.HistoryFilterModel, source string, userId uint64, currency string, id uint64, amount models.Money, createdAt time.Time) bool {
+	if userId != filter.UserId {
+		return false
+	}
+	if filter.Currency != "" && currency != filter.Currency {
+		return false
+	}
+	if !filter.From.IsZero() && createdAt.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !createdAt.Before(filter.To) {
+		return false
+	}
+	if filter.MinAmount > 0 && amount < filter.MinAmount {
+		return false
+	}
+	if filter.MaxAmount > 0 && amount > filter.MaxAmount {
+		return false
+	}
+	if filter.After != nil && !filter.After.Follows(createdAt, source, id) {
+		return false
+	}
+
+	return true
+}
+
+// newerEntry orders ledger entries of one source newest first.
+func newerEntry(createdAt time.Time, id uint64, otherCreatedAt time.Time, otherId uint64) bool {
+	if !createdAt.Equal(otherCreatedAt) {
+		return createdAt.After(otherCreatedAt)
+	}
+
+	return id > otherId
+}
+
+func historyLimit(length int, filter models.HistoryFilterModel) int {
+	if filter.Limit > 0 && length > filter.Limit {
+		return filter.Limit
+	}
+
+	return length
+}
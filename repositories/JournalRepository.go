@@ -0,0 +1,192 @@
+package repositories
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"guru/models"
+	"io"
+	"os"
+	"sync"
+)
+
+type JournalRepository interface {
+	Append(entry models.JournalEntryModel) error
+	FindAll() ([]models.JournalEntryModel, error)
+	Checkpoint(sequence uint64) error
+}
+
+var ErrReadOnlyJournal = errors.New("journal opened read-only")
+
+// FileJournalRepository is an append-only file of JSON lines, synced to disk on every append.
+type FileJournalRepository struct {
+	path string
+	file *os.File
+	sync.Mutex
+}
+
+// NewFileJournalRepository opens the journal at path, cutting off a torn last
+// line left by a crash during append so that new entries follow valid ones.
+func NewFileJournalRepository(path string) (*FileJournalRepository, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &FileJournalRepository{path: path, file: file}
+	_, size, err := r.read()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// NewFileJournalReader opens the journal at path without ever changing it, so it can be read next to the
+// server that writes it. Append and Checkpoint fail with ErrReadOnlyJournal.
+func NewFileJournalReader(path string) (*FileJournalRepository, error) {
+	r := &FileJournalRepository{path: path}
+	if _, _, err := r.read(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *FileJournalRepository) Append(entry models.JournalEntryModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return ErrReadOnlyJournal
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return r.file.Sync()
+}
+
+func (r *FileJournalRepository) FindAll() ([]models.JournalEntryModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	entries, _, err := r.read()
+
+	return entries, err
+}
+
+// Checkpoint drops every entry up to and including sequence by rewriting the
+// remaining entries to a temporary file and renaming it over the journal.
+func (r *FileJournalRepository) Checkpoint(sequence uint64) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return ErrReadOnlyJournal
+	}
+	entries, _, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := r.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, entry := range entries {
+		if entry.Sequence <= sequence {
+			continue
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := writer.Write(append(line, '\n')); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, r.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	r.file.Close()
+	r.file = file
+
+	return nil
+}
+
+func (r *FileJournalRepository) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
+
+// read decodes the journal up to the first incomplete or malformed line and
+// returns the entries together with the size of that valid prefix.
+func (r *FileJournalRepository) read() ([]models.JournalEntryModel, int64, error) {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var entries []models.JournalEntryModel
+	var size int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var entry models.JournalEntryModel
+		if err := json.Unmarshal(line, &entry); err != nil {
+			break
+		}
+		entries = append(entries, entry)
+		size += int64(len(line))
+	}
+
+	return entries, size, nil
+}
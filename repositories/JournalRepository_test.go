@@ -0,0 +1,113 @@
+package repositories
+
+import (
+	"github.com/stretchr/testify/assert"
+	"guru/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileJournalRepository_Checkpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	journal, err := NewFileJournalRepository(filepath.Join(dir, "guru.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	for sequence := uint64(1); sequence <= 3; sequence++ {
+		entry := models.JournalEntryModel{Sequence: sequence, Operation: models.JournalDeposit, UserId: 1, Balance: models.Money(sequence * 100)}
+		if err := journal.Append(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := journal.Checkpoint(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Append(models.JournalEntryModel{Sequence: 4, Operation: models.JournalDeposit, UserId: 1, Balance: 400}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := journal.FindAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []models.JournalEntryModel{
+		{Sequence: 3, Operation: models.JournalDeposit, UserId: 1, Balance: 300},
+		{Sequence: 4, Operation: models.JournalDeposit, UserId: 1, Balance: 400},
+	}, entries)
+}
+
+func TestFileJournalRepository_TornAppend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "guru.journal")
+	data := `{"seq":1,"op":"deposit","user_id":1,"balance":100,"created_at":"0001-01-01T00:00:00Z"}` + "\n" + `{"seq":2,"op":"dep`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := NewFileJournalRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	if err := journal.Append(models.JournalEntryModel{Sequence: 2, Operation: models.JournalDeposit, UserId: 1, Balance: 200}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := journal.FindAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []models.JournalEntryModel{
+		{Sequence: 1, Operation: models.JournalDeposit, UserId: 1, Balance: 100},
+		{Sequence: 2, Operation: models.JournalDeposit, UserId: 1, Balance: 200},
+	}, entries)
+}
+
+func TestFileJournalRepository_Reader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A torn line may be an append in progress of the server; the reader leaves it in place.
+	path := filepath.Join(dir, "guru.journal")
+	data := `{"seq":1,"op":"deposit","user_id":1,"balance":100,"created_at":"0001-01-01T00:00:00Z"}` + "\n" + `{"seq":2,"op":"dep`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := NewFileJournalReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	entries, err := journal.FindAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []models.JournalEntryModel{{Sequence: 1, Operation: models.JournalDeposit, UserId: 1, Balance: 100}}, entries)
+	assert.Equal(t, ErrReadOnlyJournal, journal.Append(models.JournalEntryModel{Sequence: 2}))
+	assert.Equal(t, ErrReadOnlyJournal, journal.Checkpoint(1))
+
+	stored, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, data, string(stored))
+}
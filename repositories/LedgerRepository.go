@@ -0,0 +1,185 @@
+package repositories
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"guru/models"
+	"time"
+)
+
+// LedgerRepository records a ledger entry and applies the balance change it causes to the stored user
+// as one atomic unit, so the ledger and the user documents cannot drift apart.
+type LedgerRepository interface {
+	InsertDeposit(depositModel models.DepositModel) error
+	InsertTransaction(transactionModel models.TransactionModel) error
+	// InsertTransactions records the transactions and the balance changes of all of them, or nothing.
+	InsertTransactions(transactionModels []models.TransactionModel) error
+	InsertWithdrawal(withdrawalModel models.WithdrawalModel) error
+	// UpdateWithdrawal settles a pending withdrawal, like WithdrawalRepository.Update.
+	UpdateWithdrawal(withdrawalModel models.WithdrawalModel, delta models.Money) error
+	UpdateBonus(bonusModel models.BonusModel, delta models.Money) error
+	InsertAdjustment(adjustmentModel models.AdjustmentModel) error
+}
+
+// MongoLedgerRepository uses multi-document transactions and therefore requires a replica set.
+type MongoLedgerRepository struct {
+	DB *mongo.Database
+}
+
+func (r *MongoLedgerRepository) InsertDeposit(depositModel models.DepositModel) error {
+	return r.insert(depositCollection, depositModel, depositModel.UserId, depositModel.Currency, depositModel.BalanceAfter-depositModel.BalanceBefore)
+}
+
+func (r *MongoLedgerRepository) InsertTransaction(transactionModel models.TransactionModel) error {
+	return r.insert(TransactionCollection, transactionModel, transactionModel.UserId, transactionModel.Currency, transactionModel.BalanceAfter-transactionModel.BalanceBefore)
+}
+
+func (r *MongoLedgerRepository) InsertTransactions(transactionModels []models.TransactionModel) error {
+	wallets, deltas := walletDeltas(transactionModels)
+
+	return r.transaction(func(ctx mongo.SessionContext) error {
+		_, err := r.DB.Collection(TransactionCollection).InsertMany(ctx, transactionDocuments(transactionModels))
+		if isDuplicateKey(err) {
+			return ErrDuplicate
+		}
+		if err != nil {
+			return err
+		}
+
+		updates := make([]mongo.WriteModel, 0, len(wallets))
+		for _, wallet := range wallets {
+			if delta := deltas[wallet]; delta != 0 {
+				updates = append(updates, mongo.NewUpdateOneModel().
+					SetFilter(balanceFilter(wallet.UserId, wallet.Currency, delta)).
+					SetUpdate(bson.M{"$inc": bson.M{"wallets." + wallet.Currency: delta}}))
+			}
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+
+		result, err := r.DB.Collection(userCollection).BulkWrite(ctx, updates)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount != int64(len(updates)) {
+			return ErrBalanceGuard
+		}
+
+		return nil
+	})
+}
+
+func (r *MongoLedgerRepository) InsertWithdrawal(withdrawalModel models.WithdrawalModel) error {
+	return r.insert(withdrawalCollection, withdrawalModel, withdrawalModel.UserId, withdrawalModel.Currency, withdrawalModel.BalanceAfter-withdrawalModel.BalanceBefore)
+}
+
+func (r *MongoLedgerRepository) UpdateWithdrawal(withdrawalModel models.WithdrawalModel, delta models.Money) error {
+	filter := bson.M{"id": withdrawalModel.Id, "status": models.WithdrawalPending}
+	return r.update(withdrawalCollection, filter, withdrawalModel, withdrawalModel.UserId, withdrawalModel.Currency, delta)
+}
+
+func (r *MongoLedgerRepository) UpdateBonus(bonusModel models.BonusModel, delta models.Money) error {
+	return r.update(bonusCollection, bson.M{"id": bonusModel.Id}, bonusModel, bonusModel.UserId, bonusModel.Currency, delta)
+}
+
+func (r *MongoLedgerRepository) InsertAdjustment(adjustmentModel models.AdjustmentModel) error {
+	return r.insert(adjustmentCollection, adjustmentModel, adjustmentModel.UserId, adjustmentModel.Currency, adjustmentModel.BalanceAfter-adjustmentModel.BalanceBefore)
+}
+
+func (r *MongoLedgerRepository) insert(collectionName string, document interface{}, userId uint64, currency string, delta models.Money) error {
+	return r.transaction(func(ctx mongo.SessionContext) error {
+		_, err := r.DB.Collection(collectionName).InsertOne(ctx, document)
+		if isDuplicateKey(err) {
+			return ErrDuplicate
+		}
+		if err != nil {
+			return err
+		}
+
+		return r.incBalance(ctx, userId, currency, delta)
+	})
+}
+
+func (r *MongoLedgerRepository) update(collectionName string, filter bson.M, document interface{}, userId uint64, currency string, delta models.Money) error {
+	return r.transaction(func(ctx mongo.SessionContext) error {
+		result, err := r.DB.Collection(collectionName).UpdateOne(ctx, filter, bson.M{"$set": document})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrNotFound
+		}
+
+		return r.incBalance(ctx, userId, currency, delta)
+	})
+}
+
+// incBalance applies delta to one wallet of the user. The filter requires the wallet to exist
+// and guards debits so the stored balance never goes below zero.
+func (r *MongoLedgerRepository) incBalance(ctx mongo.SessionContext, userId uint64, currency string, delta models.Money) error {
+	if delta == 0 {
+		return nil
+	}
+
+	collection := r.DB.Collection(userCollection)
+	result, err := collection.UpdateOne(ctx, balanceFilter(userId, currency, delta), bson.M{"$inc": bson.M{"wallets." + currency: delta}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrBalanceGuard
+	}
+
+	return nil
+}
+
+// balanceFilter matches the user when it has the wallet and, for a debit, the wallet covers it.
+func balanceFilter(userId uint64, currency string, delta models.Money) bson.M {
+	wallet := "wallets." + currency
+	filter := bson.M{"id": userId, wallet: bson.M{"$exists": true}}
+	if delta < 0 {
+		filter[wallet] = bson.M{"$gte": -delta}
+	}
+
+	return filter
+}
+
+// walletDeltas sums the balance changes of the transactions per wallet; the wallets are in order of first use.
+func walletDeltas(transactionModels []models.TransactionModel) ([]models.StatisticKeyModel, map[models.StatisticKeyModel]models.Money) {
+	var wallets []models.StatisticKeyModel
+	deltas := make(map[models.StatisticKeyModel]models.Money)
+	for _, transaction := range transactionModels {
+		wallet := models.StatisticKeyModel{UserId: transaction.UserId, Currency: transaction.Currency}
+		if _, ok := deltas[wallet]; !ok {
+			wallets = append(wallets, wallet)
+		}
+		deltas[wallet] += transaction.BalanceAfter - transaction.BalanceBefore
+	}
+
+	return wallets, deltas
+}
+
+func (r *MongoLedgerRepository) transaction(fn func(ctx mongo.SessionContext) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, err := r.DB.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	transactionOpts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessionCtx)
+	}, transactionOpts)
+
+	return err
+}
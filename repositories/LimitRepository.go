@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"guru/models"
+	"time"
+)
+
+const limitCollection = "limit"
+
+type LimitRepository interface {
+	FindAll() ([]models.LimitModel, error)
+	Upsert(limitModel models.LimitModel) error
+	Delete(limitModel models.LimitModel) error
+}
+
+type MongoLimitRepository struct {
+	DB *mongo.Database
+}
+
+func (r *MongoLimitRepository) FindAll() ([]models.LimitModel, error) {
+	collection := r.DB.Collection(limitCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make([]models.LimitModel, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *MongoLimitRepository) Upsert(limitModel models.LimitModel) error {
+	collection := r.DB.Collection(limitCollection)
+
+	_, err := collection.ReplaceOne(context.TODO(), limitFilter(limitModel), limitModel, options.Replace().SetUpsert(true))
+
+	return err
+}
+
+func (r *MongoLimitRepository) Delete(limitModel models.LimitModel) error {
+	collection := r.DB.Collection(limitCollection)
+
+	result, err := collection.DeleteOne(context.TODO(), limitFilter(limitModel))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func limitFilter(limitModel models.LimitModel) bson.M {
+	return bson.M{
+		"user_id":  limitModel.UserId,
+		"currency": limitModel.Currency,
+		"type":     limitModel.Type,
+		"period":   limitModel.Period,
+	}
+}
@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"guru/models"
+	"sync"
+)
+
+type MemoryAdjustmentRepository struct {
+	adjustments []models.AdjustmentModel
+	sync.Mutex
+}
+
+func NewMemoryAdjustmentRepository(adjustments ...models.AdjustmentModel) *MemoryAdjustmentRepository {
+	return &MemoryAdjustmentRepository{adjustments: adjustments}
+}
+
+func (r *MemoryAdjustmentRepository) FindById(id uint64) (*models.AdjustmentModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, adjustment := range r.adjustments {
+		if adjustment.Id == id && !adjustment.Repair {
+			return &adjustment, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (r *MemoryAdjustmentRepository) FindByUserId(userId uint64) ([]models.AdjustmentModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	results := make([]models.AdjustmentModel, 0)
+	for _, adjustment := range r.adjustments {
+		if adjustment.UserId == userId {
+			results = append(results, adjustment)
+		}
+	}
+
+	return results, nil
+}
+
+func (r *MemoryAdjustmentRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, adjustment := range r.adjustments {
+		lastSequence(sequences, adjustment.UserId, adjustment.Sequence)
+	}
+
+	return nil
+}
+
+func (r *MemoryAdjustmentRepository) FindLastRepairId() (uint64, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var last uint64
+	for _, adjustment := range r.adjustments {
+		if adjustment.Repair && adjustment.Id > last {
+			last = adjustment.Id
+		}
+	}
+
+	return last, nil
+}
+
+func (r *MemoryAdjustmentRepository) Insert(adjustmentModel models.AdjustmentModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, adjustment := range r.adjustments {
+		if adjustment.Id == adjustmentModel.Id && adjustment.Repair == adjustmentModel.Repair {
+			return ErrDuplicate
+		}
+	}
+
+	r.adjustments = append(r.adjustments, adjustmentModel)
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package repositories
+
+import (
+	"guru/models"
+	"sort"
+	"sync"
+)
+
+type MemoryAuditRepository struct {
+	entries []models.AuditModel
+	sync.Mutex
+}
+
+func NewMemoryAuditRepository(entries ...models.AuditModel) *MemoryAuditRepository {
+	return &MemoryAuditRepository{entries: entries}
+}
+
+func (r *MemoryAuditRepository) FindLast() (*models.AuditModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var last *models.AuditModel
+	for i := range r.entries {
+		if last == nil || r.entries[i].Sequence > last.Sequence {
+			last = &r.entries[i]
+		}
+	}
+	if last == nil {
+		return nil, ErrNotFound
+	}
+
+	result := *last
+	return &result, nil
+}
+
+func (r *MemoryAuditRepository) Find(filter models.AuditFilterModel) ([]models.AuditModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	results := make([]models.AuditModel, 0)
+	for _, entry := range r.entries {
+		if entry.Sequence <= filter.AfterSequence {
+			continue
+		}
+		if filter.UserId != 0 && entry.UserId != filter.UserId {
+			continue
+		}
+		if !filter.From.IsZero() && entry.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !entry.CreatedAt.Before(filter.To) {
+			continue
+		}
+		results = append(results, entry)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Sequence < results[j].Sequence })
+	if filter.Limit > 0 && len(results) > filter.Limit {
+		results = results[:filter.Limit]
+	}
+
+	return results, nil
+}
+
+func (r *MemoryAuditRepository) Insert(auditModel models.AuditModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, entry := range r.entries {
+		if entry.Sequence == auditModel.Sequence {
+			return ErrDuplicate
+		}
+	}
+	r.entries = append(r.entries, auditModel)
+
+	return nil
+}
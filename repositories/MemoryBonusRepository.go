@@ -0,0 +1,96 @@
+package repositories
+
+import (
+	"guru/models"
+	"sync"
+)
+
+type MemoryBonusRepository struct {
+	bonuses []models.BonusModel
+	sync.Mutex
+}
+
+func NewMemoryBonusRepository(bonuses ...models.BonusModel) *MemoryBonusRepository {
+	return &MemoryBonusRepository{bonuses: bonuses}
+}
+
+func (r *MemoryBonusRepository) FindActive() ([]models.BonusModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	results := make([]models.BonusModel, 0)
+	for _, bonus := range r.bonuses {
+		if bonus.Status == models.BonusActive {
+			results = append(results, bonus)
+		}
+	}
+
+	return results, nil
+}
+
+func (r *MemoryBonusRepository) FindById(id uint64) (*models.BonusModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, bonus := range r.bonuses {
+		if bonus.Id == id {
+			return &bonus, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (r *MemoryBonusRepository) FindByUserId(userId uint64) ([]models.BonusModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	results := make([]models.BonusModel, 0)
+	for _, bonus := range r.bonuses {
+		if bonus.UserId == userId {
+			results = append(results, bonus)
+		}
+	}
+
+	return results, nil
+}
+
+func (r *MemoryBonusRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, bonus := range r.bonuses {
+		lastSequence(sequences, bonus.UserId, bonus.Sequence)
+	}
+
+	return nil
+}
+
+func (r *MemoryBonusRepository) Insert(bonusModel models.BonusModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, bonus := range r.bonuses {
+		if bonus.Id == bonusModel.Id {
+			return ErrDuplicate
+		}
+	}
+
+	r.bonuses = append(r.bonuses, bonusModel)
+
+	return nil
+}
+
+func (r *MemoryBonusRepository) Update(bonusModel models.BonusModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for i := range r.bonuses {
+		if r.bonuses[i].Id == bonusModel.Id {
+			r.bonuses[i] = bonusModel
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
@@ -0,0 +1,124 @@
+package repositories
+
+import (
+	"guru/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryDepositRepository struct {
+	deposits []models.DepositModel
+	sync.Mutex
+}
+
+func NewMemoryDepositRepository(deposits ...models.DepositModel) *MemoryDepositRepository {
+	return &MemoryDepositRepository{deposits: deposits}
+}
+
+func (r *MemoryDepositRepository) FindAllDeposit(statistic map[models.StatisticKeyModel]*models.StatisticModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, deposit := range r.deposits {
+		result := statisticFor(statistic, deposit.UserId, deposit.Currency)
+		result.DepositCount++
+		result.DepositSum += deposit.Amount
+	}
+
+	return nil
+}
+
+func (r *MemoryDepositRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, deposit := range r.deposits {
+		lastSequence(sequences, deposit.UserId, deposit.Sequence)
+	}
+
+	return nil
+}
+
+func (r *MemoryDepositRepository) FindDepositBuckets(filter models.StatisticFilterModel, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, deposit := range r.deposits {
+		if bucket := bucketFor(buckets, filter, deposit.UserId, deposit.Currency, deposit.CreatedAt); bucket != nil {
+			bucket.DepositCount++
+			bucket.DepositSum += deposit.Amount
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryDepositRepository) FindById(id uint64) (*models.DepositModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, deposit := range r.deposits {
+		if deposit.Id == id {
+			return &deposit, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (r *MemoryDepositRepository) FindDepositSum(userId uint64, currency string, from time.Time) (models.Money, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var sum models.Money
+	for _, deposit := range r.deposits {
+		if deposit.UserId == userId && deposit.Currency == currency && deposit.CreatedAt.After(from) {
+			sum += deposit.Amount
+		}
+	}
+
+	return sum, nil
+}
+
+func (r *MemoryDepositRepository) FindHistory(filter models.HistoryFilterModel) ([]models.DepositModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	results := make([]models.DepositModel, 0)
+	for _, deposit := range r.deposits {
+		if matchesHistory(filter, models.HistorySourceDeposit, deposit.UserId, deposit.Currency, deposit.Id, deposit.Amount, deposit.CreatedAt) {
+			results = append(results, deposit)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return newerEntry(results[i].CreatedAt, results[i].Id, results[j].CreatedAt, results[j].Id)
+	})
+
+	return results[:historyLimit(len(results), filter)], nil
+}
+
+func (r *MemoryDepositRepository) Insert(depositModel models.DepositModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, deposit := range r.deposits {
+		if deposit.Id == depositModel.Id {
+			return ErrDuplicate
+		}
+	}
+
+	r.deposits = append(r.deposits, depositModel)
+
+	return nil
+}
+
+func statisticFor(statistic map[models.StatisticKeyModel]*models.StatisticModel, userId uint64, currency string) *models.StatisticModel {
+	key := models.StatisticKeyModel{UserId: userId, Currency: currency}
+	if _, ok := statistic[key]; !ok {
+		statistic[key] = &models.StatisticModel{Id: key}
+	}
+
+	return statistic[key]
+}
@@ -0,0 +1,49 @@
+package repositories
+
+import (
+	"guru/models"
+	"sync"
+)
+
+type MemoryJournalRepository struct {
+	entries []models.JournalEntryModel
+	sync.Mutex
+}
+
+func NewMemoryJournalRepository(entries ...models.JournalEntryModel) *MemoryJournalRepository {
+	return &MemoryJournalRepository{entries: entries}
+}
+
+func (r *MemoryJournalRepository) Append(entry models.JournalEntryModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.entries = append(r.entries, entry)
+
+	return nil
+}
+
+func (r *MemoryJournalRepository) FindAll() ([]models.JournalEntryModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	entries := make([]models.JournalEntryModel, len(r.entries))
+	copy(entries, r.entries)
+
+	return entries, nil
+}
+
+func (r *MemoryJournalRepository) Checkpoint(sequence uint64) error {
+	r.Lock()
+	defer r.Unlock()
+
+	var entries []models.JournalEntryModel
+	for _, entry := range r.entries {
+		if entry.Sequence > sequence {
+			entries = append(entries, entry)
+		}
+	}
+	r.entries = entries
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package repositories
+
+import (
+	"guru/models"
+	"sync"
+)
+
+type MemoryLedgerRepository struct {
+	users        *MemoryUserRepository
+	deposits     *MemoryDepositRepository
+	transactions *MemoryTransactionRepository
+	withdrawals  *MemoryWithdrawalRepository
+	bonuses      *MemoryBonusRepository
+	adjustments  *MemoryAdjustmentRepository
+	sync.Mutex
+}
+
+func NewMemoryLedgerRepository(
+	users *MemoryUserRepository,
+	deposits *MemoryDepositRepository,
+	transactions *MemoryTransactionRepository,
+	withdrawals *MemoryWithdrawalRepository,
+	bonuses *MemoryBonusRepository,
+	adjustments *MemoryAdjustmentRepository,
+) *MemoryLedgerRepository {
+	return &MemoryLedgerRepository{
+		users:        users,
+		deposits:     deposits,
+		transactions: transactions,
+		withdrawals:  withdrawals,
+		bonuses:      bonuses,
+		adjustments:  adjustments,
+	}
+}
+
+func (r *MemoryLedgerRepository) InsertDeposit(depositModel models.DepositModel) error {
+	return r.apply(depositModel.UserId, depositModel.Currency, depositModel.BalanceAfter-depositModel.BalanceBefore, func() error {
+		return r.deposits.Insert(depositModel)
+	})
+}
+
+func (r *MemoryLedgerRepository) InsertTransaction(transactionModel models.TransactionModel) error {
+	return r.apply(transactionModel.UserId, transactionModel.Currency, transactionModel.BalanceAfter-transactionModel.BalanceBefore, func() error {
+		return r.transactions.Insert(transactionModel)
+	})
+}
+
+// InsertTransactions guards the net balance change of every wallet, like one apply for the whole batch.
+func (r *MemoryLedgerRepository) InsertTransactions(transactionModels []models.TransactionModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	wallets, deltas := walletDeltas(transactionModels)
+	r.users.Lock()
+	for _, wallet := range wallets {
+		user, ok := r.users.users[wallet.UserId]
+		balance, exists := user.Wallets[wallet.Currency]
+		if !ok || !exists || balance+deltas[wallet] < 0 {
+			r.users.Unlock()
+			return ErrBalanceGuard
+		}
+	}
+	r.users.Unlock()
+
+	if err := r.transactions.insertAll(transactionModels); err != nil {
+		return err
+	}
+
+	r.users.Lock()
+	defer r.users.Unlock()
+	for _, wallet := range wallets {
+		r.users.users[wallet.UserId].Wallets[wallet.Currency] += deltas[wallet]
+	}
+
+	return nil
+}
+
+func (r *MemoryLedgerRepository) InsertWithdrawal(withdrawalModel models.WithdrawalModel) error {
+	return r.apply(withdrawalModel.UserId, withdrawalModel.Currency, withdrawalModel.BalanceAfter-withdrawalModel.BalanceBefore, func() error {
+		return r.withdrawals.Insert(withdrawalModel)
+	})
+}
+
+func (r *MemoryLedgerRepository) UpdateWithdrawal(withdrawalModel models.WithdrawalModel, delta models.Money) error {
+	return r.apply(withdrawalModel.UserId, withdrawalModel.Currency, delta, func() error {
+		return r.withdrawals.Update(withdrawalModel)
+	})
+}
+
+func (r *MemoryLedgerRepository) UpdateBonus(bonusModel models.BonusModel, delta models.Money) error {
+	return r.apply(bonusModel.UserId, bonusModel.Currency, delta, func() error {
+		return r.bonuses.Update(bonusModel)
+	})
+}
+
+func (r *MemoryLedgerRepository) InsertAdjustment(adjustmentModel models.AdjustmentModel) error {
+	return r.apply(adjustmentModel.UserId, adjustmentModel.Currency, adjustmentModel.BalanceAfter-adjustmentModel.BalanceBefore, func() error {
+		return r.adjustments.Insert(adjustmentModel)
+	})
+}
+
+// apply checks the balance guard before writing so a failed write leaves both the ledger and the user untouched.
+func (r *MemoryLedgerRepository) apply(userId uint64, currency string, delta models.Money, write func() error) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.users.Lock()
+	user, ok := r.users.users[userId]
+	balance, exists := user.Wallets[currency]
+	r.users.Unlock()
+	if !ok || !exists || balance+delta < 0 {
+		return ErrBalanceGuard
+	}
+
+	if err := write(); err != nil {
+		return err
+	}
+
+	r.users.Lock()
+	defer r.users.Unlock()
+	r.users.users[userId].Wallets[currency] += delta
+
+	return nil
+}
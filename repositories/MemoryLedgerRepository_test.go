@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"github.com/stretchr/testify/assert"
+	"guru/models"
+	"testing"
+)
+
+func TestMemoryLedgerRepository_InsertTransaction(t *testing.T) {
+	users := NewMemoryUserRepository(models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 1000}, Token: "sssss"})
+	transactions := NewMemoryTransactionRepository()
+	ledger := NewMemoryLedgerRepository(users, NewMemoryDepositRepository(), transactions, NewMemoryWithdrawalRepository(), NewMemoryBonusRepository(), NewMemoryAdjustmentRepository())
+
+	err := ledger.InsertTransaction(models.TransactionModel{Id: 1, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 400, BalanceBefore: 1000, BalanceAfter: 600})
+	assert.Nil(t, err)
+
+	err = ledger.InsertTransaction(models.TransactionModel{Id: 2, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 700, BalanceBefore: 600, BalanceAfter: -100})
+	assert.Equal(t, ErrBalanceGuard, err)
+
+	err = ledger.InsertTransaction(models.TransactionModel{Id: 1, UserId: 1, Currency: "EUR", Type: models.TypeWin, Amount: 100, BalanceBefore: 600, BalanceAfter: 700})
+	assert.Equal(t, ErrDuplicate, err)
+
+	stored := make(map[uint64]*models.UserModel)
+	if err := users.FindAll(stored); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, models.Wallets{"EUR": 600}, stored[1].Wallets)
+
+	_, err = transactions.FindById(2)
+	assert.Equal(t, ErrNotFound, err)
+
+	err = ledger.InsertTransaction(models.TransactionModel{Id: 3, UserId: 1, Currency: "USD", Type: models.TypeWin, Amount: 100, BalanceBefore: 0, BalanceAfter: 100})
+	assert.Equal(t, ErrBalanceGuard, err)
+}
+
+func TestMemoryLedgerRepository_InsertTransactions(t *testing.T) {
+	users := NewMemoryUserRepository(
+		models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 1000}, Token: "sssss"},
+		models.UserModel{Id: 2, Wallets: models.Wallets{"EUR": 0}, Token: "ddddd"},
+	)
+	transactions := NewMemoryTransactionRepository(models.TransactionModel{Id: 9, UserId: 2, Currency: "EUR", Type: models.TypeWin, Amount: 100})
+	ledger := NewMemoryLedgerRepository(users, NewMemoryDepositRepository(), transactions, NewMemoryWithdrawalRepository(), NewMemoryBonusRepository(), NewMemoryAdjustmentRepository())
+
+	// The guard applies to the net change of each wallet.
+	err := ledger.InsertTransactions([]models.TransactionModel{
+		{Id: 1, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 800, BalanceBefore: 1000, BalanceAfter: 200},
+		{Id: 2, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 300, BalanceBefore: 200, BalanceAfter: -100},
+	})
+	assert.Equal(t, ErrBalanceGuard, err)
+
+	// One duplicate stores none of the transactions.
+	err = ledger.InsertTransactions([]models.TransactionModel{
+		{Id: 1, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 800, BalanceBefore: 1000, BalanceAfter: 200},
+		{Id: 9, UserId: 2, Currency: "EUR", Type: models.TypeWin, Amount: 100, BalanceBefore: 0, BalanceAfter: 100},
+	})
+	assert.Equal(t, ErrDuplicate, err)
+	_, err = transactions.FindById(1)
+	assert.Equal(t, ErrNotFound, err)
+
+	err = ledger.InsertTransactions([]models.TransactionModel{
+		{Id: 1, UserId: 1, Currency: "EUR", Type: models.TypeBet, Amount: 800, BalanceBefore: 1000, BalanceAfter: 200},
+		{Id: 2, UserId: 2, Currency: "EUR", Type: models.TypeWin, Amount: 100, BalanceBefore: 0, BalanceAfter: 100},
+		{Id: 3, UserId: 1, Currency: "EUR", Type: models.TypeWin, Amount: 50, BalanceBefore: 200, BalanceAfter: 250},
+	})
+	assert.Nil(t, err)
+
+	stored := make(map[uint64]*models.UserModel)
+	if err := users.FindAll(stored); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, models.Wallets{"EUR": 250}, stored[1].Wallets)
+	assert.Equal(t, models.Wallets{"EUR": 100}, stored[2].Wallets)
+}
+
+func TestMemoryLedgerRepository_UpdateWithdrawal(t *testing.T) {
+	users := NewMemoryUserRepository(models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 1000}, Token: "sssss"})
+	withdrawals := NewMemoryWithdrawalRepository()
+	ledger := NewMemoryLedgerRepository(users, NewMemoryDepositRepository(), NewMemoryTransactionRepository(), withdrawals, NewMemoryBonusRepository(), NewMemoryAdjustmentRepository())
+
+	withdrawal := models.WithdrawalModel{Id: 1, UserId: 1, Currency: "EUR", Amount: 400, Status: models.WithdrawalPending, BalanceBefore: 1000, BalanceAfter: 600}
+	assert.Nil(t, ledger.InsertWithdrawal(withdrawal))
+
+	// A withdrawal is settled once; the second rejection releases nothing.
+	withdrawal.Status = models.WithdrawalRejected
+	assert.Nil(t, ledger.UpdateWithdrawal(withdrawal, 400))
+	assert.Equal(t, ErrNotFound, ledger.UpdateWithdrawal(withdrawal, 400))
+
+	stored := make(map[uint64]*models.UserModel)
+	if err := users.FindAll(stored); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, models.Wallets{"EUR": 1000}, stored[1].Wallets)
+}
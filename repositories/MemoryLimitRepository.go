@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"guru/models"
+	"sync"
+)
+
+type MemoryLimitRepository struct {
+	limits []models.LimitModel
+	sync.Mutex
+}
+
+func NewMemoryLimitRepository(limits ...models.LimitModel) *MemoryLimitRepository {
+	return &MemoryLimitRepository{limits: limits}
+}
+
+func (r *MemoryLimitRepository) FindAll() ([]models.LimitModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	results := make([]models.LimitModel, len(r.limits))
+	copy(results, r.limits)
+
+	return results, nil
+}
+
+func (r *MemoryLimitRepository) Upsert(limitModel models.LimitModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if i := r.index(limitModel); i >= 0 {
+		r.limits[i] = limitModel
+		return nil
+	}
+
+	r.limits = append(r.limits, limitModel)
+
+	return nil
+}
+
+func (r *MemoryLimitRepository) Delete(limitModel models.LimitModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	i := r.index(limitModel)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	r.limits = append(r.limits[:i], r.limits[i+1:]...)
+
+	return nil
+}
+
+func (r *MemoryLimitRepository) index(limitModel models.LimitModel) int {
+	for i, limit := range r.limits {
+		if limit.UserId == limitModel.UserId && limit.Key() == limitModel.Key() {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"guru/models"
+	"sync"
+)
+
+type MemoryRoundRepository struct {
+	rounds []models.RoundModel
+	sync.Mutex
+}
+
+func NewMemoryRoundRepository(rounds ...models.RoundModel) *MemoryRoundRepository {
+	return &MemoryRoundRepository{rounds: rounds}
+}
+
+func (r *MemoryRoundRepository) FindById(provider string, roundId string) (*models.RoundModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, round := range r.rounds {
+		if round.Provider == provider && round.RoundId == roundId {
+			return &round, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (r *MemoryRoundRepository) Insert(roundModel models.RoundModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, round := range r.rounds {
+		if round.Provider == roundModel.Provider && round.RoundId == roundModel.RoundId {
+			return ErrDuplicate
+		}
+	}
+
+	r.rounds = append(r.rounds, roundModel)
+
+	return nil
+}
+
+func (r *MemoryRoundRepository) Update(roundModel models.RoundModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for i := range r.rounds {
+		if r.rounds[i].Provider == roundModel.Provider && r.rounds[i].RoundId == roundModel.RoundId {
+			r.rounds[i] = roundModel
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
@@ -0,0 +1,285 @@
+package repositories
+
+import (
+	"guru/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+type MemoryTransactionRepository struct {
+	transactions []models.TransactionModel
+	sync.Mutex
+}
+
+func NewMemoryTransactionRepository(transactions ...models.TransactionModel) *MemoryTransactionRepository {
+	return &MemoryTransactionRepository{transactions: transactions}
+}
+
+func (r *MemoryTransactionRepository) FindAllBet(statistic map[models.StatisticKeyModel]*models.StatisticModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	rolledBack := r.rolledBack()
+	for _, transaction := range r.transactions {
+		if transaction.Type != models.TypeBet || rolledBack[transaction.Id] {
+			continue
+		}
+
+		result := statisticFor(statistic, transaction.UserId, transaction.Currency)
+		result.BetCount++
+		result.BetSum += transaction.Amount
+	}
+
+	return nil
+}
+
+func (r *MemoryTransactionRepository) FindAllWin(statistic map[models.StatisticKeyModel]*models.StatisticModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	rolledBack := r.rolledBack()
+	for _, transaction := range r.transactions {
+		if transaction.Type != models.TypeWin || rolledBack[transaction.Id] {
+			continue
+		}
+
+		result := statisticFor(statistic, transaction.UserId, transaction.Currency)
+		result.WinCount++
+		result.WinSum += transaction.Amount
+	}
+
+	return nil
+}
+
+func (r *MemoryTransactionRepository) FindBetBuckets(filter models.StatisticFilterModel, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	rolledBack := r.rolledBack()
+	for _, transaction := range r.transactions {
+		if transaction.Type != models.TypeBet || rolledBack[transaction.Id] {
+			continue
+		}
+
+		if bucket := bucketFor(buckets, filter, transaction.UserId, transaction.Currency, transaction.CreatedAt); bucket != nil {
+			bucket.BetCount++
+			bucket.BetSum += transaction.Amount
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryTransactionRepository) FindWinBuckets(filter models.StatisticFilterModel, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	rolledBack := r.rolledBack()
+	for _, transaction := range r.transactions {
+		if transaction.Type != models.TypeWin || rolledBack[transaction.Id] {
+			continue
+		}
+
+		if bucket := bucketFor(buckets, filter, transaction.UserId, transaction.Currency, transaction.CreatedAt); bucket != nil {
+			bucket.WinCount++
+			bucket.WinSum += transaction.Amount
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryTransactionRepository) FindById(id uint64) (*models.TransactionModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, transaction := range r.transactions {
+		if transaction.Id == id {
+			return &transaction, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (r *MemoryTransactionRepository) FindByExternalId(provider string, externalId string) (*models.TransactionModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, transaction := range r.transactions {
+		if transaction.ExternalId != "" && transaction.Provider == provider && transaction.ExternalId == externalId {
+			return &transaction, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (r *MemoryTransactionRepository) FindGameStatistics(filter models.StatisticFilterModel, games map[models.GameStatisticKeyModel]*models.GameStatisticModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	rolledBack := r.rolledBack()
+	for _, transaction := range r.transactions {
+		if rolledBack[transaction.Id] {
+			continue
+		}
+
+		game := gameStatisticFor(games, filter, transaction)
+		if game == nil {
+			continue
+		}
+		switch transaction.Type {
+		case models.TypeBet:
+			game.BetCount++
+			game.BetSum += transaction.Amount
+		case models.TypeWin:
+			game.WinCount++
+			game.WinSum += transaction.Amount
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryTransactionRepository) FindRollback(originalId uint64) (*models.TransactionModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, transaction := range r.transactions {
+		if transaction.Type == models.TypeRollback && transaction.OriginalTransactionId == originalId {
+			return &transaction, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (r *MemoryTransactionRepository) FindHistory(filter models.HistoryFilterModel) ([]models.TransactionModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	results := make([]models.TransactionModel, 0)
+	for _, transaction := range r.transactions {
+		if matchesHistory(filter, models.HistorySourceTransaction, transaction.UserId, transaction.Currency, transaction.Id, transaction.Amount, transaction.CreatedAt) && filter.HasType(transaction.Type) {
+			results = append(results, transaction)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return newerEntry(results[i].CreatedAt, results[i].Id, results[j].CreatedAt, results[j].Id)
+	})
+
+	return results[:historyLimit(len(results), filter)], nil
+}
+
+func (r *MemoryTransactionRepository) FindLossSum(userId uint64, currency string, from time.Time) (models.Money, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var sum models.Money
+	for _, transaction := range r.transactions {
+		if transaction.UserId == userId && transaction.Currency == currency && transaction.CreatedAt.After(from) {
+			sum += transaction.BalanceBefore - transaction.BalanceAfter
+		}
+	}
+
+	return sum, nil
+}
+
+func (r *MemoryTransactionRepository) Insert(transactionModel models.TransactionModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.duplicate(transactionModel) {
+		return ErrDuplicate
+	}
+	r.transactions = append(r.transactions, transactionModel)
+
+	return nil
+}
+
+func (r *MemoryTransactionRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, transaction := range r.transactions {
+		lastSequence(sequences, transaction.UserId, transaction.Sequence)
+	}
+
+	return nil
+}
+
+func (r *MemoryTransactionRepository) FindLastProviderId() (uint64, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var last uint64
+	for _, transaction := range r.transactions {
+		if transaction.ExternalId != "" && transaction.Id > last {
+			last = transaction.Id
+		}
+	}
+
+	return last, nil
+}
+
+func (r *MemoryTransactionRepository) InsertMany(transactionModels []models.TransactionModel) (int, error) {
+	for i, transactionModel := range transactionModels {
+		if err := r.Insert(transactionModel); err != nil {
+			return i, err
+		}
+	}
+
+	return len(transactionModels), nil
+}
+
+// insertAll inserts either all of the transactions or, when one of them is a duplicate, none.
+func (r *MemoryTransactionRepository) insertAll(transactionModels []models.TransactionModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	stored := len(r.transactions)
+	for _, transactionModel := range transactionModels {
+		if r.duplicate(transactionModel) {
+			r.transactions = r.transactions[:stored]
+			return ErrDuplicate
+		}
+		r.transactions = append(r.transactions, transactionModel)
+	}
+
+	return nil
+}
+
+// duplicate reports whether the transaction reuses a stored id or rolls back a transaction that is already rolled back.
+func (r *MemoryTransactionRepository) duplicate(transactionModel models.TransactionModel) bool {
+	for _, transaction := range r.transactions {
+		if transaction.Id == transactionModel.Id {
+			return true
+		}
+		if transactionModel.ExternalId != "" &&
+			transaction.Provider == transactionModel.Provider &&
+			transaction.ExternalId == transactionModel.ExternalId {
+			return true
+		}
+		if transactionModel.Type == models.TypeRollback &&
+			transaction.Type == models.TypeRollback &&
+			transaction.OriginalTransactionId == transactionModel.OriginalTransactionId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *MemoryTransactionRepository) rolledBack() map[uint64]bool {
+	rolledBack := make(map[uint64]bool)
+	for _, transaction := range r.transactions {
+		if transaction.Type == models.TypeRollback {
+			rolledBack[transaction.OriginalTransactionId] = true
+		}
+	}
+
+	return rolledBack
+}
@@ -0,0 +1,76 @@
+package repositories
+
+import (
+	"errors"
+	"guru/models"
+	"sync"
+)
+
+type MemoryUserRepository struct {
+	users map[uint64]models.UserModel
+	sync.Mutex
+}
+
+func NewMemoryUserRepository(users ...models.UserModel) *MemoryUserRepository {
+	r := &MemoryUserRepository{users: make(map[uint64]models.UserModel)}
+	for _, user := range users {
+		r.users[user.Id] = copyUser(user)
+	}
+
+	return r
+}
+
+func (r *MemoryUserRepository) FindAll(users map[uint64]*models.UserModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for id := range r.users {
+		user := copyUser(r.users[id])
+		if err := user.HashToken(); err != nil {
+			return err
+		}
+		r.users[id] = copyUser(user)
+		users[id] = &user
+	}
+
+	return nil
+}
+
+func (r *MemoryUserRepository) Insert(users []models.UserModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, user := range users {
+		if _, ok := r.users[user.Id]; ok {
+			return errors.New("duplicate user id")
+		}
+	}
+
+	for _, user := range users {
+		r.users[user.Id] = copyUser(user)
+	}
+
+	return nil
+}
+
+func (r *MemoryUserRepository) Update(user *models.UserModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.users[user.Id]; ok {
+		r.users[user.Id] = copyUser(*user)
+	}
+
+	return nil
+}
+
+// copyUser keeps the stored wallets from being shared with the caller.
+func copyUser(user models.UserModel) models.UserModel {
+	wallets := make(models.Wallets, len(user.Wallets))
+	for currency, balance := range user.Wallets {
+		wallets[currency] = balance
+	}
+	user.Wallets = wallets
+
+	return user
+}
@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"guru/models"
+	"sync"
+)
+
+type MemoryWithdrawalRepository struct {
+	withdrawals []models.WithdrawalModel
+	sync.Mutex
+}
+
+func NewMemoryWithdrawalRepository(withdrawals ...models.WithdrawalModel) *MemoryWithdrawalRepository {
+	return &MemoryWithdrawalRepository{withdrawals: withdrawals}
+}
+
+func (r *MemoryWithdrawalRepository) FindAllWithdrawal(statistic map[models.StatisticKeyModel]*models.StatisticModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, withdrawal := range r.withdrawals {
+		result := statisticFor(statistic, withdrawal.UserId, withdrawal.Currency)
+		switch withdrawal.Status {
+		case models.WithdrawalApproved:
+			result.WithdrawalCount++
+			result.WithdrawalSum += withdrawal.Amount
+		case models.WithdrawalPending:
+			result.PendingWithdrawalSum += withdrawal.Amount
+		}
+	}
+
+	return nil
+}
+
+func (r *MemoryWithdrawalRepository) FindById(id uint64) (*models.WithdrawalModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, withdrawal := range r.withdrawals {
+		if withdrawal.Id == id {
+			return &withdrawal, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (r *MemoryWithdrawalRepository) FindByUserId(userId uint64) ([]models.WithdrawalModel, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	results := make([]models.WithdrawalModel, 0)
+	for _, withdrawal := range r.withdrawals {
+		if withdrawal.UserId == userId {
+			results = append(results, withdrawal)
+		}
+	}
+
+	return results, nil
+}
+
+func (r *MemoryWithdrawalRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, withdrawal := range r.withdrawals {
+		lastSequence(sequences, withdrawal.UserId, withdrawal.Sequence)
+		lastSequence(sequences, withdrawal.UserId, withdrawal.ReleaseSequence)
+	}
+
+	return nil
+}
+
+func (r *MemoryWithdrawalRepository) Insert(withdrawalModel models.WithdrawalModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, withdrawal := range r.withdrawals {
+		if withdrawal.Id == withdrawalModel.Id {
+			return ErrDuplicate
+		}
+	}
+
+	r.withdrawals = append(r.withdrawals, withdrawalModel)
+
+	return nil
+}
+
+func (r *MemoryWithdrawalRepository) Update(withdrawalModel models.WithdrawalModel) error {
+	r.Lock()
+	defer r.Unlock()
+
+	for i := range r.withdrawals {
+		if r.withdrawals[i].Id == withdrawalModel.Id && r.withdrawals[i].Status == models.WithdrawalPending {
+			r.withdrawals[i] = withdrawalModel
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
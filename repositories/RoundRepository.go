@@ -0,0 +1,64 @@
+package repositories
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"guru/models"
+)
+
+const roundCollection = "round"
+
+type RoundRepository interface {
+	FindById(provider string, roundId string) (*models.RoundModel, error)
+	Insert(roundModel models.RoundModel) error
+	Update(roundModel models.RoundModel) error
+}
+
+type MongoRoundRepository struct {
+	DB *mongo.Database
+}
+
+func (r *MongoRoundRepository) FindById(provider string, roundId string) (*models.RoundModel, error) {
+	collection := r.DB.Collection(roundCollection)
+
+	var result models.RoundModel
+	err := collection.FindOne(context.TODO(), bson.M{"provider": provider, "round_id": roundId}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *MongoRoundRepository) Insert(roundModel models.RoundModel) error {
+	collection := r.DB.Collection(roundCollection)
+
+	_, err := collection.InsertOne(context.TODO(), roundModel)
+	if isDuplicateKey(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *MongoRoundRepository) Update(roundModel models.RoundModel) error {
+	collection := r.DB.Collection(roundCollection)
+	filter := bson.M{"provider": roundModel.Provider, "round_id": roundModel.RoundId}
+
+	result, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": roundModel})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
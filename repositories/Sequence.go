@@ -0,0 +1,49 @@
+package repositories
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// findLastSequences raises the last ledger sequence of every user in sequences to the highest one of the
+// collection; sequence is the aggregation expression of the sequence of a row.
+func findLastSequences(collection *mongo.Collection, sequence interface{}, sequences map[uint64]uint64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	groupStage := bson.D{{
+		Key: "$group",
+		Value: bson.M{
+			"_id":      "$user_id",
+			"sequence": bson.M{"$max": bson.M{"$ifNull": bson.A{sequence, 0}}},
+		},
+	}}
+
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{groupStage})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var result struct {
+			UserId   uint64 `bson:"_id"`
+			Sequence uint64 `bson:"sequence"`
+		}
+		if err := cur.Decode(&result); err != nil {
+			return err
+		}
+		lastSequence(sequences, result.UserId, result.Sequence)
+	}
+
+	return cur.Err()
+}
+
+// lastSequence raises the last ledger sequence of the user to sequence.
+func lastSequence(sequences map[uint64]uint64, userId uint64, sequence uint64) {
+	if sequence > sequences[userId] {
+		sequences[userId] = sequence
+	}
+}
@@ -0,0 +1,135 @@
+package repositories
+
+import (
+	"context"
+	"github.com/imdario/mergo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"guru/models"
+	"time"
+)
+
+// bucketMatch selects one user's records created within the filter window.
+func bucketMatch(filter models.StatisticFilterModel) bson.M {
+	createdAt := bson.M{"$gte": filter.From}
+	if !filter.To.IsZero() {
+		createdAt["$lt"] = filter.To
+	}
+
+	return bson.M{"user_id": filter.UserId, "created_at": createdAt}
+}
+
+// statisticKey groups records by user and currency.
+func statisticKey() bson.M {
+	return bson.M{"user_id": "$user_id", "currency": "$currency"}
+}
+
+// bucketKey groups records by currency and the start of their UTC bucket.
+func bucketKey(filter models.StatisticFilterModel) bson.M {
+	return bson.M{
+		"start":    bson.M{"$dateTrunc": bson.M{"date": "$created_at", "unit": filter.Granularity, "timezone": "UTC"}},
+		"currency": "$currency",
+	}
+}
+
+// gameStatisticFor returns the statistic of the game of a transaction when it falls within the filter window.
+// Transactions without a provider or game are not counted.
+func gameStatisticFor(games map[models.GameStatisticKeyModel]*models.GameStatisticModel, filter models.StatisticFilterModel, transaction models.TransactionModel) *models.GameStatisticModel {
+	if transaction.Provider == "" && transaction.GameId == "" {
+		return nil
+	}
+	if !inWindow(filter, transaction.UserId, transaction.CreatedAt) {
+		return nil
+	}
+
+	game := models.GameStatisticModel{Provider: transaction.Provider, GameId: transaction.GameId, Currency: transaction.Currency}
+	if _, ok := games[game.Key()]; !ok {
+		games[game.Key()] = &game
+	}
+
+	return games[game.Key()]
+}
+
+// inWindow reports whether a record of the user was created within the filter window.
+func inWindow(filter models.StatisticFilterModel, userId uint64, createdAt time.Time) bool {
+	if userId != filter.UserId || createdAt.Before(filter.From) {
+		return false
+	}
+
+	return filter.To.IsZero() || createdAt.Before(filter.To)
+}
+
+// windowSum adds up the value of the records of one wallet created after from.
+func windowSum(collection *mongo.Collection, userId uint64, currency string, from time.Time, value interface{}) (models.Money, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	matchStage := bson.D{{
+		Key:   "$match",
+		Value: bson.M{"user_id": userId, "currency": currency, "created_at": bson.M{"$gt": from}},
+	}}
+	groupStage := bson.D{{Key: "$group", Value: bson.M{"_id": nil, "sum": bson.M{"$sum": value}}}}
+
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	var result struct {
+		Sum models.Money `bson:"sum"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+
+	return result.Sum, cur.Err()
+}
+
+func decodeBuckets(ctx context.Context, cur *mongo.Cursor, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error {
+	defer cur.Close(ctx)
+
+	results := make(map[models.StatisticBucketKeyModel]*models.StatisticBucketModel)
+	for cur.Next(ctx) {
+		var result struct {
+			Key struct {
+				Start    time.Time `bson:"start"`
+				Currency string    `bson:"currency"`
+			} `bson:"_id"`
+			models.StatisticBucketModel `bson:",inline"`
+		}
+		if err := cur.Decode(&result); err != nil {
+			return err
+		}
+
+		bucket := result.StatisticBucketModel
+		bucket.Start = result.Key.Start
+		bucket.Currency = result.Key.Currency
+		results[bucket.Key()] = &bucket
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	if err := mergo.Merge(&buckets, results); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// bucketFor returns the bucket containing createdAt when it falls within the filter window.
+func bucketFor(buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel, filter models.StatisticFilterModel, userId uint64, currency string, createdAt time.Time) *models.StatisticBucketModel {
+	if !inWindow(filter, userId, createdAt) {
+		return nil
+	}
+
+	bucket := models.StatisticBucketModel{Start: models.TruncateTime(createdAt, filter.Granularity), Currency: currency}
+	if _, ok := buckets[bucket.Key()]; !ok {
+		buckets[bucket.Key()] = &bucket
+	}
+
+	return buckets[bucket.Key()]
+}
@@ -2,39 +2,66 @@ package repositories
 
 import (
 	"context"
+	"errors"
 	"github.com/imdario/mergo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"guru/models"
 	"time"
 )
 
 const TransactionCollection = "transaction"
 
-type TransactionRepository struct {
+type TransactionRepository interface {
+	FindAllBet(statistic map[models.StatisticKeyModel]*models.StatisticModel) error
+	FindAllWin(statistic map[models.StatisticKeyModel]*models.StatisticModel) error
+	FindBetBuckets(filter models.StatisticFilterModel, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error
+	FindWinBuckets(filter models.StatisticFilterModel, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error
+	FindById(id uint64) (*models.TransactionModel, error)
+	// FindByExternalId returns the transaction a provider booked under its own id.
+	FindByExternalId(provider string, externalId string) (*models.TransactionModel, error)
+	FindGameStatistics(filter models.StatisticFilterModel, games map[models.GameStatisticKeyModel]*models.GameStatisticModel) error
+	FindHistory(filter models.HistoryFilterModel) ([]models.TransactionModel, error)
+	// FindLastSequences raises the last ledger sequence of every user in sequences to the highest of its rows.
+	FindLastSequences(sequences map[uint64]uint64) error
+	// FindLastProviderId returns the highest id of the transactions booked by providers, 0 when there are none.
+	FindLastProviderId() (uint64, error)
+	// FindLossSum returns the net real money lost in the wallet after from; bonus parts of transactions do not count.
+	FindLossSum(userId uint64, currency string, from time.Time) (models.Money, error)
+	FindRollback(originalId uint64) (*models.TransactionModel, error)
+	Insert(transactionModel models.TransactionModel) error
+	// InsertMany inserts the transactions in order up to the first one that fails and returns how many it
+	// stored, with the error of the one that failed. The count is -1 when it cannot tell how many were stored.
+	InsertMany(transactionModels []models.TransactionModel) (int, error)
+}
+
+type MongoTransactionRepository struct {
 	DB *mongo.Database
 }
 
-func (r *TransactionRepository) FindAllBet(statistic map[uint64]*models.StatisticModel) error {
+func (r *MongoTransactionRepository) FindAllBet(statistic map[models.StatisticKeyModel]*models.StatisticModel) error {
 	collection := r.DB.Collection(TransactionCollection)
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	matchStage := bson.D{{"$match", bson.D{{"type", models.TypeBet}}}}
+	matchStage := bson.D{{Key: "$match", Value: bson.M{"type": models.TypeBet}}}
+	lookupStage, notRolledBackStage := rollbackStages()
 	groupStage := bson.D{{
-		"$group",
-		bson.D{
-			{"_id", "$user_id"},
-			{"bet_sum", bson.D{{"$sum", "$amount"}}},
-			{"bet_count", bson.D{{"$sum", 1}}},
+		Key: "$group",
+		Value: bson.M{
+			"_id":       statisticKey(),
+			"bet_sum":   bson.M{"$sum": "$amount"},
+			"bet_count": bson.M{"$sum": 1},
 		},
 	}}
 
-	cur, err := collection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, notRolledBackStage, groupStage})
 	if err != nil {
 		return err
 	}
 
-	results := make(map[uint64]*models.StatisticModel)
+	results := make(map[models.StatisticKeyModel]*models.StatisticModel)
 	for cur.Next(ctx) {
 		var result models.StatisticModel
 		if err := cur.Decode(&result); err != nil {
@@ -55,26 +82,28 @@ func (r *TransactionRepository) FindAllBet(statistic map[uint64]*models.Statisti
 	return nil
 }
 
-func (r *TransactionRepository) FindAllWin(statistic map[uint64]*models.StatisticModel) error {
+func (r *MongoTransactionRepository) FindAllWin(statistic map[models.StatisticKeyModel]*models.StatisticModel) error {
 	collection := r.DB.Collection(TransactionCollection)
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	matchStage := bson.D{{"$match", bson.D{{"type", models.TypeWin}}}}
+	matchStage := bson.D{{Key: "$match", Value: bson.M{"type": models.TypeWin}}}
+	lookupStage, notRolledBackStage := rollbackStages()
 	groupStage := bson.D{{
-		"$group",
-		bson.D{
-			{"_id", "$user_id"},
-			{"win_sum", bson.D{{"$sum", "$amount"}}},
-			{"win_count", bson.D{{"$sum", 1}}},
+		Key: "$group",
+		Value: bson.M{
+			"_id":       statisticKey(),
+			"win_sum":   bson.M{"$sum": "$amount"},
+			"win_count": bson.M{"$sum": 1},
 		},
 	}}
 
-	cur, err := collection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, notRolledBackStage, groupStage})
 	if err != nil {
 		return err
 	}
 
-	results := make(map[uint64]*models.StatisticModel)
+	results := make(map[models.StatisticKeyModel]*models.StatisticModel)
 	for cur.Next(ctx) {
 		var result models.StatisticModel
 		if err := cur.Decode(&result); err != nil {
@@ -95,13 +124,314 @@ func (r *TransactionRepository) FindAllWin(statistic map[uint64]*models.Statisti
 	return nil
 }
 
-func (r *TransactionRepository) Insert(transactionModel models.TransactionModel) error {
+func (r *MongoTransactionRepository) FindBetBuckets(filter models.StatisticFilterModel, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error {
+	collection := r.DB.Collection(TransactionCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	match := bucketMatch(filter)
+	match["type"] = models.TypeBet
+	matchStage := bson.D{{Key: "$match", Value: match}}
+	lookupStage, notRolledBackStage := rollbackStages()
+	groupStage := bson.D{{
+		Key: "$group",
+		Value: bson.M{
+			"_id":       bucketKey(filter),
+			"bet_sum":   bson.M{"$sum": "$amount"},
+			"bet_count": bson.M{"$sum": 1},
+		},
+	}}
+
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, notRolledBackStage, groupStage})
+	if err != nil {
+		return err
+	}
+
+	return decodeBuckets(ctx, cur, buckets)
+}
+
+func (r *MongoTransactionRepository) FindWinBuckets(filter models.StatisticFilterModel, buckets map[models.StatisticBucketKeyModel]*models.StatisticBucketModel) error {
+	collection := r.DB.Collection(TransactionCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	match := bucketMatch(filter)
+	match["type"] = models.TypeWin
+	matchStage := bson.D{{Key: "$match", Value: match}}
+	lookupStage, notRolledBackStage := rollbackStages()
+	groupStage := bson.D{{
+		Key: "$group",
+		Value: bson.M{
+			"_id":       bucketKey(filter),
+			"win_sum":   bson.M{"$sum": "$amount"},
+			"win_count": bson.M{"$sum": 1},
+		},
+	}}
+
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, notRolledBackStage, groupStage})
+	if err != nil {
+		return err
+	}
+
+	return decodeBuckets(ctx, cur, buckets)
+}
+
+func (r *MongoTransactionRepository) FindById(id uint64) (*models.TransactionModel, error) {
+	collection := r.DB.Collection(TransactionCollection)
+
+	var result models.TransactionModel
+	err := collection.FindOne(context.TODO(), bson.M{"id": id}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *MongoTransactionRepository) FindByExternalId(provider string, externalId string) (*models.TransactionModel, error) {
+	collection := r.DB.Collection(TransactionCollection)
+
+	var result models.TransactionModel
+	err := collection.FindOne(context.TODO(), bson.M{"provider": provider, "external_id": externalId}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *MongoTransactionRepository) FindGameStatistics(filter models.StatisticFilterModel, games map[models.GameStatisticKeyModel]*models.GameStatisticModel) error {
+	collection := r.DB.Collection(TransactionCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	match := bucketMatch(filter)
+	match["type"] = bson.M{"$in": bson.A{models.TypeBet, models.TypeWin}}
+	match["$or"] = bson.A{bson.M{"provider": bson.M{"$exists": true}}, bson.M{"game_id": bson.M{"$exists": true}}}
+	matchStage := bson.D{{Key: "$match", Value: match}}
+	lookupStage, notRolledBackStage := rollbackStages()
+	isBet := bson.M{"$eq": bson.A{"$type", models.TypeBet}}
+	isWin := bson.M{"$eq": bson.A{"$type", models.TypeWin}}
+	groupStage := bson.D{{
+		Key: "$group",
+		Value: bson.M{
+			"_id":       bson.M{"provider": "$provider", "game_id": "$game_id", "currency": "$currency"},
+			"bet_count": bson.M{"$sum": bson.M{"$cond": bson.A{isBet, 1, 0}}},
+			"bet_sum":   bson.M{"$sum": bson.M{"$cond": bson.A{isBet, "$amount", 0}}},
+			"win_count": bson.M{"$sum": bson.M{"$cond": bson.A{isWin, 1, 0}}},
+			"win_sum":   bson.M{"$sum": bson.M{"$cond": bson.A{isWin, "$amount", 0}}},
+		},
+	}}
+
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{matchStage, lookupStage, notRolledBackStage, groupStage})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var result struct {
+			Key                       models.GameStatisticModel `bson:"_id"`
+			models.GameStatisticModel `bson:",inline"`
+		}
+		if err := cur.Decode(&result); err != nil {
+			return err
+		}
+
+		game := result.GameStatisticModel
+		game.Provider = result.Key.Provider
+		game.GameId = result.Key.GameId
+		game.Currency = result.Key.Currency
+		games[game.Key()] = &game
+	}
+
+	return cur.Err()
+}
+
+func (r *MongoTransactionRepository) FindRollback(originalId uint64) (*models.TransactionModel, error) {
+	collection := r.DB.Collection(TransactionCollection)
+	filter := bson.M{"type": models.TypeRollback, "original_transaction_id": originalId}
+
+	var result models.TransactionModel
+	err := collection.FindOne(context.TODO(), filter).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *MongoTransactionRepository) FindHistory(filter models.HistoryFilterModel) ([]models.TransactionModel, error) {
+	collection := r.DB.Collection(TransactionCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query := historyQuery(filter, models.HistorySourceTransaction)
+
+	var types []string
+	for _, t := range filter.Types {
+		if t != models.TypeDeposit {
+			types = append(types, t)
+		}
+	}
+	if len(types) > 0 {
+		query["type"] = bson.M{"$in": types}
+	}
+
+	cur, err := collection.Find(ctx, query, historyOptions(filter))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make([]models.TransactionModel, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *MongoTransactionRepository) FindLossSum(userId uint64, currency string, from time.Time) (models.Money, error) {
+	loss := bson.M{"$subtract": bson.A{"$balance_before", "$balance_after"}}
+
+	return windowSum(r.DB.Collection(TransactionCollection), userId, currency, from, loss)
+}
+
+func (r *MongoTransactionRepository) Insert(transactionModel models.TransactionModel) error {
 	collection := r.DB.Collection(TransactionCollection)
 
 	_, err := collection.InsertOne(context.TODO(), transactionModel)
+	if isDuplicateKey(err) {
+		return ErrDuplicate
+	}
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func (r *MongoTransactionRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	return findLastSequences(r.DB.Collection(TransactionCollection), "$sequence", sequences)
+}
+
+func (r *MongoTransactionRepository) FindLastProviderId() (uint64, error) {
+	collection := r.DB.Collection(TransactionCollection)
+
+	var result models.TransactionModel
+	findOptions := options.FindOne().SetSort(bson.M{"id": -1})
+	err := collection.FindOne(context.TODO(), bson.M{"external_id": bson.M{"$exists": true}}, findOptions).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Id, nil
+}
+
+func (r *MongoTransactionRepository) InsertMany(transactionModels []models.TransactionModel) (int, error) {
+	collection := r.DB.Collection(TransactionCollection)
+
+	_, err := collection.InsertMany(context.TODO(), transactionDocuments(transactionModels))
+	if err == nil {
+		return len(transactionModels), nil
+	}
+
+	var bulkWriteException mongo.BulkWriteException
+	if errors.As(err, &bulkWriteException) && bulkWriteException.WriteConcernError == nil && len(bulkWriteException.WriteErrors) > 0 {
+		writeError := bulkWriteException.WriteErrors[0]
+		if writeError.Code == duplicateKeyCode {
+			return writeError.Index, ErrDuplicate
+		}
+		return writeError.Index, writeError
+	}
+
+	// The write may have stored any number of the transactions; look them up.
+	stored, findErr := r.findStored(transactionModels)
+	if findErr != nil {
+		return -1, err
+	}
+	for i := range transactionModels {
+		if !stored[i] {
+			return i, err
+		}
+	}
+
+	return len(transactionModels), nil
+}
+
+// findStored reports which of the transactions are stored, as they are and not as other transactions with their id.
+func (r *MongoTransactionRepository) findStored(transactionModels []models.TransactionModel) ([]bool, error) {
+	ids := make([]uint64, len(transactionModels))
+	for i, transaction := range transactionModels {
+		ids[i] = transaction.Id
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cur, err := r.DB.Collection(TransactionCollection).Find(ctx, bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var results []models.TransactionModel
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	found := make(map[uint64]models.TransactionModel, len(results))
+	for _, result := range results {
+		found[result.Id] = result
+	}
+	stored := make([]bool, len(transactionModels))
+	for i, transaction := range transactionModels {
+		result, ok := found[transaction.Id]
+		stored[i] = ok && sameTransaction(result, transaction)
+	}
+
+	return stored, nil
+}
+
+// sameTransaction compares the ledger fields of two transactions; stored times lose their precision.
+func sameTransaction(a models.TransactionModel, b models.TransactionModel) bool {
+	return a.Id == b.Id && a.UserId == b.UserId && a.Currency == b.Currency && a.Type == b.Type &&
+		a.Amount == b.Amount && a.BalanceBefore == b.BalanceBefore && a.BalanceAfter == b.BalanceAfter
+}
+
+func transactionDocuments(transactionModels []models.TransactionModel) []interface{} {
+	documents := make([]interface{}, len(transactionModels))
+	for i := range transactionModels {
+		documents[i] = transactionModels[i]
+	}
+
+	return documents
+}
+
+// rollbackStages joins every transaction with its rollback and keeps only those that were not rolled back.
+func rollbackStages() (bson.D, bson.D) {
+	lookupStage := bson.D{{
+		Key: "$lookup",
+		Value: bson.M{
+			"from":         TransactionCollection,
+			"localField":   "id",
+			"foreignField": "original_transaction_id",
+			"as":           "rollback",
+		},
+	}}
+	matchStage := bson.D{{Key: "$match", Value: bson.M{"rollback": bson.M{"$size": 0}}}}
+
+	return lookupStage, matchStage
+}
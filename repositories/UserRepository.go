@@ -10,13 +10,24 @@ import (
 
 const userCollection = "user"
 
-type UserRepository struct {
+type UserRepository interface {
+	// FindAll loads every user; users stored with a plaintext token get it replaced by its hash.
+	FindAll(users map[uint64]*models.UserModel) error
+	Insert(users []models.UserModel) error
+	Update(user *models.UserModel) error
+}
+
+type MongoUserRepository struct {
 	DB *mongo.Database
+	// ReadOnly hashes plaintext tokens in memory only, leaving the stored users untouched.
+	ReadOnly bool
 }
 
-func (r *UserRepository) FindAll(users map[uint64]*models.UserModel) error {
+func (r *MongoUserRepository) FindAll(users map[uint64]*models.UserModel) error {
 	collection := r.DB.Collection(userCollection)
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
 	cur, err := collection.Find(ctx, bson.D{})
 	if err != nil {
 		return err
@@ -28,6 +39,15 @@ func (r *UserRepository) FindAll(users map[uint64]*models.UserModel) error {
 		if err := cur.Decode(&result); err != nil {
 			return err
 		}
+		if result.Token != "" && r.ReadOnly {
+			if err := result.HashToken(); err != nil {
+				return err
+			}
+		} else if result.Token != "" {
+			if err := r.hashToken(ctx, &result); err != nil {
+				return err
+			}
+		}
 		users[result.Id] = &result
 	}
 	if err := cur.Err(); err != nil {
@@ -37,9 +57,33 @@ func (r *UserRepository) FindAll(users map[uint64]*models.UserModel) error {
 	return nil
 }
 
-func (r *UserRepository) Insert(users []interface{}) error {
+// hashToken stores the hash of the plaintext token of a user stored before tokens were hashed, and drops the token.
+func (r *MongoUserRepository) hashToken(ctx context.Context, user *models.UserModel) error {
+	if err := user.HashToken(); err != nil {
+		return err
+	}
+
+	collection := r.DB.Collection(userCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"id": user.Id}, bson.M{
+		"$set":   bson.M{"token_hash": user.TokenHash},
+		"$unset": bson.M{"token": ""},
+	})
+
+	return err
+}
+
+func (r *MongoUserRepository) Insert(users []models.UserModel) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		documents = append(documents, user)
+	}
+
 	collection := r.DB.Collection(userCollection)
-	_, err := collection.InsertMany(context.TODO(), users)
+	_, err := collection.InsertMany(context.TODO(), documents)
 	if err != nil {
 		return err
 	}
@@ -47,11 +91,11 @@ func (r *UserRepository) Insert(users []interface{}) error {
 	return nil
 }
 
-func (r *UserRepository) Update(user *models.UserModel) error {
+func (r *MongoUserRepository) Update(user *models.UserModel) error {
 	collection := r.DB.Collection(userCollection)
-	filter := bson.D{{"id", user.Id}}
+	filter := bson.M{"id": user.Id}
 
-	_, err := collection.UpdateOne(context.TODO(), filter, user)
+	_, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": user})
 	if err != nil {
 		return err
 	}
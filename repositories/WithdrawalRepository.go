@@ -0,0 +1,138 @@
+package repositories
+
+import (
+	"context"
+	"github.com/imdario/mergo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"guru/models"
+	"time"
+)
+
+const withdrawalCollection = "withdrawal"
+
+type WithdrawalRepository interface {
+	FindAllWithdrawal(statistic map[models.StatisticKeyModel]*models.StatisticModel) error
+	FindById(id uint64) (*models.WithdrawalModel, error)
+	FindByUserId(userId uint64) ([]models.WithdrawalModel, error)
+	// FindLastSequences raises the last ledger sequence of every user in sequences to the highest of its rows.
+	FindLastSequences(sequences map[uint64]uint64) error
+	Insert(withdrawalModel models.WithdrawalModel) error
+	// Update settles a pending withdrawal; it returns ErrNotFound when no pending withdrawal has the id,
+	// so a withdrawal cannot be settled twice.
+	Update(withdrawalModel models.WithdrawalModel) error
+}
+
+type MongoWithdrawalRepository struct {
+	DB *mongo.Database
+}
+
+func (r *MongoWithdrawalRepository) FindAllWithdrawal(statistic map[models.StatisticKeyModel]*models.StatisticModel) error {
+	collection := r.DB.Collection(withdrawalCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	approved := bson.M{"$eq": bson.A{"$status", models.WithdrawalApproved}}
+	pending := bson.M{"$eq": bson.A{"$status", models.WithdrawalPending}}
+	groupStage := bson.D{{
+		Key: "$group",
+		Value: bson.M{
+			"_id":                    statisticKey(),
+			"withdrawal_count":       bson.M{"$sum": bson.M{"$cond": bson.A{approved, 1, 0}}},
+			"withdrawal_sum":         bson.M{"$sum": bson.M{"$cond": bson.A{approved, "$amount", 0}}},
+			"pending_withdrawal_sum": bson.M{"$sum": bson.M{"$cond": bson.A{pending, "$amount", 0}}},
+		},
+	}}
+
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{groupStage})
+	if err != nil {
+		return err
+	}
+
+	results := make(map[models.StatisticKeyModel]*models.StatisticModel)
+	for cur.Next(ctx) {
+		var result models.StatisticModel
+		if err := cur.Decode(&result); err != nil {
+			return err
+		}
+
+		results[result.Id] = &result
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	if err := mergo.Merge(&statistic, results); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *MongoWithdrawalRepository) FindById(id uint64) (*models.WithdrawalModel, error) {
+	collection := r.DB.Collection(withdrawalCollection)
+
+	var result models.WithdrawalModel
+	err := collection.FindOne(context.TODO(), bson.M{"id": id}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (r *MongoWithdrawalRepository) FindByUserId(userId uint64) ([]models.WithdrawalModel, error) {
+	collection := r.DB.Collection(withdrawalCollection)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, bson.M{"user_id": userId})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := make([]models.WithdrawalModel, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *MongoWithdrawalRepository) FindLastSequences(sequences map[uint64]uint64) error {
+	sequence := bson.M{"$max": bson.A{"$sequence", "$release_sequence"}}
+	return findLastSequences(r.DB.Collection(withdrawalCollection), sequence, sequences)
+}
+
+func (r *MongoWithdrawalRepository) Insert(withdrawalModel models.WithdrawalModel) error {
+	collection := r.DB.Collection(withdrawalCollection)
+
+	_, err := collection.InsertOne(context.TODO(), withdrawalModel)
+	if isDuplicateKey(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *MongoWithdrawalRepository) Update(withdrawalModel models.WithdrawalModel) error {
+	collection := r.DB.Collection(withdrawalCollection)
+	filter := bson.M{"id": withdrawalModel.Id, "status": models.WithdrawalPending}
+
+	result, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": withdrawalModel})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
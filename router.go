@@ -3,26 +3,69 @@ package main
 import (
 	"github.com/gorilla/mux"
 	"guru/handlers"
+	"guru/models"
 	"net/http"
 )
 
 type router struct {
-	userHandler *handlers.UserHandler
+	userHandler        *handlers.UserHandler
 	transactionHandler *handlers.TransactionHandler
+	withdrawalHandler  *handlers.WithdrawalHandler
+	adminHandler       *handlers.AdminHandler
+	providerHandlers   []*handlers.ProviderHandler
+	signatureVerifier  *handlers.SignatureVerifier
+	auditTrail         *handlers.AuditTrail
 }
 
 func (router router) InitRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(handlers.RequestId)
 
 	fs := http.FileServer(http.Dir("./swaggerui/"))
 	r.PathPrefix("/swaggerui/").Handler(http.StripPrefix("/swaggerui/", fs))
 
+	player := router.auditTrail.Middleware(models.CallerPlayer, "")
+	payment := router.auditTrail.Middleware(models.CallerPayment, "")
+
 	s := r.PathPrefix("/user").Subrouter()
-	s.HandleFunc("/create", router.userHandler.Create).Methods(http.MethodPost)
+	s.Handle("/create", player(http.HandlerFunc(router.userHandler.Create))).Methods(http.MethodPost)
 	s.HandleFunc("/get", router.userHandler.Get).Methods(http.MethodPost)
-	s.HandleFunc("/deposit", router.userHandler.AddDeposit).Methods(http.MethodPost)
+	s.Handle("/deposit", player(http.HandlerFunc(router.userHandler.AddDeposit))).Methods(http.MethodPost)
+	s.HandleFunc("/history", router.userHandler.History).Methods(http.MethodPost)
+	s.HandleFunc("/statistics", router.userHandler.Statistics).Methods(http.MethodPost)
+	s.HandleFunc("/limits", router.userHandler.SetLimit).Methods(http.MethodPost)
+	s.HandleFunc("/status", router.userHandler.SetStatus).Methods(http.MethodPost)
+	s.HandleFunc("/token", router.userHandler.RotateToken).Methods(http.MethodPost)
+	s.Handle("/withdrawal", player(http.HandlerFunc(router.withdrawalHandler.Withdraw))).Methods(http.MethodPost)
+
+	r.Handle("/transaction", player(http.HandlerFunc(router.transactionHandler.Transaction))).Methods(http.MethodPost)
+	r.Handle("/transaction/batch", player(http.HandlerFunc(router.transactionHandler.BatchTransaction))).Methods(http.MethodPost)
+	r.HandleFunc("/round/close", router.transactionHandler.CloseRound).Methods(http.MethodPost)
+	r.Handle("/withdrawal/settle", payment(http.HandlerFunc(router.withdrawalHandler.Settle))).Methods(http.MethodPost)
+
+	a := r.PathPrefix("/admin").Subrouter()
+	a.Use(router.auditTrail.Middleware(models.CallerAdmin, ""))
+	a.Use(router.adminHandler.Middleware)
+	a.HandleFunc("/reconcile", router.adminHandler.Reconcile).Methods(http.MethodPost)
+	a.HandleFunc("/bonus/grant", router.adminHandler.GrantBonus).Methods(http.MethodPost)
+	a.HandleFunc("/users/search", router.adminHandler.SearchUsers).Methods(http.MethodPost)
+	a.HandleFunc("/user/get", router.adminHandler.GetUser).Methods(http.MethodPost)
+	a.HandleFunc("/user/history", router.adminHandler.History).Methods(http.MethodPost)
+	a.HandleFunc("/user/adjust", router.adminHandler.AdjustBalance).Methods(http.MethodPost)
+	a.HandleFunc("/user/freeze", router.adminHandler.Freeze).Methods(http.MethodPost)
+	a.HandleFunc("/user/status", router.adminHandler.ChangeStatus).Methods(http.MethodPost)
+	a.HandleFunc("/audit/search", router.adminHandler.SearchAudit).Methods(http.MethodPost)
+	a.HandleFunc("/audit/verify", router.adminHandler.VerifyAudit).Methods(http.MethodPost)
 
-	r.HandleFunc("/transaction", router.transactionHandler.Transaction).Methods(http.MethodPost)
+	for _, providerHandler := range router.providerHandlers {
+		adapter := providerHandler.Adapter()
+		p := r.PathPrefix("/provider/" + adapter.Provider()).Subrouter()
+		p.Use(router.auditTrail.Middleware(models.CallerProvider, adapter.Provider()))
+		p.Use(router.signatureVerifier.Middleware(adapter.Provider()))
+		for _, action := range models.ProviderActions {
+			p.HandleFunc(adapter.Path(action), providerHandler.Handle(action)).Methods(http.MethodPost)
+		}
+	}
 
 	return r
 }
@@ -0,0 +1,92 @@
+package rpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+	"guru/models"
+	"guru/services"
+	"net"
+)
+
+// auditedMethods are the balance-affecting calls, audited like their HTTP counterparts.
+var auditedMethods = map[string]bool{
+	"/guru.v1.WalletService/CreateUser":  true,
+	"/guru.v1.WalletService/AddDeposit":  true,
+	"/guru.v1.WalletService/Transaction": true,
+}
+
+// AuditTrail writes every call of the audited methods, failed ones included, to the audit trail.
+type AuditTrail struct {
+	service *services.UserService
+}
+
+func NewAuditTrail(service *services.UserService) *AuditTrail {
+	return &AuditTrail{service: service}
+}
+
+// Interceptor audits the calls as player calls. The status of an entry is the gRPC code of the call.
+func (t *AuditTrail) Interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !auditedMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	var payload []byte
+	if message, ok := req.(proto.Message); ok {
+		payload, _ = proto.Marshal(message)
+	}
+	payloadHash := sha256.Sum256(payload)
+	entry := models.AuditModel{
+		Endpoint:    info.FullMethod,
+		Caller:      models.CallerPlayer,
+		UserId:      auditUser(req),
+		SourceIp:    sourceIp(ctx),
+		RequestId:   requestId(ctx),
+		PayloadHash: hex.EncodeToString(payloadHash[:]),
+		Result:      models.AuditSuccess,
+	}
+
+	res, err := handler(ctx, req)
+	if err != nil {
+		domainError := services.AsError(err)
+		entry.Status = int(errorCodes[domainError.Kind])
+		entry.Result = models.AuditFailure
+		entry.Error = domainError.Message
+		entry.ErrorCode = domainError.Code
+	}
+	if err := t.service.Audit(entry); err != nil {
+		zap.L().Error(err.Error(), zap.String("endpoint", entry.Endpoint), zap.String("request_id", entry.RequestId))
+	}
+
+	return res, err
+}
+
+// auditUser returns the user of a request.
+func auditUser(req interface{}) uint64 {
+	switch r := req.(type) {
+	case interface{ GetUserId() uint64 }:
+		return r.GetUserId()
+	case *CreateUserRequest:
+		return r.GetId()
+	}
+
+	return 0
+}
+
+// sourceIp returns the address of the peer of the call.
+func sourceIp(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
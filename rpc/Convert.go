@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"guru/models"
+	"time"
+)
+
+// fromTimestamp returns the time of an optional timestamp, the zero time when it is absent.
+func fromTimestamp(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return t.AsTime()
+}
+
+// toTimestamp returns the timestamp of a time, none for the zero time.
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(t)
+}
+
+func toGetUserResponse(r *models.GetUserResponseModel) *GetUserResponse {
+	res := &GetUserResponse{Id: r.Id, Status: r.Status}
+	if r.ExcludedUntil != nil {
+		res.ExcludedUntil = toTimestamp(*r.ExcludedUntil)
+	}
+	for _, wallet := range r.Wallets {
+		res.Wallets = append(res.Wallets, toWallet(wallet))
+	}
+	for _, limit := range r.Limits {
+		res.Limits = append(res.Limits, toLimit(limit))
+	}
+	for _, bucket := range r.Buckets {
+		res.Buckets = append(res.Buckets, &StatisticBucket{
+			Start:        toTimestamp(bucket.Start),
+			Currency:     bucket.Currency,
+			DepositCount: int64(bucket.DepositCount),
+			DepositSum:   bucket.DepositSum.FormatCurrency(bucket.Currency),
+			BetCount:     int64(bucket.BetCount),
+			BetSum:       bucket.BetSum.FormatCurrency(bucket.Currency),
+			WinCount:     int64(bucket.WinCount),
+			WinSum:       bucket.WinSum.FormatCurrency(bucket.Currency),
+		})
+	}
+
+	return res
+}
+
+func toWallet(w models.WalletResponseModel) *Wallet {
+	wallet := &Wallet{
+		Currency:             w.Currency,
+		Balance:              w.Balance.FormatCurrency(w.Currency),
+		DepositCount:         int64(w.DepositCount),
+		DepositSum:           w.DepositSum.FormatCurrency(w.Currency),
+		BetCount:             int64(w.BetCount),
+		BetSum:               w.BetSum.FormatCurrency(w.Currency),
+		WinCount:             int64(w.WinCount),
+		WinSum:               w.WinSum.FormatCurrency(w.Currency),
+		WithdrawalCount:      int64(w.WithdrawalCount),
+		WithdrawalSum:        w.WithdrawalSum.FormatCurrency(w.Currency),
+		PendingWithdrawalSum: w.PendingWithdrawalSum.FormatCurrency(w.Currency),
+	}
+	if b := w.Bonus; b != nil {
+		wallet.Bonus = &Bonus{
+			Id:             b.Id,
+			Currency:       b.Currency,
+			Amount:         b.Amount.FormatCurrency(b.Currency),
+			Balance:        b.Balance.FormatCurrency(b.Currency),
+			WageringTarget: b.WageringTarget.FormatCurrency(b.Currency),
+			Wagered:        b.Wagered.FormatCurrency(b.Currency),
+			Status:         b.Status,
+			ExpiresAt:      toTimestamp(b.ExpiresAt),
+		}
+	}
+
+	return wallet
+}
+
+// toLimit converts a limit like its JSON: the pending fields are set only for a pending change.
+func toLimit(l models.LimitModel) *Limit {
+	limit := &Limit{
+		Currency:  l.Currency,
+		Type:      l.Type,
+		Period:    l.Period,
+		Amount:    l.Amount.FormatCurrency(l.Currency),
+		UpdatedAt: toTimestamp(l.UpdatedAt),
+	}
+	if l.IsPending() {
+		limit.PendingRemoval = l.PendingRemoval
+		limit.PendingAt = toTimestamp(l.PendingAt)
+		if !l.PendingRemoval {
+			limit.PendingAmount = l.PendingAmount.FormatCurrency(l.Currency)
+		}
+	}
+
+	return limit
+}
+
+func toTransactionResponse(r *models.TransactionResponseModel) *TransactionResponse {
+	return &TransactionResponse{Currency: r.Currency, Balance: r.Balance.FormatCurrency(r.Currency)}
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"guru/services"
+)
+
+const (
+	requestIdHeader    = "x-request-id"
+	errorCodeTrailer   = "error-code"
+	maxRequestIdLength = 128
+)
+
+// errorCodes maps the kinds of domain errors to gRPC codes, as handlers map them to HTTP statuses.
+var errorCodes = map[services.ErrorKind]codes.Code{
+	services.KindInternal:     codes.Internal,
+	services.KindInvalid:      codes.InvalidArgument,
+	services.KindUnauthorized: codes.Unauthenticated,
+	services.KindForbidden:    codes.PermissionDenied,
+	services.KindNotFound:     codes.NotFound,
+	services.KindConflict:     codes.AlreadyExists,
+}
+
+type requestIdKey struct{}
+
+// RequestId gives every call a request id, taken from the x-request-id metadata or generated, and echoes it in
+// the x-request-id header of the response.
+func RequestId(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIdHeader); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if id == "" || len(id) > maxRequestIdLength {
+		id = newRequestId()
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs(requestIdHeader, id)); err != nil {
+		zap.L().Error(err.Error())
+	}
+
+	return handler(context.WithValue(ctx, requestIdKey{}, id), req)
+}
+
+// requestId returns the request id given to the call by RequestId.
+func requestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+func newRequestId() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		zap.L().Error(err.Error())
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// Errors answers an error with the status of its domain error and the code of the domain error in the error-code
+// trailer. Errors that are not domain errors are reported as services.ErrInternal, their details are only logged.
+func Errors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+	if err == nil {
+		return res, nil
+	}
+
+	zap.L().Error(err.Error(), zap.String("method", info.FullMethod), zap.String("request_id", requestId(ctx)))
+	domainError := services.AsError(err)
+	if err := grpc.SetTrailer(ctx, metadata.Pairs(errorCodeTrailer, domainError.Code)); err != nil {
+		zap.L().Error(err.Error())
+	}
+
+	return nil, status.Error(errorCodes[domainError.Kind], domainError.Message)
+}
@@ -0,0 +1,129 @@
+package rpc
+
+import (
+	"context"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"guru/models"
+	"guru/services"
+)
+
+// WalletServer serves the player endpoints of the HTTP API over gRPC.
+type WalletServer struct {
+	UnimplementedWalletServiceServer
+	service   *services.UserService
+	validator *validator.Validate
+}
+
+func NewWalletServer(service *services.UserService) *WalletServer {
+	return &WalletServer{
+		service:   service,
+		validator: validator.New(),
+	}
+}
+
+// NewServer returns a gRPC server of the wallet with server reflection. Like the HTTP API, it gives every call
+// a request id, audits the balance-affecting calls and answers domain errors with their code.
+func NewServer(service *services.UserService) *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(RequestId, Errors, NewAuditTrail(service).Interceptor))
+	RegisterWalletServiceServer(server, NewWalletServer(service))
+	reflection.Register(server)
+
+	return server
+}
+
+func (s *WalletServer) CreateUser(ctx context.Context, req *CreateUserRequest) (*CreateUserResponse, error) {
+	wallets := make(models.Wallets, len(req.Wallets))
+	for currency, amount := range req.Wallets {
+		balance, err := models.ParseAmount(amount, currency)
+		if err != nil {
+			return nil, services.InvalidRequest(err)
+		}
+		wallets[currency] = balance
+	}
+	user := models.UserModel{Id: req.Id, Wallets: wallets, Token: req.Token}
+	if err := s.validator.Struct(&user); err != nil {
+		return nil, services.InvalidRequest(err)
+	}
+
+	if err := s.service.CreateUser(user.Id, user); err != nil {
+		return nil, err
+	}
+
+	return &CreateUserResponse{}, nil
+}
+
+func (s *WalletServer) GetUser(ctx context.Context, req *GetUserRequest) (*GetUserResponse, error) {
+	userRequest := models.GetUserRequestModel{
+		Id:          req.Id,
+		Token:       req.Token,
+		From:        fromTimestamp(req.From),
+		To:          fromTimestamp(req.To),
+		Granularity: req.Granularity,
+	}
+	if err := s.validator.Struct(&userRequest); err != nil {
+		return nil, services.InvalidRequest(err)
+	}
+
+	userResponse, err := s.service.GetUser(userRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return toGetUserResponse(userResponse), nil
+}
+
+func (s *WalletServer) AddDeposit(ctx context.Context, req *DepositRequest) (*TransactionResponse, error) {
+	amount, err := models.ParseAmount(req.Amount, req.Currency)
+	if err != nil {
+		return nil, services.InvalidRequest(err)
+	}
+	depositRequest := models.DepositRequestModel{
+		UserId:    req.UserId,
+		DepositId: req.DepositId,
+		Currency:  req.Currency,
+		Amount:    amount,
+		Token:     req.Token,
+	}
+	if err := s.validator.Struct(&depositRequest); err != nil {
+		return nil, services.InvalidRequest(err)
+	}
+
+	depositResponse, err := s.service.AddDeposit(depositRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return toTransactionResponse(depositResponse), nil
+}
+
+func (s *WalletServer) Transaction(ctx context.Context, req *TransactionRequest) (*TransactionResponse, error) {
+	amount, err := models.ParseAmount(req.Amount, req.Currency)
+	if err != nil {
+		return nil, services.InvalidRequest(err)
+	}
+	transactionRequest := models.TransactionRequestModel{
+		UserId:                req.UserId,
+		TransactionId:         req.TransactionId,
+		Type:                  req.Type,
+		Currency:              req.Currency,
+		Amount:                amount,
+		Token:                 req.Token,
+		OriginalTransactionId: req.OriginalTransactionId,
+		RoundId:               req.RoundId,
+		GameId:                req.GameId,
+		Provider:              req.Provider,
+		CloseRound:            req.CloseRound,
+	}
+	if err := s.validator.Struct(&transactionRequest); err != nil {
+		return nil, services.InvalidRequest(err)
+	}
+
+	transactionResponse, err := s.service.Transaction(transactionRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return toTransactionResponse(transactionResponse), nil
+}
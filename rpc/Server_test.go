@@ -0,0 +1,192 @@
+package rpc
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"guru/models"
+	"guru/repositories"
+	"guru/services"
+	"net"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+
+// newTestClient serves a wallet with one user, 1 with 50.00 EUR, on an in-process listener.
+func newTestClient(t *testing.T) (WalletServiceClient, *services.UserService, *grpc.ClientConn) {
+	t.Helper()
+	service := &services.UserService{
+		UserRepository:        repositories.NewMemoryUserRepository(models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 5000}, Token: "sssss"}),
+		DepositRepository:     repositories.NewMemoryDepositRepository(),
+		TransactionRepository: repositories.NewMemoryTransactionRepository(),
+		WithdrawalRepository:  repositories.NewMemoryWithdrawalRepository(),
+		BonusRepository:       repositories.NewMemoryBonusRepository(),
+		LimitRepository:       repositories.NewMemoryLimitRepository(),
+		RoundRepository:       repositories.NewMemoryRoundRepository(),
+		AdjustmentRepository:  repositories.NewMemoryAdjustmentRepository(),
+		AuditRepository:       repositories.NewMemoryAuditRepository(),
+		Journal:               repositories.NewMemoryJournalRepository(),
+		Clock:                 func() time.Time { return now },
+	}
+	if err := service.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewServer(service)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return NewWalletServiceClient(conn), service, conn
+}
+
+func TestWalletServer(t *testing.T) {
+	client, _, _ := newTestClient(t)
+	ctx := context.Background()
+
+	_, err := client.CreateUser(ctx, &CreateUserRequest{Id: 2, Wallets: map[string]string{"EUR": "10.00", "JPY": "500"}, Token: "token"})
+	assert.Nil(t, err)
+
+	depositResponse, err := client.AddDeposit(ctx, &DepositRequest{UserId: 2, DepositId: 1, Currency: "EUR", Amount: "2.50", Token: "token"})
+	assert.Nil(t, err)
+	assert.Equal(t, "EUR", depositResponse.Currency)
+	assert.Equal(t, "12.50", depositResponse.Balance)
+
+	transactionResponse, err := client.Transaction(ctx, &TransactionRequest{UserId: 2, TransactionId: 1, Type: models.TypeBet, Currency: "EUR", Amount: "5", Token: "token"})
+	assert.Nil(t, err)
+	assert.Equal(t, "7.50", transactionResponse.Balance)
+
+	userResponse, err := client.GetUser(ctx, &GetUserRequest{Id: 2, Token: "token"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), userResponse.Id)
+	assert.Equal(t, models.StatusActive, userResponse.Status)
+	assert.Len(t, userResponse.Wallets, 2)
+	for _, wallet := range userResponse.Wallets {
+		if wallet.Currency == "EUR" {
+			assert.Equal(t, "7.50", wallet.Balance)
+			assert.Equal(t, int64(1), wallet.DepositCount)
+			assert.Equal(t, "2.50", wallet.DepositSum)
+			assert.Equal(t, int64(1), wallet.BetCount)
+			assert.Equal(t, "5.00", wallet.BetSum)
+		} else {
+			assert.Equal(t, "500", wallet.Balance)
+		}
+	}
+}
+
+func TestWalletServer_Errors(t *testing.T) {
+	client, _, _ := newTestClient(t)
+
+	for _, test := range []struct {
+		name string
+		call func(ctx context.Context, opts ...grpc.CallOption) error
+		code codes.Code
+		err  *services.Error
+	}{
+		{"existing user", func(ctx context.Context, opts ...grpc.CallOption) error {
+			_, err := client.CreateUser(ctx, &CreateUserRequest{Id: 1, Wallets: map[string]string{"EUR": "0"}, Token: "other"}, opts...)
+			return err
+		}, codes.AlreadyExists, services.ErrUserAlreadyExists},
+		{"missing token", func(ctx context.Context, opts ...grpc.CallOption) error {
+			_, err := client.GetUser(ctx, &GetUserRequest{Id: 1}, opts...)
+			return err
+		}, codes.InvalidArgument, services.ErrInvalidRequest},
+		{"wrong token", func(ctx context.Context, opts ...grpc.CallOption) error {
+			_, err := client.GetUser(ctx, &GetUserRequest{Id: 1, Token: "wrong"}, opts...)
+			return err
+		}, codes.InvalidArgument, services.ErrWrongToken},
+		{"unknown user", func(ctx context.Context, opts ...grpc.CallOption) error {
+			_, err := client.GetUser(ctx, &GetUserRequest{Id: 9, Token: "sssss"}, opts...)
+			return err
+		}, codes.NotFound, services.ErrNotFound},
+		{"invalid amount", func(ctx context.Context, opts ...grpc.CallOption) error {
+			_, err := client.AddDeposit(ctx, &DepositRequest{UserId: 1, DepositId: 1, Currency: "EUR", Amount: "1.001", Token: "sssss"}, opts...)
+			return err
+		}, codes.InvalidArgument, services.ErrInvalidAmount},
+		{"not enough balance", func(ctx context.Context, opts ...grpc.CallOption) error {
+			_, err := client.Transaction(ctx, &TransactionRequest{UserId: 1, TransactionId: 1, Type: models.TypeBet, Currency: "EUR", Amount: "100", Token: "sssss"}, opts...)
+			return err
+		}, codes.InvalidArgument, services.ErrNotEnoughBalance},
+	} {
+		var header, trailer metadata.MD
+		err := test.call(context.Background(), grpc.Header(&header), grpc.Trailer(&trailer))
+
+		assert.Equal(t, test.code, status.Code(err), test.name)
+		assert.Equal(t, []string{test.err.Code}, trailer.Get(errorCodeTrailer), test.name)
+		assert.Len(t, header.Get(requestIdHeader), 1, test.name)
+	}
+}
+
+func TestWalletServer_Audit(t *testing.T) {
+	client, service, _ := newTestClient(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), requestIdHeader, "req-1")
+
+	var header metadata.MD
+	_, err := client.AddDeposit(ctx, &DepositRequest{UserId: 1, DepositId: 1, Currency: "EUR", Amount: "10", Token: "sssss"}, grpc.Header(&header))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"req-1"}, header.Get(requestIdHeader))
+	_, err = client.Transaction(ctx, &TransactionRequest{UserId: 1, TransactionId: 1, Type: models.TypeBet, Currency: "EUR", Amount: "10", Token: "wrong"})
+	assert.NotNil(t, err)
+	_, err = client.GetUser(ctx, &GetUserRequest{Id: 1, Token: "sssss"})
+	assert.Nil(t, err)
+
+	// Reads are not audited.
+	auditResponse, err := service.SearchAudit(models.SearchAuditRequestModel{UserId: 1})
+	assert.Nil(t, err)
+	if assert.Len(t, auditResponse.Entries, 2) {
+		deposit, transaction := auditResponse.Entries[0], auditResponse.Entries[1]
+		assert.Equal(t, "/guru.v1.WalletService/AddDeposit", deposit.Endpoint)
+		assert.Equal(t, models.CallerPlayer, deposit.Caller)
+		assert.Equal(t, "req-1", deposit.RequestId)
+		assert.Equal(t, "bufconn", deposit.SourceIp)
+		assert.Equal(t, int(codes.OK), deposit.Status)
+		assert.Equal(t, models.AuditSuccess, deposit.Result)
+
+		assert.Equal(t, "/guru.v1.WalletService/Transaction", transaction.Endpoint)
+		assert.Equal(t, int(codes.InvalidArgument), transaction.Status)
+		assert.Equal(t, models.AuditFailure, transaction.Result)
+		assert.Equal(t, services.ErrWrongToken.Code, transaction.ErrorCode)
+	}
+
+	verification, err := service.VerifyAudit()
+	assert.Nil(t, err)
+	assert.True(t, verification.Valid)
+}
+
+func TestWalletServer_Reflection(t *testing.T) {
+	_, _, conn := newTestClient(t)
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&rpb.ServerReflectionRequest{MessageRequest: &rpb.ServerReflectionRequest_ListServices{}}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, service := range res.GetListServicesResponse().Service {
+		names = append(names, service.Name)
+	}
+	assert.Contains(t, names, "guru.v1.WalletService")
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"guru/models"
+)
+
+// ErrorKind classifies domain errors; each transport maps a kind to its own status.
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindInvalid
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+)
+
+// Error is a domain error with a stable machine Code for clients and a Message for people.
+// Errors match by Code, so an error built with the Code of a sentinel is that sentinel for errors.Is.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	ErrInternal       = &Error{KindInternal, "INTERNAL_ERROR", "internal error"}
+	ErrInvalidRequest = &Error{KindInvalid, "INVALID_REQUEST", "invalid request"}
+	ErrUnauthorized   = &Error{KindUnauthorized, "UNAUTHORIZED", "unauthorized"}
+	ErrForbidden      = &Error{KindForbidden, "FORBIDDEN", "forbidden"}
+
+	ErrNotFound          = &Error{KindNotFound, "NOT_FOUND", "not found"}
+	ErrUserAlreadyExists = &Error{KindConflict, "USER_ALREADY_EXISTS", "user already exists"}
+	ErrWrongToken        = &Error{KindInvalid, "WRONG_TOKEN", "wrong token"}
+	ErrConflict          = &Error{KindConflict, "CONFLICT", "conflict"}
+	ErrWalletNotFound    = &Error{KindNotFound, "WALLET_NOT_FOUND", "wallet not found"}
+	ErrInvalidAmount     = &Error{KindInvalid, "INVALID_AMOUNT", "invalid money amount"}
+	ErrUnknownCurrency   = &Error{KindInvalid, "UNKNOWN_CURRENCY", "unknown currency"}
+	ErrInvalidCursor     = &Error{KindInvalid, "INVALID_CURSOR", "invalid cursor"}
+	ErrInvalidTimeWindow = &Error{KindInvalid, "INVALID_TIME_WINDOW", "invalid time window"}
+	ErrCurrencyRequired  = &Error{KindInvalid, "CURRENCY_REQUIRED", "currency required"}
+	ErrNotEnoughBalance  = &Error{KindInvalid, "NOT_ENOUGH_BALANCE", "not enough balance"}
+
+	ErrUnknownTransactionType        = &Error{KindInvalid, "UNKNOWN_TRANSACTION_TYPE", "unknown transaction type"}
+	ErrTransactionIdReserved         = &Error{KindInvalid, "TRANSACTION_ID_RESERVED", "transaction id reserved for provider transactions"}
+	ErrOriginalTransactionIdRequired = &Error{KindInvalid, "ORIGINAL_TRANSACTION_ID_REQUIRED", "original transaction id required"}
+	ErrOriginalTransactionNotFound   = &Error{KindNotFound, "ORIGINAL_TRANSACTION_NOT_FOUND", "original transaction not found"}
+	ErrTransactionNotRollbackable    = &Error{KindInvalid, "TRANSACTION_NOT_ROLLBACKABLE", "transaction cannot be rolled back"}
+	ErrRollbackCurrencyMismatch      = &Error{KindInvalid, "ROLLBACK_CURRENCY_MISMATCH", "rollback currency mismatch"}
+	ErrRollbackAmountMismatch        = &Error{KindInvalid, "ROLLBACK_AMOUNT_MISMATCH", "rollback amount mismatch"}
+	ErrAlreadyRolledBack             = &Error{KindConflict, "ALREADY_ROLLED_BACK", "already rolled back"}
+	ErrUnknownProviderAction         = &Error{KindInvalid, "UNKNOWN_PROVIDER_ACTION", "unknown provider action"}
+	ErrBatchAborted                  = &Error{KindConflict, "BATCH_ABORTED", "not applied, another transaction of the batch failed"}
+
+	ErrRoundNotFound = &Error{KindNotFound, "ROUND_NOT_FOUND", "round not found"}
+	ErrRoundMismatch = &Error{KindConflict, "ROUND_MISMATCH", "round mismatch"}
+	ErrRoundClosed   = &Error{KindConflict, "ROUND_CLOSED", "round closed"}
+
+	ErrWithdrawalAlreadySettled = &Error{KindConflict, "WITHDRAWAL_ALREADY_SETTLED", "withdrawal already settled"}
+
+	ErrInvalidWageringTarget = &Error{KindInvalid, "INVALID_WAGERING_TARGET", "invalid wagering target"}
+	ErrBonusAlreadyExpired   = &Error{KindInvalid, "BONUS_ALREADY_EXPIRED", "bonus already expired"}
+	ErrBonusAlreadyActive    = &Error{KindConflict, "BONUS_ALREADY_ACTIVE", "bonus already active"}
+
+	ErrLimitNotFound        = &Error{KindNotFound, "LIMIT_NOT_FOUND", "limit not found"}
+	ErrDepositLimitExceeded = &Error{KindForbidden, "DEPOSIT_LIMIT_EXCEEDED", "deposit limit exceeded"}
+	ErrLossLimitExceeded    = &Error{KindForbidden, "LOSS_LIMIT_EXCEEDED", "loss limit exceeded"}
+
+	ErrAccountSuspended        = &Error{KindForbidden, "ACCOUNT_SUSPENDED", "account suspended"}
+	ErrAccountSelfExcluded     = &Error{KindForbidden, "ACCOUNT_SELF_EXCLUDED", "account self-excluded"}
+	ErrAccountClosed           = &Error{KindForbidden, "ACCOUNT_CLOSED", "account closed"}
+	ErrInvalidStatusTransition = &Error{KindConflict, "INVALID_STATUS_TRANSITION", "invalid status transition"}
+	ErrInvalidExclusionEnd     = &Error{KindInvalid, "INVALID_EXCLUSION_END", "invalid exclusion end"}
+)
+
+// modelErrors are the errors of models that reach the transports, by their domain error.
+var modelErrors = map[error]*Error{
+	models.ErrInvalidMoney:     ErrInvalidAmount,
+	models.ErrUnknownCurrency:  ErrUnknownCurrency,
+	models.ErrInvalidCursor:    ErrInvalidCursor,
+	models.ErrCurrencyRequired: ErrCurrencyRequired,
+}
+
+// AsError returns the domain error behind err, or ErrInternal when there is none.
+func AsError(err error) *Error {
+	var domainError *Error
+	if errors.As(err, &domainError) {
+		return domainError
+	}
+	for modelError, domainError := range modelErrors {
+		if errors.Is(err, modelError) {
+			return domainError
+		}
+	}
+
+	return ErrInternal
+}
+
+// InvalidRequest returns the domain error of a request that failed to decode or validate: the domain error
+// behind err, like an invalid amount, or else ErrInvalidRequest with the message of err.
+func InvalidRequest(err error) *Error {
+	if domainError := AsError(err); domainError != ErrInternal {
+		return domainError
+	}
+
+	return &Error{ErrInvalidRequest.Kind, ErrInvalidRequest.Code, err.Error()}
+}
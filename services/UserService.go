@@ -2,60 +2,232 @@ package services
 
 import (
 	"context"
-	"errors"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 	"guru/models"
 	"guru/repositories"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// account is the in-memory state of one user. Its mutex serializes the operations on that user,
+// so operations on different users, including their storage writes, run in parallel.
+type account struct {
+	user       *models.UserModel
+	statistics map[string]*models.StatisticModel
+	// bonuses holds the active bonus of each wallet.
+	bonuses map[string]*models.BonusModel
+	limits  map[models.LimitKeyModel]*models.LimitModel
+	// sequence is the ledger sequence of the last ledger row of the user.
+	sequence uint64
+	sync.Mutex
+}
+
+// statistic returns the statistic of one currency wallet of the user.
+func (a *account) statistic(currency string) *models.StatisticModel {
+	if _, ok := a.statistics[currency]; !ok {
+		a.statistics[currency] = &models.StatisticModel{Id: models.StatisticKeyModel{UserId: a.user.Id, Currency: currency}}
+	}
+
+	return a.statistics[currency]
+}
+
+// wallet returns the balance of the user in the currency, failing when the user has no such wallet.
+func (a *account) wallet(currency string) (models.Money, error) {
+	balance, ok := a.user.Wallets[currency]
+	if !ok {
+		return 0, ErrWalletNotFound
+	}
+
+	return balance, nil
+}
+
+// touch marks the user for the next flush; users that were never stored stay new.
+func (a *account) touch() {
+	if a.user.Sync != models.SyncNew {
+		a.user.Sync = models.SyncModified
+	}
+}
+
 type UserService struct {
-	Users                 map[uint64]*models.UserModel
-	Statistic             map[uint64]*models.StatisticModel
 	UserRepository        repositories.UserRepository
 	DepositRepository     repositories.DepositRepository
 	TransactionRepository repositories.TransactionRepository
-	Ticker                *time.Ticker
-	sync.Mutex
+	WithdrawalRepository  repositories.WithdrawalRepository
+	BonusRepository       repositories.BonusRepository
+	LimitRepository       repositories.LimitRepository
+	RoundRepository       repositories.RoundRepository
+	AdjustmentRepository  repositories.AdjustmentRepository
+	AuditRepository       repositories.AuditRepository
+	// Ticker drives the write-behind of users; it is not used when Ledger is set.
+	Ticker *time.Ticker
+	// Ledger, when set, commits every ledger entry together with its balance change in one transaction.
+	Ledger repositories.LedgerRepository
+	// Journal durably records balance mutations between flushes; it is disabled when nil.
+	Journal repositories.JournalRepository
+	// Clock stamps ledger records; time.Now is used when it is nil.
+	Clock func() time.Time
+	// StrictRounds rejects wins on unknown rounds and bets and wins on closed rounds.
+	StrictRounds bool
+	// LimitCoolingOff delays raising or removing a deposit or loss limit; 24 hours are used when it is zero.
+	LimitCoolingOff time.Duration
+	sequence        uint64
+	lastRepairId    uint64
+	lastProviderId  uint64
+	journalLock     sync.Mutex
+	accounts        map[uint64]*account
+	// auditLast is the tail of the audit hash chain, guarded by auditLock; auditDropped counts the entries
+	// that failed to append.
+	auditLast    models.AuditModel
+	auditLock    sync.Mutex
+	auditDropped uint64
+	// RWMutex guards the accounts map only, the state of each user is guarded by its account.
+	sync.RWMutex
 }
 
-func (s *UserService) Run(ctx context.Context, r *mux.Router) {
+func (s *UserService) Load() error {
 	users := make(map[uint64]*models.UserModel)
 	if err := s.UserRepository.FindAll(users); err != nil {
-		zap.L().Fatal(err.Error())
+		return err
+	}
+	for _, user := range users {
+		user.Sync = models.SyncSaved
 	}
-	s.Users = users
 
-	statistic := make(map[uint64]*models.StatisticModel)
+	statistic := make(map[models.StatisticKeyModel]*models.StatisticModel)
 	if err := s.DepositRepository.FindAllDeposit(statistic); err != nil {
-		zap.L().Fatal(err.Error())
+		return err
 	}
 	if err := s.TransactionRepository.FindAllBet(statistic); err != nil {
-		zap.L().Fatal(err.Error())
+		return err
 	}
 	if err := s.TransactionRepository.FindAllWin(statistic); err != nil {
+		return err
+	}
+	if err := s.WithdrawalRepository.FindAllWithdrawal(statistic); err != nil {
+		return err
+	}
+	bonuses, err := s.BonusRepository.FindActive()
+	if err != nil {
+		return err
+	}
+	sequences := make(map[uint64]uint64)
+	for _, find := range []func(map[uint64]uint64) error{
+		s.DepositRepository.FindLastSequences,
+		s.TransactionRepository.FindLastSequences,
+		s.WithdrawalRepository.FindLastSequences,
+		s.BonusRepository.FindLastSequences,
+		s.AdjustmentRepository.FindLastSequences,
+	} {
+		if err := find(sequences); err != nil {
+			return err
+		}
+	}
+	limits, err := s.LimitRepository.FindAll()
+	if err != nil {
+		return err
+	}
+	lastRepairId, err := s.AdjustmentRepository.FindLastRepairId()
+	if err != nil {
+		return err
+	}
+	lastProviderId, err := s.TransactionRepository.FindLastProviderId()
+	if err != nil {
+		return err
+	}
+	auditLast, err := s.AuditRepository.FindLast()
+	if err == repositories.ErrNotFound {
+		auditLast = &models.AuditModel{}
+	} else if err != nil {
+		return err
+	}
+	if err := s.replayJournal(users); err != nil {
+		return err
+	}
+
+	accounts := make(map[uint64]*account, len(users))
+	for id, user := range users {
+		accounts[id] = &account{
+			user:       user,
+			statistics: make(map[string]*models.StatisticModel),
+			bonuses:    make(map[string]*models.BonusModel),
+			limits:     make(map[models.LimitKeyModel]*models.LimitModel),
+			sequence:   sequences[id],
+		}
+	}
+	for key, result := range statistic {
+		if a, ok := accounts[key.UserId]; ok {
+			a.statistics[key.Currency] = result
+		}
+	}
+	for i := range bonuses {
+		if a, ok := accounts[bonuses[i].UserId]; ok {
+			a.bonuses[bonuses[i].Currency] = &bonuses[i]
+		}
+	}
+	for i := range limits {
+		if a, ok := accounts[limits[i].UserId]; ok {
+			a.limits[limits[i].Key()] = &limits[i]
+		}
+	}
+
+	s.auditLock.Lock()
+	s.auditLast = *auditLast
+	s.auditLock.Unlock()
+
+	atomic.StoreUint64(&s.lastRepairId, lastRepairId)
+	s.raiseProviderId(lastProviderId)
+
+	s.Lock()
+	defer s.Unlock()
+	s.accounts = accounts
+
+	return nil
+}
+
+// Server is an API served next to the HTTP API while the service runs, such as the gRPC API.
+type Server interface {
+	// Serve blocks until the server is stopped.
+	Serve() error
+	// GracefulStop stops the server once its pending calls have finished.
+	GracefulStop()
+}
+
+// Run loads the service and serves the HTTP API on :8080 and the servers until ctx is done. The servers are
+// stopped before the remaining audit entries are written.
+func (s *UserService) Run(ctx context.Context, r *mux.Router, servers ...Server) {
+	if err := s.Load(); err != nil {
 		zap.L().Fatal(err.Error())
 	}
-	s.Statistic = statistic
 
 	server := &http.Server{
 		Addr:    ":8080",
 		Handler: r,
 	}
-	s.startTicker()
+	if s.Ticker != nil {
+		s.startTicker()
+	}
 
 	go func() {
 		err := server.ListenAndServe()
 		zap.L().Warn("http server terminated", zap.String("error", err.Error()))
 	}()
+	for _, extra := range servers {
+		go func(extra Server) {
+			if err := extra.Serve(); err != nil {
+				zap.L().Warn("server terminated", zap.String("error", err.Error()))
+			}
+		}(extra)
+	}
 	zap.L().Info("server started")
 
-
 	<-ctx.Done()
-	s.stopTicker()
+	if s.Ticker != nil {
+		s.stopTicker()
+	}
 
 	ctxShutDown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer func() {
@@ -65,151 +237,690 @@ func (s *UserService) Run(ctx context.Context, r *mux.Router) {
 	if err := server.Shutdown(ctxShutDown); err != nil {
 		zap.L().Error("http server shutdown: %v", zap.String("error", err.Error()))
 	}
+	for _, extra := range servers {
+		extra.GracefulStop()
+	}
 	zap.L().Info("shutdown completed")
 }
 
-func (s *UserService) GetUser(id uint64, token string) (*models.GetUserResponseModel, error) {
-	s.Lock()
-	defer s.Unlock()
-	if _, ok := s.Users[id]; !ok {
-		return nil, errors.New("not found")
+func (s *UserService) GetUser(userRequest models.GetUserRequestModel) (*models.GetUserResponseModel, error) {
+	userResponse, err := s.getUser(userRequest.Id, userRequest.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if userRequest.Granularity != "" {
+		userResponse.Buckets, err = s.statisticBuckets(models.StatisticFilterModel{
+			UserId:      userRequest.Id,
+			From:        userRequest.From,
+			To:          userRequest.To,
+			Granularity: userRequest.Granularity,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return userResponse, nil
+}
+
+func (s *UserService) getUser(id uint64, token string) (*models.GetUserResponseModel, error) {
+	a, err := s.lockAuthorized(id, token)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	if err := s.checkStatus(a, models.StatusActive, models.StatusSuspended, models.StatusSelfExcluded); err != nil {
+		return nil, err
 	}
 
-	if s.Users[id].Token != token {
-		return nil, errors.New("wrong token")
+	return s.userResponse(a), nil
+}
+
+// userResponse reports the wallets, status and limits of the user. The caller holds the account.
+func (s *UserService) userResponse(a *account) *models.GetUserResponseModel {
+	wallets := make([]models.WalletResponseModel, 0, len(a.user.Wallets))
+	for currency, balance := range a.user.Wallets {
+		statistic := a.statistic(currency)
+		wallets = append(wallets, models.WalletResponseModel{
+			Currency:             currency,
+			Balance:              balance,
+			DepositCount:         statistic.DepositCount,
+			DepositSum:           statistic.DepositSum,
+			BetCount:             statistic.BetCount,
+			BetSum:               statistic.BetSum,
+			WinCount:             statistic.WinCount,
+			WinSum:               statistic.WinSum,
+			WithdrawalCount:      statistic.WithdrawalCount,
+			WithdrawalSum:        statistic.WithdrawalSum,
+			PendingWithdrawalSum: statistic.PendingWithdrawalSum,
+			Bonus:                s.bonusResponse(a, currency),
+		})
 	}
+	sort.Slice(wallets, func(i, j int) bool {
+		return wallets[i].Currency < wallets[j].Currency
+	})
+
+	status := statusResponse(a.user, s.now())
 
 	return &models.GetUserResponseModel{
-		Id:           s.Users[id].Id,
-		Balance:      s.Users[id].Balance,
-		DepositCount: s.Statistic[id].DepositCount,
-		DepositSum:   s.Statistic[id].DepositSum,
-		BetCount:     s.Statistic[id].BetCount,
-		BetSum:       s.Statistic[id].BetSum,
-		WinCount:     s.Statistic[id].WinCount,
-		WinSum:       s.Statistic[id].WinSum,
-	}, nil
+		Id:            a.user.Id,
+		Status:        status.Status,
+		ExcludedUntil: status.ExcludedUntil,
+		Wallets:       wallets,
+		Limits:        s.limitResponses(a),
+	}
 }
 
-func (s *UserService) CreateUser(id uint64, user models.UserModel) {
-	s.Lock()
-	defer s.Unlock()
-	s.Users[id] = &user
-	s.Users[id].Status = models.StatusNew
-	s.Statistic[id] = &models.StatisticModel{
-		Id:           id,
-		DepositCount: 0,
-		DepositSum:   0,
-		BetCount:     0,
-		BetSum:       0,
-		WinCount:     0,
-		WinSum:       0,
+// lockAccount finds the user and locks its account; the caller must unlock it.
+func (s *UserService) lockAccount(id uint64) (*account, error) {
+	s.RLock()
+	a, ok := s.accounts[id]
+	s.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	a.Lock()
+	if a.user == nil {
+		a.Unlock()
+		return nil, ErrNotFound
+	}
+
+	return a, nil
+}
+
+// lockAuthorized locks the account of the user after checking its token.
+func (s *UserService) lockAuthorized(id uint64, token string) (*account, error) {
+	a, err := s.lockAccount(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !a.user.Authenticate(token, s.now()) {
+		a.Unlock()
+		return nil, ErrWrongToken
+	}
+
+	return a, nil
+}
+
+// lockPlaceholder locks the account of id, adding an empty one when there is none. It retries when the account
+// it locked was dropped meanwhile by a failed create.
+func (s *UserService) lockPlaceholder(id uint64) *account {
+	for {
+		s.Lock()
+		a, ok := s.accounts[id]
+		if !ok {
+			a = &account{}
+			s.accounts[id] = a
+		}
+		s.Unlock()
+
+		a.Lock()
+		s.RLock()
+		current := s.accounts[id] == a
+		s.RUnlock()
+		if current {
+			return a
+		}
+		a.Unlock()
 	}
 }
 
+func (s *UserService) CreateUser(id uint64, user models.UserModel) error {
+	a := s.lockPlaceholder(id)
+	defer a.Unlock()
+
+	if a.user != nil {
+		return ErrUserAlreadyExists
+	}
+	// A failed create drops its placeholder, so a create waiting on it starts over on a new one.
+	dropPlaceholder := func() {
+		s.Lock()
+		delete(s.accounts, id)
+		s.Unlock()
+	}
+	if err := user.HashToken(); err != nil {
+		dropPlaceholder()
+		return err
+	}
+	user.Status = models.StatusActive
+	user.StatusChangedAt = s.now()
+	user.OpeningWallets = make(models.Wallets, len(user.Wallets))
+	for currency, balance := range user.Wallets {
+		user.OpeningWallets[currency] = balance
+	}
+	user.Sync = models.SyncNew
+	if s.Ledger != nil {
+		user.Sync = models.SyncSaved
+		if err := s.UserRepository.Insert([]models.UserModel{user}); err != nil {
+			dropPlaceholder()
+			return err
+		}
+	}
+	a.user = &user
+	a.statistics = make(map[string]*models.StatisticModel)
+	a.bonuses = make(map[string]*models.BonusModel)
+	a.limits = make(map[models.LimitKeyModel]*models.LimitModel)
+
+	return s.journal(models.JournalCreate, a.user, "", 0)
+}
+
 func (s *UserService) AddDeposit(depositRequest models.DepositRequestModel) (*models.TransactionResponseModel, error) {
-	s.Lock()
-	defer s.Unlock()
-	if _, ok := s.Users[depositRequest.UserId]; !ok {
-		return nil, errors.New("not found")
+	a, err := s.lockAuthorized(depositRequest.UserId, depositRequest.Token)
+	if err != nil {
+		return nil, err
 	}
+	defer a.Unlock()
 
-	if s.Users[depositRequest.UserId].Token != depositRequest.Token {
-		return nil, errors.New("wrong token")
+	balance, err := a.wallet(depositRequest.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if response, err := s.replayDeposit(depositRequest); response != nil || err != nil {
+		return response, err
 	}
 
-	if err := s.saveDeposit(depositRequest); err != nil {
+	if err := s.checkStatus(a, models.StatusActive); err != nil {
 		return nil, err
 	}
 
-	s.Users[depositRequest.UserId].Balance += depositRequest.Amount
-	s.Statistic[depositRequest.UserId].DepositCount += 1
-	s.Statistic[depositRequest.UserId].DepositSum += depositRequest.Amount
-	s.Users[depositRequest.UserId].Status = models.StatusModified
+	if err := s.checkLimits(nil, a, models.LimitDeposit, depositRequest.Currency, depositRequest.Amount); err != nil {
+		return nil, err
+	}
+
+	balanceAfter, err := balance.Add(depositRequest.Amount)
+	if err != nil {
+		return nil, ErrInvalidAmount
+	}
+	undo, err := s.journalAhead(models.JournalDeposit, a.user, depositRequest.Currency, depositRequest.DepositId, balanceAfter)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveDeposit(depositRequest, balance, a.sequence+1); err != nil {
+		undo()
+		return nil, err
+	}
+
+	a.user.Wallets[depositRequest.Currency] = balanceAfter
+	a.sequence++
+	statistic := a.statistic(depositRequest.Currency)
+	statistic.DepositCount += 1
+	statistic.DepositSum += depositRequest.Amount
+	a.touch()
 
 	return &models.TransactionResponseModel{
-		Error:   "",
-		Balance: s.Users[depositRequest.UserId].Balance,
+		Currency: depositRequest.Currency,
+		Balance:  a.user.Wallets[depositRequest.Currency],
 	}, nil
 }
 
 func (s *UserService) Transaction(transactionRequest models.TransactionRequestModel) (*models.TransactionResponseModel, error) {
-	s.Lock()
-	defer s.Unlock()
-	if _, ok := s.Users[transactionRequest.UserId]; !ok {
-		return nil, errors.New("not found")
+	a, err := s.lockAuthorized(transactionRequest.UserId, transactionRequest.Token)
+	if err != nil {
+		return nil, err
 	}
+	defer a.Unlock()
+
+	return s.transaction(nil, a, transactionRequest)
+}
 
-	if s.Users[transactionRequest.UserId].Token != transactionRequest.Token {
-		return nil, errors.New("wrong token")
+// transaction applies a transaction to the locked account of its user. Within a batch the storage writes
+// are left to the batch, which stores them or undoes the transaction.
+func (s *UserService) transaction(b *ledgerBatch, a *account, transactionRequest models.TransactionRequestModel) (*models.TransactionResponseModel, error) {
+	if transactionRequest.ExternalId == "" && transactionRequest.TransactionId >= models.ProviderTransactionIdBase {
+		return nil, ErrTransactionIdReserved
+	}
+	balance, err := a.wallet(transactionRequest.Currency)
+	if err != nil {
+		return nil, err
 	}
 
-	if transactionRequest.Type == models.TypeBet && s.Users[transactionRequest.UserId].Balance < transactionRequest.Amount {
-		return nil, errors.New("not enough balance")
+	if response, err := s.replayTransaction(b, transactionRequest); response != nil || err != nil {
+		return response, err
 	}
 
-	if err := s.saveTransaction(transactionRequest); err != nil {
+	// Wins and rollbacks still settle rounds on accounts that can no longer bet, unless they are closed.
+	allowed := []string{models.StatusActive}
+	if transactionRequest.Type != models.TypeBet {
+		allowed = append(allowed, models.StatusSuspended, models.StatusSelfExcluded)
+	}
+	if err := s.checkStatus(a, allowed...); err != nil {
 		return nil, err
 	}
 
-	if transactionRequest.Type == models.TypeWin {
-		s.Users[transactionRequest.UserId].Balance += transactionRequest.Amount
-		s.Statistic[transactionRequest.UserId].WinCount += 1
-		s.Statistic[transactionRequest.UserId].WinSum += transactionRequest.Amount
+	var original *models.TransactionModel
+	switch transactionRequest.Type {
+	case models.TypeBet, models.TypeWin:
+	case models.TypeRollback:
+		if original, err = s.findRollbackOriginal(b, transactionRequest); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnknownTransactionType
+	}
+
+	if original != nil && transactionRequest.RoundId == "" {
+		// A rollback belongs to the round of the transaction it reverses.
+		transactionRequest.RoundId = original.RoundId
+		transactionRequest.GameId = original.GameId
+		transactionRequest.Provider = original.Provider
+	}
+	round, err := s.findRound(b, transactionRequest)
+	if err != nil {
+		return nil, err
+	}
+	if round != nil && transactionRequest.GameId == "" {
+		transactionRequest.GameId = round.GameId
 	}
+
+	bonus, err := s.activeBonus(b, a, transactionRequest.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if original != nil && bonus != nil && original.BonusId != bonus.Id {
+		// The bonus of the original transaction is gone, the rollback settles against the real balance only.
+		bonus = nil
+	}
+
+	var bonusAmount models.Money
+	var updatedBonus models.BonusModel
+	if bonus != nil {
+		bonusAmount = bonusShare(bonus, transactionRequest.Type, transactionRequest.Amount, balance, original)
+		updatedBonus = *bonus
+		if original != nil {
+			err = applyBonus(&updatedBonus, original.Type, -original.Amount, -bonusAmount)
+		} else {
+			err = applyBonus(&updatedBonus, transactionRequest.Type, transactionRequest.Amount, bonusAmount)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	delta := transactionDelta(transactionRequest.Type, transactionRequest.Amount-bonusAmount)
+	if original != nil {
+		// The bonus part of the original never moved real money; when its bonus is gone that part is forfeited.
+		delta = -transactionDelta(original.Type, original.Amount-original.BonusAmount)
+	}
+
+	balanceAfter, err := balance.Add(delta)
+	if err != nil {
+		return nil, ErrInvalidAmount
+	}
+	if balanceAfter < 0 {
+		return nil, ErrNotEnoughBalance
+	}
+
 	if transactionRequest.Type == models.TypeBet {
-		s.Users[transactionRequest.UserId].Balance -= transactionRequest.Amount
-		s.Statistic[transactionRequest.UserId].BetCount += 1
-		s.Statistic[transactionRequest.UserId].BetSum -= transactionRequest.Amount
+		if err := s.checkLimits(b, a, models.LimitLoss, transactionRequest.Currency, -delta); err != nil {
+			return nil, err
+		}
+	}
+
+	undo, err := s.journalAhead(models.JournalTransaction, a.user, transactionRequest.Currency, transactionRequest.TransactionId, balanceAfter)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveTransaction(b, transactionRequest, bonus, bonusAmount, balance, balanceAfter, a.sequence+1); err != nil {
+		undo()
+		return nil, err
+	}
+	b.compensate(undo)
+
+	a.user.Wallets[transactionRequest.Currency] = balanceAfter
+	a.sequence++
+	statistic := a.statistic(transactionRequest.Currency)
+	if original != nil {
+		addTransactionStatistic(statistic, original.Type, -1, -original.Amount)
+	} else {
+		addTransactionStatistic(statistic, transactionRequest.Type, 1, transactionRequest.Amount)
+	}
+	a.touch()
+	if bonus != nil {
+		if err := s.settleBonus(b, a, updatedBonus); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.saveRound(b, transactionRequest, round); err != nil {
+		return nil, err
+	}
+
+	return &models.TransactionResponseModel{
+		Currency: transactionRequest.Currency,
+		Balance:  a.user.Wallets[transactionRequest.Currency],
+	}, nil
+}
+
+func (s *UserService) replayDeposit(depositRequest models.DepositRequestModel) (*models.TransactionResponseModel, error) {
+	deposit, err := s.DepositRepository.FindById(depositRequest.DepositId)
+	if err == repositories.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deposit.UserId != depositRequest.UserId ||
+		deposit.Currency != depositRequest.Currency ||
+		deposit.Amount != depositRequest.Amount {
+		return nil, ErrConflict
 	}
-	s.Users[transactionRequest.UserId].Status = models.StatusModified
 
 	return &models.TransactionResponseModel{
-		Error:   "",
-		Balance: s.Users[transactionRequest.UserId].Balance,
+		Currency: deposit.Currency,
+		Balance:  deposit.BalanceAfter,
 	}, nil
 }
 
-func (s *UserService) saveDeposit(depositRequest models.DepositRequestModel) error {
+func (s *UserService) replayTransaction(b *ledgerBatch, transactionRequest models.TransactionRequestModel) (*models.TransactionResponseModel, error) {
+	transaction, err := s.findTransaction(b, transactionRequest.TransactionId)
+	if err == repositories.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return replayedTransaction(transaction, transactionRequest)
+}
+
+// replayedTransaction answers a retry of a stored transaction, or ErrConflict when the retry differs from it.
+func replayedTransaction(transaction *models.TransactionModel, transactionRequest models.TransactionRequestModel) (*models.TransactionResponseModel, error) {
+	if transaction.UserId != transactionRequest.UserId ||
+		transaction.Currency != transactionRequest.Currency ||
+		transaction.Type != transactionRequest.Type ||
+		transaction.Amount != transactionRequest.Amount ||
+		transaction.OriginalTransactionId != transactionRequest.OriginalTransactionId ||
+		transaction.ExternalId != transactionRequest.ExternalId ||
+		(transaction.ExternalId != "" && transaction.Provider != transactionRequest.Provider) {
+		return nil, ErrConflict
+	}
+
+	return &models.TransactionResponseModel{
+		Currency: transaction.Currency,
+		Balance:  transaction.BalanceAfter,
+	}, nil
+}
+
+func (s *UserService) findRollbackOriginal(b *ledgerBatch, transactionRequest models.TransactionRequestModel) (*models.TransactionModel, error) {
+	if transactionRequest.OriginalTransactionId == 0 {
+		return nil, ErrOriginalTransactionIdRequired
+	}
+
+	original, err := s.findTransaction(b, transactionRequest.OriginalTransactionId)
+	if err == repositories.ErrNotFound || (err == nil && original.UserId != transactionRequest.UserId) {
+		return nil, ErrOriginalTransactionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if original.Type != models.TypeBet && original.Type != models.TypeWin {
+		return nil, ErrTransactionNotRollbackable
+	}
+
+	if original.Currency != transactionRequest.Currency {
+		return nil, ErrRollbackCurrencyMismatch
+	}
+
+	if original.Amount != transactionRequest.Amount {
+		return nil, ErrRollbackAmountMismatch
+	}
+
+	_, err = s.findRollback(b, original.Id)
+	if err == nil {
+		return nil, ErrAlreadyRolledBack
+	}
+	if err != repositories.ErrNotFound {
+		return nil, err
+	}
+
+	return original, nil
+}
+
+func (s *UserService) saveDeposit(depositRequest models.DepositRequestModel, balanceBefore models.Money, sequence uint64) error {
 	deposit := models.DepositModel{
 		Id:            depositRequest.DepositId,
 		UserId:        depositRequest.UserId,
+		Currency:      depositRequest.Currency,
 		Amount:        depositRequest.Amount,
-		BalanceBefore: s.Users[depositRequest.UserId].Balance,
-		BalanceAfter:  s.Users[depositRequest.UserId].Balance + depositRequest.Amount,
-		CreatedAt:     time.Time{},
+		BalanceBefore: balanceBefore,
+		BalanceAfter:  balanceBefore + depositRequest.Amount,
+		CreatedAt:     s.now(),
+		Sequence:      sequence,
+	}
+
+	insert := s.DepositRepository.Insert
+	if s.Ledger != nil {
+		insert = s.Ledger.InsertDeposit
 	}
 
-	if err := s.DepositRepository.Insert(deposit); err != nil {
+	return ledgerError(insert(deposit))
+}
+
+func (s *UserService) saveTransaction(
+	b *ledgerBatch,
+	transactionRequest models.TransactionRequestModel,
+	bonus *models.BonusModel,
+	bonusAmount models.Money,
+	balanceBefore models.Money,
+	balanceAfter models.Money,
+	sequence uint64,
+) error {
+	transaction := models.TransactionModel{
+		Id:                    transactionRequest.TransactionId,
+		UserId:                transactionRequest.UserId,
+		Currency:              transactionRequest.Currency,
+		Amount:                transactionRequest.Amount,
+		Type:                  transactionRequest.Type,
+		OriginalTransactionId: transactionRequest.OriginalTransactionId,
+		BonusAmount:           bonusAmount,
+		RoundId:               transactionRequest.RoundId,
+		GameId:                transactionRequest.GameId,
+		Provider:              transactionRequest.Provider,
+		ExternalId:            transactionRequest.ExternalId,
+		BalanceBefore:         balanceBefore,
+		BalanceAfter:          balanceAfter,
+		CreatedAt:             s.now(),
+		Sequence:              sequence,
+	}
+
+	if bonus != nil {
+		transaction.BonusId = bonus.Id
+	}
+	if b != nil {
+		b.current().transaction = &transaction
+		return nil
+	}
+
+	insert := s.TransactionRepository.Insert
+	if s.Ledger != nil {
+		insert = s.Ledger.InsertTransaction
+	}
+
+	return ledgerError(insert(transaction))
+}
+
+// ledgerError translates repository errors of a ledger write into service errors.
+func ledgerError(err error) error {
+	switch err {
+	case repositories.ErrDuplicate:
+		return ErrConflict
+	case repositories.ErrBalanceGuard:
+		return ErrNotEnoughBalance
+	}
+
+	return err
+}
+
+// journal durably records the balance of the mutated wallet after a mutation, before the mutation is acknowledged.
+// The caller holds the account of the user, so entries of one user are journaled in mutation order.
+func (s *UserService) journal(operation string, user *models.UserModel, currency string, referenceId uint64) error {
+	if s.Journal == nil {
+		return nil
+	}
+
+	return s.appendJournal(s.journalEntry(operation, user, currency, referenceId))
+}
+
+// journalAhead journals the balance a mutation leaves before the mutation is stored, so a stored mutation is
+// never missing from the journal. The returned undo journals the balance back when the store then fails.
+func (s *UserService) journalAhead(operation string, user *models.UserModel, currency string, referenceId uint64, balance models.Money) (func(), error) {
+	if s.Journal == nil {
+		return func() {}, nil
+	}
+
+	entry := s.journalEntry(operation, user, currency, referenceId)
+	previous := entry.Balance
+	entry.Balance = balance
+	if err := s.appendJournal(entry); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		entry.Balance = previous
+		entry.CreatedAt = s.now()
+		if err := s.appendJournal(entry); err != nil {
+			zap.L().Error("journal entry not undone, wallet needs reconciliation", zap.Uint64("user_id", user.Id),
+				zap.String("currency", currency), zap.Error(err))
+		}
+	}, nil
+}
+
+func (s *UserService) journalEntry(operation string, user *models.UserModel, currency string, referenceId uint64) models.JournalEntryModel {
+	entry := models.JournalEntryModel{
+		Operation:   operation,
+		UserId:      user.Id,
+		ReferenceId: referenceId,
+		Currency:    currency,
+		Balance:     user.Wallets[currency],
+		CreatedAt:   s.now(),
+	}
+	switch operation {
+	case models.JournalCreate:
+		entry.TokenHash = user.TokenHash
+		entry.Wallets = user.Wallets.Copy()
+	case models.JournalToken:
+		entry.TokenHash = user.TokenHash
+		entry.PreviousTokenHash = user.PreviousTokenHash
+		if !user.PreviousTokenExpiresAt.IsZero() {
+			previousTokenExpiresAt := user.PreviousTokenExpiresAt
+			entry.PreviousTokenExpiresAt = &previousTokenExpiresAt
+		}
+	case models.JournalStatus:
+		entry.Status = user.Status
+		entry.Reason = user.StatusReason
+		if !user.ExcludedUntil.IsZero() {
+			excludedUntil := user.ExcludedUntil
+			entry.ExcludedUntil = &excludedUntil
+		}
+	}
+
+	return entry
+}
+
+// appendJournal numbers the entry and appends it to the journal.
+func (s *UserService) appendJournal(entry models.JournalEntryModel) error {
+	s.journalLock.Lock()
+	defer s.journalLock.Unlock()
+
+	entry.Sequence = s.sequence + 1
+	if err := s.Journal.Append(entry); err != nil {
 		return err
 	}
+	s.sequence = entry.Sequence
 
 	return nil
 }
 
-func (s *UserService) saveTransaction(transactionRequest models.TransactionRequestModel) error {
-	balanceAfter := s.Users[transactionRequest.UserId].Balance - transactionRequest.Amount
-	if transactionRequest.Type == models.TypeWin {
-		balanceAfter = s.Users[transactionRequest.UserId].Balance + transactionRequest.Amount
+// replayJournal applies the balances journaled since the last checkpoint over the stored snapshot.
+func (s *UserService) replayJournal(users map[uint64]*models.UserModel) error {
+	if s.Journal == nil {
+		return nil
 	}
 
-	transaction := models.TransactionModel{
-		Id:            transactionRequest.TransactionId,
-		UserId:        transactionRequest.UserId,
-		Amount:        transactionRequest.Amount,
-		Type:          transactionRequest.Type,
-		BalanceBefore: s.Users[transactionRequest.UserId].Balance,
-		BalanceAfter:  balanceAfter,
-		CreatedAt:     time.Time{},
+	entries, err := s.Journal.FindAll()
+	if err != nil {
+		return err
 	}
 
-	if err := s.TransactionRepository.Insert(transaction); err != nil {
-		return err
+	for _, entry := range entries {
+		user, ok := users[entry.UserId]
+		if !ok && entry.Operation != models.JournalCreate {
+			zap.L().Warn("journal entry for unknown user", zap.Uint64("user_id", entry.UserId), zap.Uint64("seq", entry.Sequence))
+			continue
+		}
+
+		if !ok {
+			user = &models.UserModel{Id: entry.UserId, TokenHash: entry.TokenHash, Wallets: entry.Wallets, Sync: models.SyncNew}
+			user.OpeningWallets = make(models.Wallets, len(entry.Wallets))
+			for currency, balance := range entry.Wallets {
+				user.OpeningWallets[currency] = balance
+			}
+			users[entry.UserId] = user
+		} else if user.Sync != models.SyncNew {
+			user.Sync = models.SyncModified
+		}
+		switch entry.Operation {
+		case models.JournalCreate:
+		case models.JournalStatus:
+			user.Status = entry.Status
+			user.StatusReason = entry.Reason
+			user.ExcludedUntil = time.Time{}
+			if entry.ExcludedUntil != nil {
+				user.ExcludedUntil = *entry.ExcludedUntil
+			}
+			user.StatusChangedAt = entry.CreatedAt
+		case models.JournalToken:
+			user.TokenHash = entry.TokenHash
+			user.PreviousTokenHash = entry.PreviousTokenHash
+			user.PreviousTokenExpiresAt = time.Time{}
+			if entry.PreviousTokenExpiresAt != nil {
+				user.PreviousTokenExpiresAt = *entry.PreviousTokenExpiresAt
+			}
+		default:
+			if user.Wallets == nil {
+				user.Wallets = make(models.Wallets)
+			}
+			user.Wallets[entry.Currency] = entry.Balance
+		}
+		s.sequence = entry.Sequence
 	}
 
 	return nil
 }
 
+func (s *UserService) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock().UTC()
+	}
+
+	return time.Now().UTC()
+}
+
+func transactionDelta(transactionType string, amount models.Money) models.Money {
+	if transactionType == models.TypeBet {
+		return -amount
+	}
+
+	return amount
+}
+
+func addTransactionStatistic(statistic *models.StatisticModel, transactionType string, count int, amount models.Money) {
+	switch transactionType {
+	case models.TypeBet:
+		statistic.BetCount += count
+		statistic.BetSum += amount
+	case models.TypeWin:
+		statistic.WinCount += count
+		statistic.WinSum += amount
+	}
+}
+
 func (s *UserService) startTicker() {
 	go func() {
 		for range s.Ticker.C {
@@ -228,26 +939,71 @@ func (s *UserService) stopTicker() {
 
 }
 
-func (s *UserService) saveUser() error {
-	s.Lock()
-	defer s.Unlock()
+// Flush writes new and modified users to the repository and checkpoints the journal.
+func (s *UserService) Flush() error {
+	return s.saveUser()
+}
 
-	var newUsers []interface{}
-	for k := range s.Users {
-		if s.Users[k].Status == models.StatusNew {
-			newUsers = append(newUsers, *s.Users[k])
+// saveUser snapshots the dirty users one account at a time and writes them without holding any lock,
+// so traffic keeps flowing during the flush. Users whose write fails are marked dirty again.
+func (s *UserService) saveUser() error {
+	s.journalLock.Lock()
+	sequence := s.sequence
+	s.journalLock.Unlock()
+
+	s.RLock()
+	accounts := make([]*account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	s.RUnlock()
+
+	var newAccounts, modifiedAccounts []*account
+	var newUsers, modifiedUsers []models.UserModel
+	for _, a := range accounts {
+		a.Lock()
+		if a.user != nil && a.user.Sync != models.SyncSaved {
+			state := a.user.Sync
+			a.user.Sync = models.SyncSaved
+			user := *a.user
+			user.Wallets = a.user.Wallets.Copy()
+			if state == models.SyncNew {
+				newAccounts = append(newAccounts, a)
+				newUsers = append(newUsers, user)
+			} else {
+				modifiedAccounts = append(modifiedAccounts, a)
+				modifiedUsers = append(modifiedUsers, user)
+			}
 		}
+		a.Unlock()
+	}
 
-		if s.Users[k].Status == models.StatusModified {
-			if err := s.UserRepository.Update(s.Users[k]); err != nil {
-				return err
-			}
+	for i := range modifiedUsers {
+		if err := s.UserRepository.Update(&modifiedUsers[i]); err != nil {
+			markUnsaved(modifiedAccounts, models.SyncModified)
+			markUnsaved(newAccounts, models.SyncNew)
+			return err
 		}
 	}
 
 	if err := s.UserRepository.Insert(newUsers); err != nil {
+		markUnsaved(newAccounts, models.SyncNew)
 		return err
 	}
 
+	if s.Journal != nil {
+		return s.Journal.Checkpoint(sequence)
+	}
+
 	return nil
 }
+
+func markUnsaved(accounts []*account, state string) {
+	for _, a := range accounts {
+		a.Lock()
+		if state == models.SyncNew || a.user.Sync == models.SyncSaved {
+			a.user.Sync = state
+		}
+		a.Unlock()
+	}
+}
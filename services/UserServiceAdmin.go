@@ -0,0 +1,176 @@
+package services
+
+import (
+	"guru/models"
+	"guru/repositories"
+	"sort"
+)
+
+const defaultSearchLimit = 20
+
+// SearchUsers returns one page of the users matching the search, in id order.
+func (s *UserService) SearchUsers(searchRequest models.SearchUsersRequestModel) (*models.SearchUsersResponseModel, error) {
+	limit := searchRequest.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+
+	var userIds []uint64
+	s.RLock()
+	if len(searchRequest.UserIds) > 0 {
+		for _, id := range searchRequest.UserIds {
+			if _, ok := s.accounts[id]; ok {
+				userIds = append(userIds, id)
+			}
+		}
+	} else {
+		for id := range s.accounts {
+			userIds = append(userIds, id)
+		}
+	}
+	s.RUnlock()
+	sort.Slice(userIds, func(i, j int) bool { return userIds[i] < userIds[j] })
+
+	response := &models.SearchUsersResponseModel{Users: make([]models.GetUserResponseModel, 0, limit)}
+	for _, id := range userIds {
+		if id <= searchRequest.AfterId {
+			continue
+		}
+
+		user := s.searchUser(id, searchRequest)
+		if user == nil {
+			continue
+		}
+		if len(response.Users) == limit {
+			response.NextAfterId = response.Users[limit-1].Id
+			break
+		}
+		response.Users = append(response.Users, *user)
+	}
+
+	return response, nil
+}
+
+// searchUser reports the user when it matches the search. Users still being created match no search.
+func (s *UserService) searchUser(id uint64, searchRequest models.SearchUsersRequestModel) *models.GetUserResponseModel {
+	a, err := s.lockAccount(id)
+	if err != nil {
+		return nil
+	}
+	defer a.Unlock()
+
+	if searchRequest.Status != "" && a.user.AccountStatus(s.now()) != searchRequest.Status {
+		return nil
+	}
+	if _, ok := a.user.Wallets[searchRequest.Currency]; searchRequest.Currency != "" && !ok {
+		return nil
+	}
+
+	return s.userResponse(a)
+}
+
+// AdminGetUser reports any user, closed ones included, on behalf of the operator.
+func (s *UserService) AdminGetUser(userRequest models.AdminUserRequestModel) (*models.GetUserResponseModel, error) {
+	a, err := s.lockAccount(userRequest.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	return s.userResponse(a), nil
+}
+
+// AdminHistory returns one page of the ledger of any user on behalf of the operator: deposits, transactions,
+// withdrawals, adjustments and bonus conversions merged by ledger sequence.
+func (s *UserService) AdminHistory(historyRequest models.AdminHistoryRequestModel) (*models.HistoryResponseModel, error) {
+	a, err := s.lockAccount(historyRequest.UserId)
+	if err != nil {
+		return nil, err
+	}
+	a.Unlock()
+
+	return s.ledgerHistory(models.HistoryFilterModel{
+		UserId:   historyRequest.UserId,
+		Currency: historyRequest.Currency,
+		Types:    historyRequest.Types,
+		From:     historyRequest.From,
+		To:       historyRequest.To,
+		Limit:    historyRequest.Limit,
+	}, historyRequest.Cursor)
+}
+
+// AdjustBalance credits or debits a wallet by hand and records the adjustment in the ledger with its reason
+// and the operator who made it. Retrying an adjustment returns its recorded result.
+func (s *UserService) AdjustBalance(adjustRequest models.AdjustBalanceRequestModel, actor string) (*models.AdjustmentModel, error) {
+	a, err := s.lockAccount(adjustRequest.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	balance, err := a.wallet(adjustRequest.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustment, err := s.AdjustmentRepository.FindById(adjustRequest.AdjustmentId)
+	if err == nil {
+		if adjustment.UserId != adjustRequest.UserId ||
+			adjustment.Currency != adjustRequest.Currency ||
+			adjustment.Amount != adjustRequest.Amount {
+			return nil, ErrConflict
+		}
+		return adjustment, nil
+	}
+	if err != repositories.ErrNotFound {
+		return nil, err
+	}
+
+	balanceAfter, err := balance.Add(adjustRequest.Amount)
+	if err != nil {
+		return nil, ErrInvalidAmount
+	}
+	if balanceAfter < 0 {
+		return nil, ErrNotEnoughBalance
+	}
+
+	adjustment = &models.AdjustmentModel{
+		Id:            adjustRequest.AdjustmentId,
+		UserId:        adjustRequest.UserId,
+		Currency:      adjustRequest.Currency,
+		Amount:        adjustRequest.Amount,
+		Reason:        adjustRequest.Reason,
+		Actor:         actor,
+		BalanceBefore: balance,
+		BalanceAfter:  balanceAfter,
+		CreatedAt:     s.now(),
+		Sequence:      a.sequence + 1,
+	}
+	insert := s.AdjustmentRepository.Insert
+	if s.Ledger != nil {
+		insert = s.Ledger.InsertAdjustment
+	}
+	undo, err := s.journalAhead(models.JournalAdjustment, a.user, adjustRequest.Currency, adjustRequest.AdjustmentId, balanceAfter)
+	if err != nil {
+		return nil, err
+	}
+	if err := ledgerError(insert(*adjustment)); err != nil {
+		undo()
+		return nil, err
+	}
+
+	a.user.Wallets[adjustRequest.Currency] = balanceAfter
+	a.sequence++
+	a.touch()
+
+	return adjustment, nil
+}
+
+// Freeze suspends the account on behalf of the operator.
+func (s *UserService) Freeze(freezeRequest models.FreezeRequestModel) (*models.StatusResponseModel, error) {
+	return s.ChangeStatus(models.ChangeStatusRequestModel{
+		UserId: freezeRequest.UserId,
+		Status: models.StatusSuspended,
+		Reason: freezeRequest.Reason,
+	})
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"guru/models"
+	"guru/repositories"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 20
+	auditVerifyPage   = 1000
+)
+
+// Audit appends a call to the audit trail before the call returns, so no entry waits in memory to be lost on
+// a crash. Entries are chained under the audit lock, as every entry depends on the hash of the previous one.
+// An entry that cannot be stored is counted as dropped and its error returned; the chain continues from the
+// last entry stored.
+func (s *UserService) Audit(entry models.AuditModel) error {
+	entry.CreatedAt = s.now().Truncate(time.Millisecond)
+	if err := s.appendAudit(entry); err != nil {
+		atomic.AddUint64(&s.auditDropped, 1)
+		return err
+	}
+
+	return nil
+}
+
+func (s *UserService) appendAudit(entry models.AuditModel) error {
+	s.auditLock.Lock()
+	defer s.auditLock.Unlock()
+
+	for retried := false; ; retried = true {
+		entry.Sequence = s.auditLast.Sequence + 1
+		entry.PreviousHash = s.auditLast.Hash
+		entry.Hash = entry.ComputeHash()
+		err := s.AuditRepository.Insert(entry)
+		// Another instance took the sequence; continue the chain from its entry.
+		if err == repositories.ErrDuplicate && !retried {
+			last, findErr := s.AuditRepository.FindLast()
+			if findErr != nil {
+				return err
+			}
+			s.auditLast = *last
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.auditLast = entry
+
+		return nil
+	}
+}
+
+// SearchAudit returns one page of the audit trail in sequence order.
+func (s *UserService) SearchAudit(searchRequest models.SearchAuditRequestModel) (*models.SearchAuditResponseModel, error) {
+	limit := searchRequest.Limit
+	if limit == 0 {
+		limit = defaultAuditLimit
+	}
+
+	// Fetch one extra entry to know whether another page exists.
+	entries, err := s.AuditRepository.Find(models.AuditFilterModel{
+		UserId:        searchRequest.UserId,
+		From:          searchRequest.From,
+		To:            searchRequest.To,
+		AfterSequence: searchRequest.AfterSequence,
+		Limit:         limit + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.SearchAuditResponseModel{Entries: entries}
+	if len(entries) > limit {
+		response.Entries = entries[:limit]
+		response.NextAfterSequence = entries[limit-1].Sequence
+	}
+
+	return response, nil
+}
+
+// VerifyAudit walks the audit trail from its first entry and checks that every entry follows from the one
+// before it and that the trail reaches the last entry appended, so entries edited, removed or inserted
+// out of band are found. It also reports the entries dropped since the start.
+func (s *UserService) VerifyAudit() (*models.AuditVerificationModel, error) {
+	s.auditLock.Lock()
+	last := s.auditLast
+	s.auditLock.Unlock()
+
+	var previous models.AuditModel
+	verification := &models.AuditVerificationModel{Valid: true, Dropped: atomic.LoadUint64(&s.auditDropped)}
+	for previous.Sequence < last.Sequence {
+		entries, err := s.AuditRepository.Find(models.AuditFilterModel{AfterSequence: previous.Sequence, Limit: auditVerifyPage})
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		for _, entry := range entries {
+			if entry.Sequence > last.Sequence {
+				return verification, nil
+			}
+			if entry.Sequence != previous.Sequence+1 || entry.PreviousHash != previous.Hash || entry.Hash != entry.ComputeHash() {
+				verification.Valid = false
+				verification.BrokenAt = previous.Sequence + 1
+				return verification, nil
+			}
+			verification.Checked++
+			previous = entry
+		}
+	}
+
+	if previous.Sequence != last.Sequence || previous.Hash != last.Hash {
+		verification.Valid = false
+		verification.BrokenAt = previous.Sequence + 1
+	}
+
+	return verification, nil
+}
@@ -0,0 +1,530 @@
+package services
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"guru/models"
+	"guru/repositories"
+	"sort"
+	"time"
+)
+
+// ledgerBatch collects the storage writes of the transactions of a batch, so they can be stored together
+// or undone. Its methods accept a nil batch, which stands for a single transaction written through.
+type ledgerBatch struct {
+	entries []*batchEntry
+}
+
+// batchEntry is one applied transaction of a batch: its ledger record, the writes that follow it and the
+// state of its wallet and the ledger sequence of its user before it, to undo it with the journal entries
+// written ahead of it.
+type batchEntry struct {
+	index         int
+	account       *account
+	currency      string
+	transaction   *models.TransactionModel
+	writes        []func() error
+	rounds        []models.RoundModel
+	compensations []func()
+
+	balance    models.Money
+	sequence   uint64
+	statistic  *models.StatisticModel
+	bonus      *models.BonusModel
+	bonusValue models.BonusModel
+}
+
+// begin snapshots the wallet of the account before the transaction at index is applied to it.
+func (b *ledgerBatch) begin(index int, a *account, currency string) {
+	entry := &batchEntry{index: index, account: a, currency: currency, balance: a.user.Wallets[currency], sequence: a.sequence}
+	if statistic, ok := a.statistics[currency]; ok {
+		copied := *statistic
+		entry.statistic = &copied
+	}
+	if bonus, ok := a.bonuses[currency]; ok {
+		entry.bonus = bonus
+		entry.bonusValue = *bonus
+	}
+	b.entries = append(b.entries, entry)
+}
+
+func (b *ledgerBatch) current() *batchEntry {
+	return b.entries[len(b.entries)-1]
+}
+
+// undo restores the wallet of the current transaction and drops it from the batch.
+func (b *ledgerBatch) undo() *batchEntry {
+	entry := b.current()
+	b.entries = b.entries[:len(b.entries)-1]
+	entry.restore()
+
+	return entry
+}
+
+// restore puts the wallet back in its state before the transaction and journals the balance back.
+// Restoring several transactions of a user must go from the last to the first.
+func (entry *batchEntry) restore() {
+	for i := len(entry.compensations) - 1; i >= 0; i-- {
+		entry.compensations[i]()
+	}
+	entry.compensations = nil
+
+	a := entry.account
+	a.sequence = entry.sequence
+	if _, ok := a.user.Wallets[entry.currency]; ok {
+		a.user.Wallets[entry.currency] = entry.balance
+	}
+	if entry.statistic != nil {
+		*a.statistic(entry.currency) = *entry.statistic
+	} else {
+		delete(a.statistics, entry.currency)
+	}
+	if entry.bonus != nil {
+		*entry.bonus = entry.bonusValue
+		a.bonuses[entry.currency] = entry.bonus
+	} else {
+		delete(a.bonuses, entry.currency)
+	}
+}
+
+func (entry *batchEntry) wallet() models.StatisticKeyModel {
+	return models.StatisticKeyModel{UserId: entry.account.user.Id, Currency: entry.currency}
+}
+
+// run performs a storage write, within a batch once the transactions of the batch are stored.
+func (b *ledgerBatch) run(write func() error) error {
+	if b == nil {
+		return write()
+	}
+
+	entry := b.current()
+	entry.writes = append(entry.writes, write)
+
+	return nil
+}
+
+// compensate keeps the undo of a journal entry written ahead of the current transaction, to run when the
+// transaction is undone.
+func (b *ledgerBatch) compensate(undo func()) {
+	if b == nil {
+		return
+	}
+
+	entry := b.current()
+	entry.compensations = append(entry.compensations, undo)
+}
+
+// findTransaction returns a transaction of the batch that is not stored yet.
+func (b *ledgerBatch) findTransaction(id uint64) *models.TransactionModel {
+	if b == nil {
+		return nil
+	}
+
+	for _, entry := range b.entries {
+		if entry.transaction != nil && entry.transaction.Id == id {
+			transaction := *entry.transaction
+			return &transaction
+		}
+	}
+
+	return nil
+}
+
+// findRollback returns a rollback of the batch, not stored yet, of the original transaction.
+func (b *ledgerBatch) findRollback(originalId uint64) *models.TransactionModel {
+	if b == nil {
+		return nil
+	}
+
+	for _, entry := range b.entries {
+		if t := entry.transaction; t != nil && t.Type == models.TypeRollback && t.OriginalTransactionId == originalId {
+			transaction := *t
+			return &transaction
+		}
+	}
+
+	return nil
+}
+
+// findRound returns the latest state of a round opened or closed by the batch.
+func (b *ledgerBatch) findRound(provider string, roundId string) *models.RoundModel {
+	if b == nil {
+		return nil
+	}
+
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		rounds := b.entries[i].rounds
+		for j := len(rounds) - 1; j >= 0; j-- {
+			if rounds[j].Provider == provider && rounds[j].RoundId == roundId {
+				round := rounds[j]
+				return &round
+			}
+		}
+	}
+
+	return nil
+}
+
+// saveRound records the new state of a round for the transactions after the current one.
+func (b *ledgerBatch) saveRound(round models.RoundModel) models.RoundModel {
+	if b != nil {
+		entry := b.current()
+		entry.rounds = append(entry.rounds, round)
+	}
+
+	return round
+}
+
+// lossSum returns the net real money losses of the transactions of the batch in the wallet after from.
+func (b *ledgerBatch) lossSum(userId uint64, currency string, from time.Time) models.Money {
+	if b == nil {
+		return 0
+	}
+
+	var sum models.Money
+	for _, entry := range b.entries {
+		if t := entry.transaction; t != nil && t.UserId == userId && t.Currency == currency && t.CreatedAt.After(from) {
+			sum += t.BalanceBefore - t.BalanceAfter
+		}
+	}
+
+	return sum
+}
+
+// BatchTransaction applies and journals the transactions in order, as Transaction applies each of them, and
+// stores the applied ones with one ordered bulk insert. The accounts of all users of the batch stay locked
+// until then. In the all_or_nothing mode the first failed transaction undoes the others, which fail with
+// ErrBatchAborted; only a store failure leaves the transactions stored before it applied.
+func (s *UserService) BatchTransaction(batchRequest models.BatchTransactionRequestModel) (*models.BatchTransactionResponseModel, error) {
+	accounts := s.lockAccounts(batchRequest.Transactions)
+	defer func() {
+		for _, a := range accounts {
+			a.Unlock()
+		}
+	}()
+
+	b := &ledgerBatch{}
+	results := make([]models.BatchTransactionResultModel, len(batchRequest.Transactions))
+	failed := false
+	for i, transactionRequest := range batchRequest.Transactions {
+		results[i] = models.BatchTransactionResultModel{TransactionId: transactionRequest.TransactionId, Currency: transactionRequest.Currency}
+		if failed {
+			batchFailure(&results[i], ErrBatchAborted)
+			continue
+		}
+
+		response, err := s.batchItem(b, accounts, i, transactionRequest)
+		if err != nil {
+			batchFailure(&results[i], err)
+			failed = batchRequest.Mode == models.BatchAllOrNothing
+			continue
+		}
+		results[i].Balance = response.Balance
+	}
+
+	if failed {
+		for len(b.entries) > 0 {
+			b.undo()
+		}
+		for i := range results {
+			if results[i].Code == "" {
+				batchFailure(&results[i], ErrBatchAborted)
+			}
+		}
+	}
+	s.storeBatch(b, batchRequest.Mode, results)
+
+	applied := 0
+	for _, result := range results {
+		if result.Code == "" {
+			applied++
+		}
+	}
+
+	return &models.BatchTransactionResponseModel{Applied: applied, Results: results}, nil
+}
+
+// lockAccounts locks the accounts of the users of the transactions in the order of their ids, so concurrent
+// batches cannot deadlock. Users that do not exist are left out.
+func (s *UserService) lockAccounts(transactionRequests []models.TransactionRequestModel) map[uint64]*account {
+	ids := make([]uint64, 0, len(transactionRequests))
+	accounts := make(map[uint64]*account)
+	s.RLock()
+	for _, transactionRequest := range transactionRequests {
+		if _, ok := accounts[transactionRequest.UserId]; ok {
+			continue
+		}
+		if a, ok := s.accounts[transactionRequest.UserId]; ok {
+			accounts[transactionRequest.UserId] = a
+			ids = append(ids, transactionRequest.UserId)
+		}
+	}
+	s.RUnlock()
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids {
+		a := accounts[id]
+		a.Lock()
+		if a.user == nil {
+			// The user is still being created.
+			a.Unlock()
+			delete(accounts, id)
+		}
+	}
+
+	return accounts
+}
+
+// batchItem applies the transaction at index to the locked account of its user.
+func (s *UserService) batchItem(b *ledgerBatch, accounts map[uint64]*account, index int, transactionRequest models.TransactionRequestModel) (*models.TransactionResponseModel, error) {
+	a, ok := accounts[transactionRequest.UserId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !a.user.Authenticate(transactionRequest.Token, s.now()) {
+		return nil, ErrWrongToken
+	}
+
+	b.begin(index, a, transactionRequest.Currency)
+	response, err := s.transaction(b, a, transactionRequest)
+	if err != nil {
+		b.undo()
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// storeBatch stores the transactions of the batch and then performs the writes that follow each of them.
+// A transaction that fails to be stored is undone with the transactions of its user after it, which depend
+// on its balance and ledger sequence, or with the rest of the batch in the all_or_nothing mode. Nothing
+// stored is ever removed from the ledger. When the outcome of the write is unknown, the wallets are rebuilt
+// from the ledger.
+func (s *UserService) storeBatch(b *ledgerBatch, mode string, results []models.BatchTransactionResultModel) {
+	if len(b.entries) == 0 {
+		return
+	}
+
+	var failures map[*batchEntry]error
+	var err error
+	if s.Ledger != nil {
+		failures, err = s.storeLedgerBatch(b, mode)
+	} else {
+		failures, err = s.storeTransactionBatch(b, mode)
+	}
+	if err != nil {
+		s.reloadBatch(b, results, err)
+		return
+	}
+
+	dropped := make(map[*batchEntry]bool)
+	cut := make(map[*account]bool)
+	aborted := false
+	for _, entry := range b.entries {
+		if failures[entry] != nil {
+			cut[entry.account] = true
+			aborted = mode == models.BatchAllOrNothing
+		}
+		dropped[entry] = aborted || cut[entry.account]
+	}
+
+	for _, entry := range b.entries {
+		if dropped[entry] {
+			continue
+		}
+		for _, write := range entry.writes {
+			if err := write(); err != nil {
+				// Like a failed write after a single transaction is stored, the transaction stays applied.
+				zap.L().Error("write after stored transaction failed", zap.Uint64("user_id", entry.account.user.Id),
+					zap.Uint64("transaction_id", results[entry.index].TransactionId), zap.Error(err))
+				break
+			}
+		}
+	}
+
+	for i := len(b.entries) - 1; i >= 0; i-- {
+		entry := b.entries[i]
+		if !dropped[entry] {
+			continue
+		}
+		entry.restore()
+		if err := failures[entry]; err != nil {
+			batchFailure(&results[entry.index], err)
+		} else {
+			batchFailure(&results[entry.index], ErrBatchAborted)
+		}
+	}
+}
+
+// storeLedgerBatch stores the transactions with their balance changes through the ledger, all in one
+// transaction in the all_or_nothing mode and otherwise in one transaction per user. It returns the
+// error of each transaction that was not stored.
+func (s *UserService) storeLedgerBatch(b *ledgerBatch, mode string) (map[*batchEntry]error, error) {
+	var groups [][]*batchEntry
+	if mode == models.BatchAllOrNothing {
+		groups = append(groups, b.entries)
+	} else {
+		users := make(map[*account]int)
+		for _, entry := range b.entries {
+			group, ok := users[entry.account]
+			if !ok {
+				group = len(groups)
+				users[entry.account] = group
+				groups = append(groups, nil)
+			}
+			groups[group] = append(groups[group], entry)
+		}
+	}
+
+	failures := make(map[*batchEntry]error)
+	for _, group := range groups {
+		var transactions []models.TransactionModel
+		for _, entry := range group {
+			if entry.transaction != nil {
+				transactions = append(transactions, *entry.transaction)
+			}
+		}
+		if len(transactions) == 0 {
+			continue
+		}
+
+		err := s.Ledger.InsertTransactions(transactions)
+		if err == repositories.ErrDuplicate || err == repositories.ErrBalanceGuard {
+			for _, entry := range group {
+				failures[entry] = ledgerError(err)
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return failures, nil
+}
+
+// storeTransactionBatch inserts the transactions in batch order. When one of them fails, the transactions
+// after it are not stored; those of other users are inserted again unless the batch is all_or_nothing,
+// which keeps the ones stored before the failure. It returns the error of each transaction that failed.
+func (s *UserService) storeTransactionBatch(b *ledgerBatch, mode string) (map[*batchEntry]error, error) {
+	var entries []*batchEntry
+	for _, entry := range b.entries {
+		if entry.transaction != nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	failures := make(map[*batchEntry]error)
+	cut := make(map[*account]bool)
+	for len(entries) > 0 {
+		transactions := make([]models.TransactionModel, len(entries))
+		for i, entry := range entries {
+			transactions[i] = *entry.transaction
+		}
+
+		stored, err := s.TransactionRepository.InsertMany(transactions)
+		if stored < 0 {
+			return nil, err
+		}
+		if err == nil || mode == models.BatchAllOrNothing {
+			if err != nil {
+				failures[entries[stored]] = ledgerError(err)
+			}
+			break
+		}
+
+		failed := entries[stored]
+		failures[failed] = ledgerError(err)
+		cut[failed.account] = true
+		var rest []*batchEntry
+		for _, entry := range entries[stored+1:] {
+			if !cut[entry.account] {
+				rest = append(rest, entry)
+			}
+		}
+		entries = rest
+	}
+
+	return failures, nil
+}
+
+// reloadBatch undoes the batch after a write whose outcome is unknown and rebuilds its wallets from the
+// ledger, which holds whichever of its transactions were stored. Every transaction of the batch fails; a
+// retry with the same transaction ids replays the stored ones.
+func (s *UserService) reloadBatch(b *ledgerBatch, results []models.BatchTransactionResultModel, err error) {
+	var entries []*batchEntry
+	for len(b.entries) > 0 {
+		entries = append(entries, b.undo())
+	}
+
+	reloaded := make(map[models.StatisticKeyModel]bool)
+	for _, entry := range entries {
+		batchFailure(&results[entry.index], err)
+		if entry.transaction == nil || reloaded[entry.wallet()] {
+			continue
+		}
+		reloaded[entry.wallet()] = true
+		if err := s.reloadWallet(entry.account, entry.currency); err != nil {
+			zap.L().Error("wallet needs reconciliation", zap.Uint64("user_id", entry.account.user.Id),
+				zap.String("currency", entry.currency), zap.Error(err))
+		}
+	}
+}
+
+// reloadWallet replaces the balance of a wallet by the one rebuilt from its ledger and takes the last ledger
+// sequence of the user from it. The caller holds the account.
+func (s *UserService) reloadWallet(a *account, currency string) error {
+	steps, err := s.ledgerSteps(a.user.Id)
+	if err != nil {
+		return err
+	}
+
+	var walletSteps []ledgerStep
+	for _, step := range steps {
+		if step.sequence > a.sequence {
+			a.sequence = step.sequence
+		}
+		if step.currency == currency {
+			walletSteps = append(walletSteps, step)
+		}
+	}
+
+	balance, discrepancies := replayWallet(a.user, currency, walletSteps)
+	if len(discrepancies) > 0 {
+		return errors.New("ledger chain broken")
+	}
+	if a.user.Wallets[currency] == balance {
+		return nil
+	}
+	a.user.Wallets[currency] = balance
+	a.touch()
+
+	return s.journal(models.JournalRepair, a.user, currency, 0)
+}
+
+// batchFailure reports the error of a transaction in its result; internal errors are logged, not reported.
+func batchFailure(result *models.BatchTransactionResultModel, err error) {
+	domainError := AsError(err)
+	if domainError == ErrInternal {
+		zap.L().Error(err.Error(), zap.Uint64("transaction_id", result.TransactionId))
+	}
+	result.Balance = 0
+	result.Code = domainError.Code
+	result.Message = domainError.Message
+}
+
+func (s *UserService) findTransaction(b *ledgerBatch, id uint64) (*models.TransactionModel, error) {
+	if transaction := b.findTransaction(id); transaction != nil {
+		return transaction, nil
+	}
+
+	return s.TransactionRepository.FindById(id)
+}
+
+func (s *UserService) findRollback(b *ledgerBatch, originalId uint64) (*models.TransactionModel, error) {
+	if transaction := b.findRollback(originalId); transaction != nil {
+		return transaction, nil
+	}
+
+	return s.TransactionRepository.FindRollback(originalId)
+}
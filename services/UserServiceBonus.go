@@ -0,0 +1,203 @@
+package services
+
+import (
+	"guru/models"
+	"guru/repositories"
+)
+
+// GrantBonus adds bonus funds to a wallet of the user. A wallet holds at most one active bonus;
+// granting the same bonus id again returns the stored bonus.
+func (s *UserService) GrantBonus(grantRequest models.GrantBonusRequestModel) (*models.BonusResponseModel, error) {
+	a, err := s.lockAccount(grantRequest.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	if _, err := a.wallet(grantRequest.Currency); err != nil {
+		return nil, err
+	}
+
+	wageringTarget := grantRequest.Amount * models.Money(grantRequest.WageringMultiplier)
+	if wageringTarget/models.Money(grantRequest.WageringMultiplier) != grantRequest.Amount {
+		return nil, ErrInvalidWageringTarget
+	}
+
+	bonus, err := s.BonusRepository.FindById(grantRequest.BonusId)
+	if err == nil {
+		if bonus.UserId != grantRequest.UserId ||
+			bonus.Currency != grantRequest.Currency ||
+			bonus.Amount != grantRequest.Amount ||
+			bonus.WageringTarget != wageringTarget ||
+			!bonus.ExpiresAt.Equal(grantRequest.ExpiresAt) {
+			return nil, ErrConflict
+		}
+
+		return bonusResponse(bonus), nil
+	}
+	if err != repositories.ErrNotFound {
+		return nil, err
+	}
+
+	if !grantRequest.ExpiresAt.After(s.now()) {
+		return nil, ErrBonusAlreadyExpired
+	}
+
+	active, err := s.activeBonus(nil, a, grantRequest.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if active != nil {
+		return nil, ErrBonusAlreadyActive
+	}
+
+	bonus = &models.BonusModel{
+		Id:             grantRequest.BonusId,
+		UserId:         grantRequest.UserId,
+		Currency:       grantRequest.Currency,
+		Amount:         grantRequest.Amount,
+		Balance:        grantRequest.Amount,
+		WageringTarget: wageringTarget,
+		Status:         models.BonusActive,
+		ExpiresAt:      grantRequest.ExpiresAt.UTC(),
+		CreatedAt:      s.now(),
+	}
+	if err := ledgerError(s.BonusRepository.Insert(*bonus)); err != nil {
+		return nil, err
+	}
+	a.bonuses[bonus.Currency] = bonus
+
+	return bonusResponse(bonus), nil
+}
+
+// activeBonus returns the active bonus of a wallet. A bonus past its expiry is closed first,
+// forfeiting its balance, and no bonus is returned. The caller holds the account.
+func (s *UserService) activeBonus(b *ledgerBatch, a *account, currency string) (*models.BonusModel, error) {
+	bonus, ok := a.bonuses[currency]
+	if !ok {
+		return nil, nil
+	}
+	if s.now().Before(bonus.ExpiresAt) {
+		return bonus, nil
+	}
+
+	expired := *bonus
+	expired.Status = models.BonusExpired
+	expired.SettledAt = s.now()
+	if err := s.updateBonus(b, expired, 0); err != nil {
+		return nil, err
+	}
+	delete(a.bonuses, currency)
+
+	return nil, nil
+}
+
+// bonusShare returns the part of a transaction that is paid from or credited to the bonus.
+// Bets spend the real balance first, wins are split like the stakes wagered under the bonus
+// and rollbacks reverse the bonus part of the original transaction.
+func bonusShare(bonus *models.BonusModel, transactionType string, amount models.Money, balance models.Money, original *models.TransactionModel) models.Money {
+	switch transactionType {
+	case models.TypeBet:
+		if amount > balance {
+			return amount - balance
+		}
+	case models.TypeWin:
+		if bonus.Wagered > 0 {
+			return amount.Share(bonus.WageredBonus, bonus.Wagered)
+		}
+	case models.TypeRollback:
+		return original.BonusAmount
+	}
+
+	return 0
+}
+
+// applyBonus moves the bonus part of a Bet or Win and the wagered stake to the bonus; negative
+// amounts reverse them. It fails when the bonus balance cannot cover the part.
+func applyBonus(bonus *models.BonusModel, transactionType string, amount models.Money, bonusAmount models.Money) error {
+	switch transactionType {
+	case models.TypeBet:
+		bonus.Balance -= bonusAmount
+		bonus.Wagered += amount
+		bonus.WageredBonus += bonusAmount
+	case models.TypeWin:
+		bonus.Balance += bonusAmount
+	}
+	if bonus.Balance < 0 || bonus.Wagered < 0 || bonus.WageredBonus < 0 {
+		return ErrNotEnoughBalance
+	}
+
+	return nil
+}
+
+// settleBonus stores the bonus after a transaction changed it, converting its balance
+// to real money once the wagering target is met. The caller holds the account.
+func (s *UserService) settleBonus(b *ledgerBatch, a *account, bonus models.BonusModel) error {
+	if bonus.Wagered < bonus.WageringTarget {
+		if err := s.updateBonus(b, bonus, 0); err != nil {
+			return err
+		}
+		*a.bonuses[bonus.Currency] = bonus
+
+		return nil
+	}
+
+	bonus.Status = models.BonusConverted
+	bonus.SettledAt = s.now()
+	bonus.BalanceBefore = a.user.Wallets[bonus.Currency]
+	balanceAfter, err := bonus.BalanceBefore.Add(bonus.Balance)
+	if err != nil {
+		return ErrInvalidAmount
+	}
+	bonus.BalanceAfter = balanceAfter
+	bonus.Sequence = a.sequence + 1
+	undo, err := s.journalAhead(models.JournalBonus, a.user, bonus.Currency, bonus.Id, bonus.BalanceAfter)
+	if err != nil {
+		return err
+	}
+	if err := s.updateBonus(b, bonus, bonus.Balance); err != nil {
+		undo()
+		return err
+	}
+	b.compensate(undo)
+
+	a.user.Wallets[bonus.Currency] = bonus.BalanceAfter
+	a.sequence++
+	delete(a.bonuses, bonus.Currency)
+	a.touch()
+
+	return nil
+}
+
+func (s *UserService) updateBonus(b *ledgerBatch, bonus models.BonusModel, delta models.Money) error {
+	return b.run(func() error {
+		if s.Ledger == nil {
+			return s.BonusRepository.Update(bonus)
+		}
+
+		return ledgerError(s.Ledger.UpdateBonus(bonus, delta))
+	})
+}
+
+// bonusResponse reports the active bonus of a wallet, hiding a bonus that expired since the last transaction.
+func (s *UserService) bonusResponse(a *account, currency string) *models.BonusResponseModel {
+	bonus, ok := a.bonuses[currency]
+	if !ok || !s.now().Before(bonus.ExpiresAt) {
+		return nil
+	}
+
+	return bonusResponse(bonus)
+}
+
+func bonusResponse(bonus *models.BonusModel) *models.BonusResponseModel {
+	return &models.BonusResponseModel{
+		Id:             bonus.Id,
+		Currency:       bonus.Currency,
+		Amount:         bonus.Amount,
+		Balance:        bonus.Balance,
+		WageringTarget: bonus.WageringTarget,
+		Wagered:        bonus.Wagered,
+		Status:         bonus.Status,
+		ExpiresAt:      bonus.ExpiresAt,
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"guru/models"
+)
+
+const defaultHistoryLimit = 20
+
+// History returns one page of the user's deposits and transactions merged into a single ledger, newest first.
+func (s *UserService) History(historyRequest models.HistoryRequestModel) (*models.HistoryResponseModel, error) {
+	if err := s.authorize(historyRequest.UserId, historyRequest.Token); err != nil {
+		return nil, err
+	}
+
+	return s.history(models.HistoryFilterModel{
+		UserId:    historyRequest.UserId,
+		Currency:  historyRequest.Currency,
+		Types:     historyRequest.Types,
+		From:      historyRequest.From,
+		To:        historyRequest.To,
+		MinAmount: historyRequest.MinAmount,
+		MaxAmount: historyRequest.MaxAmount,
+		Limit:     historyRequest.Limit,
+	}, historyRequest.Cursor)
+}
+
+// history returns the page of the filtered ledger following the cursor.
+func (s *UserService) history(filter models.HistoryFilterModel, cursor string) (*models.HistoryResponseModel, error) {
+	// Amounts of different currencies are not comparable.
+	if filter.Currency == "" && (filter.MinAmount != 0 || filter.MaxAmount != 0) {
+		return nil, ErrCurrencyRequired
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultHistoryLimit
+	}
+	if cursor != "" {
+		after, err := models.DecodeHistoryCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter.After = after
+	}
+
+	// Fetch one extra entry from each source to know whether another page exists.
+	page := filter
+	page.Limit = filter.Limit + 1
+
+	var deposits []models.DepositModel
+	if filter.HasType(models.TypeDeposit) {
+		var err error
+		if deposits, err = s.DepositRepository.FindHistory(page); err != nil {
+			return nil, err
+		}
+	}
+
+	var transactions []models.TransactionModel
+	if filter.HasType(models.TypeBet) || filter.HasType(models.TypeWin) || filter.HasType(models.TypeRollback) {
+		var err error
+		if transactions, err = s.TransactionRepository.FindHistory(page); err != nil {
+			return nil, err
+		}
+	}
+
+	return mergeHistory(deposits, transactions, filter.Limit), nil
+}
+
+// ledgerHistory returns the page of the filtered ledger of every balance change of the user following the
+// cursor, newest first in ledger order. The whole ledger of the user is read, as the sources are only ordered
+// by their sequences together; a release, which records no balances, takes them from the entry before it.
+func (s *UserService) ledgerHistory(filter models.HistoryFilterModel, cursor string) (*models.HistoryResponseModel, error) {
+	if filter.Limit == 0 {
+		filter.Limit = defaultHistoryLimit
+	}
+	var after *ledgerStep
+	if cursor != "" {
+		position, err := models.DecodeHistoryCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &ledgerStep{sequence: position.Sequence, createdAt: position.CreatedAt, source: position.Source, id: position.Id}
+	}
+
+	steps, err := s.ledgerSteps(filter.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.HistoryEntryModel, len(steps))
+	balances := make(map[string]models.Money)
+	for i, step := range steps {
+		balanceBefore, balanceAfter := step.balanceBefore, step.balanceAfter
+		if !step.recorded {
+			balanceBefore = balances[step.currency]
+			balanceAfter = balanceBefore + step.delta
+		}
+		balances[step.currency] = balanceAfter
+		entries[i] = models.HistoryEntryModel{
+			Id:                    step.id,
+			Type:                  step.entryType,
+			Currency:              step.currency,
+			Amount:                step.amount,
+			OriginalTransactionId: step.originalId,
+			BalanceBefore:         balanceBefore,
+			BalanceAfter:          balanceAfter,
+			CreatedAt:             step.createdAt,
+			Sequence:              step.sequence,
+		}
+	}
+
+	response := &models.HistoryResponseModel{Entries: make([]models.HistoryEntryModel, 0, filter.Limit)}
+	var last models.HistoryCursorModel
+	for i := len(steps) - 1; i >= 0; i-- {
+		step, entry := steps[i], entries[i]
+		if after != nil && !step.precedes(*after) {
+			continue
+		}
+		if (filter.Currency != "" && entry.Currency != filter.Currency) ||
+			(!filter.From.IsZero() && entry.CreatedAt.Before(filter.From)) ||
+			(!filter.To.IsZero() && !entry.CreatedAt.Before(filter.To)) ||
+			!filter.HasType(entry.Type) {
+			continue
+		}
+		if len(response.Entries) == filter.Limit {
+			response.NextCursor = last.Encode()
+			break
+		}
+
+		response.Entries = append(response.Entries, entry)
+		last = models.HistoryCursorModel{CreatedAt: step.createdAt, Source: step.source, Id: step.id, Sequence: step.sequence}
+	}
+
+	return response, nil
+}
+
+func (s *UserService) authorize(userId uint64, token string) error {
+	a, err := s.lockAuthorized(userId, token)
+	if err != nil {
+		return err
+	}
+	a.Unlock()
+
+	return nil
+}
+
+// mergeHistory merges two newest-first lists into a page of at most limit entries.
+func mergeHistory(deposits []models.DepositModel, transactions []models.TransactionModel, limit int) *models.HistoryResponseModel {
+	response := &models.HistoryResponseModel{Entries: make([]models.HistoryEntryModel, 0, limit)}
+	var last models.HistoryCursorModel
+	for len(deposits)+len(transactions) > 0 {
+		if len(response.Entries) == limit {
+			response.NextCursor = last.Encode()
+			break
+		}
+
+		takeDeposit := len(transactions) == 0
+		if len(deposits) > 0 && len(transactions) > 0 {
+			cursor := models.HistoryCursorModel{
+				CreatedAt: transactions[0].CreatedAt,
+				Source:    models.HistorySourceTransaction,
+				Id:        transactions[0].Id,
+			}
+			takeDeposit = !cursor.Follows(deposits[0].CreatedAt, models.HistorySourceDeposit, deposits[0].Id)
+		}
+
+		if takeDeposit {
+			deposit := deposits[0]
+			deposits = deposits[1:]
+			response.Entries = append(response.Entries, models.HistoryEntryModel{
+				Id:            deposit.Id,
+				Type:          models.TypeDeposit,
+				Currency:      deposit.Currency,
+				Amount:        deposit.Amount,
+				BalanceBefore: deposit.BalanceBefore,
+				BalanceAfter:  deposit.BalanceAfter,
+				CreatedAt:     deposit.CreatedAt,
+			})
+			last = models.HistoryCursorModel{CreatedAt: deposit.CreatedAt, Source: models.HistorySourceDeposit, Id: deposit.Id}
+			continue
+		}
+
+		transaction := transactions[0]
+		transactions = transactions[1:]
+		response.Entries = append(response.Entries, models.HistoryEntryModel{
+			Id:                    transaction.Id,
+			Type:                  transaction.Type,
+			Currency:              transaction.Currency,
+			Amount:                transaction.Amount,
+			OriginalTransactionId: transaction.OriginalTransactionId,
+			BalanceBefore:         transaction.BalanceBefore,
+			BalanceAfter:          transaction.BalanceAfter,
+			CreatedAt:             transaction.CreatedAt,
+		})
+		last = models.HistoryCursorModel{CreatedAt: transaction.CreatedAt, Source: models.HistorySourceTransaction, Id: transaction.Id}
+	}
+
+	return response
+}
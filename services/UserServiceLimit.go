@@ -0,0 +1,174 @@
+package services
+
+import (
+	"guru/models"
+	"guru/repositories"
+	"sort"
+	"time"
+)
+
+// defaultLimitCoolingOff delays raising or removing a limit when UserService.LimitCoolingOff is zero.
+const defaultLimitCoolingOff = 24 * time.Hour
+
+// SetLimit sets a deposit or loss limit of a wallet. Adding or lowering a limit takes effect at once,
+// raising or removing it only after the cooling-off delay, until which the current amount stays in force.
+func (s *UserService) SetLimit(limitRequest models.SetLimitRequestModel) (*models.LimitModel, error) {
+	a, err := s.lockAuthorized(limitRequest.UserId, limitRequest.Token)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	if _, err := a.wallet(limitRequest.Currency); err != nil {
+		return nil, err
+	}
+
+	key := models.LimitKeyModel{Currency: limitRequest.Currency, Type: limitRequest.Type, Period: limitRequest.Period}
+	current, err := s.currentLimit(a, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var limit models.LimitModel
+	switch {
+	case limitRequest.Remove && current == nil:
+		return nil, ErrLimitNotFound
+	case limitRequest.Remove:
+		if current.PendingRemoval {
+			return current, nil
+		}
+		limit = *current
+		limit.PendingAmount = 0
+		limit.PendingRemoval = true
+		limit.PendingAt = s.now().Add(s.limitCoolingOff())
+	case current == nil || limitRequest.Amount <= current.Amount:
+		limit = models.LimitModel{
+			UserId:    limitRequest.UserId,
+			Currency:  limitRequest.Currency,
+			Type:      limitRequest.Type,
+			Period:    limitRequest.Period,
+			Amount:    limitRequest.Amount,
+			UpdatedAt: s.now(),
+		}
+	default:
+		if current.IsPending() && !current.PendingRemoval && current.PendingAmount == limitRequest.Amount {
+			return current, nil
+		}
+		limit = *current
+		limit.PendingAmount = limitRequest.Amount
+		limit.PendingRemoval = false
+		limit.PendingAt = s.now().Add(s.limitCoolingOff())
+	}
+
+	if err := s.LimitRepository.Upsert(limit); err != nil {
+		return nil, err
+	}
+	a.limits[key] = &limit
+
+	return &limit, nil
+}
+
+// currentLimit returns the limit in force, storing a pending change that has become due.
+// It returns nil when the wallet has no such limit. The caller holds the account.
+func (s *UserService) currentLimit(a *account, key models.LimitKeyModel) (*models.LimitModel, error) {
+	limit, ok := a.limits[key]
+	if !ok {
+		return nil, nil
+	}
+
+	effective, ok := limit.Effective(s.now())
+	if !ok {
+		if err := s.LimitRepository.Delete(*limit); err != nil && err != repositories.ErrNotFound {
+			return nil, err
+		}
+		delete(a.limits, key)
+
+		return nil, nil
+	}
+	if limit.IsPending() && !effective.IsPending() {
+		if err := s.LimitRepository.Upsert(effective); err != nil {
+			return nil, err
+		}
+		*limit = effective
+	}
+
+	return limit, nil
+}
+
+// checkLimits fails when adding amount to the deposits or losses of the wallet within
+// the rolling window of any of its limits of that type would exceed the limit. The caller holds the account.
+func (s *UserService) checkLimits(b *ledgerBatch, a *account, limitType string, currency string, amount models.Money) error {
+	for _, period := range models.LimitPeriods {
+		limit, err := s.currentLimit(a, models.LimitKeyModel{Currency: currency, Type: limitType, Period: period})
+		if err != nil {
+			return err
+		}
+		if limit == nil {
+			continue
+		}
+
+		from := s.now().Add(-models.LimitWindow(period))
+		sum, err := s.limitSum(b, limitType, a.user.Id, currency, from)
+		if err != nil {
+			return err
+		}
+		if sum+amount > limit.Amount {
+			if limitType == models.LimitDeposit {
+				return ErrDepositLimitExceeded
+			}
+			return ErrLossLimitExceeded
+		}
+	}
+
+	return nil
+}
+
+// limitSum returns the deposits or the net real money losses of the wallet recorded in the ledger after from,
+// counting the losses of the transactions of the batch that are not stored yet.
+func (s *UserService) limitSum(b *ledgerBatch, limitType string, userId uint64, currency string, from time.Time) (models.Money, error) {
+	if limitType == models.LimitDeposit {
+		return s.DepositRepository.FindDepositSum(userId, currency, from)
+	}
+
+	sum, err := s.TransactionRepository.FindLossSum(userId, currency, from)
+	if err != nil {
+		return 0, err
+	}
+
+	return sum + b.lossSum(userId, currency, from), nil
+}
+
+func (s *UserService) limitCoolingOff() time.Duration {
+	if s.LimitCoolingOff > 0 {
+		return s.LimitCoolingOff
+	}
+
+	return defaultLimitCoolingOff
+}
+
+// limitResponses reports the limits of the user as they stand now, sorted by wallet, type and period.
+func (s *UserService) limitResponses(a *account) []models.LimitModel {
+	order := make(map[string]int, len(models.LimitPeriods))
+	for i, period := range models.LimitPeriods {
+		order[period] = i
+	}
+
+	var limits []models.LimitModel
+	for _, limit := range a.limits {
+		if effective, ok := limit.Effective(s.now()); ok {
+			limits = append(limits, effective)
+		}
+	}
+	sort.Slice(limits, func(i, j int) bool {
+		if limits[i].Currency != limits[j].Currency {
+			return limits[i].Currency < limits[j].Currency
+		}
+		if limits[i].Type != limits[j].Type {
+			return limits[i].Type < limits[j].Type
+		}
+
+		return order[limits[i].Period] < order[limits[j].Period]
+	})
+
+	return limits
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"guru/models"
+	"guru/repositories"
+	"sync/atomic"
+)
+
+// ProviderCall serves a seamless-wallet call of a game provider. Authenticate and balance calls report the wallet,
+// debit, credit and rollback calls book the provider's transaction on the account of the user.
+func (s *UserService) ProviderCall(provider string, call models.ProviderRequestModel) (*models.ProviderResponseModel, error) {
+	var transactionType string
+	switch call.Action {
+	case models.ProviderAuthenticate, models.ProviderBalance:
+		return s.providerBalance(call)
+	case models.ProviderDebit:
+		transactionType = models.TypeBet
+	case models.ProviderCredit:
+		transactionType = models.TypeWin
+	case models.ProviderRollback:
+		transactionType = models.TypeRollback
+	default:
+		return nil, ErrUnknownProviderAction
+	}
+
+	a, err := s.lockAuthorized(call.UserId, call.Token)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	transactionResponse, err := s.providerTransaction(a, call.OriginalTransactionId, models.TransactionRequestModel{
+		UserId:     call.UserId,
+		Type:       transactionType,
+		Currency:   call.Currency,
+		Amount:     call.Amount,
+		Token:      call.Token,
+		RoundId:    call.RoundId,
+		GameId:     call.GameId,
+		Provider:   provider,
+		CloseRound: call.CloseRound,
+		ExternalId: call.TransactionId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ProviderResponseModel{
+		UserId:   call.UserId,
+		Currency: transactionResponse.Currency,
+		Balance:  transactionResponse.Balance,
+	}, nil
+}
+
+// providerTransaction books a provider call on the locked account of its user. Calls are found again by the
+// provider and its own id, the ids of their original too; a new transaction takes the next id of the provider
+// sequence. When another instance took that id meanwhile, the call is tried once more past its transaction.
+func (s *UserService) providerTransaction(a *account, originalExternalId string, transactionRequest models.TransactionRequestModel) (*models.TransactionResponseModel, error) {
+	if originalExternalId != "" {
+		original, err := s.TransactionRepository.FindByExternalId(transactionRequest.Provider, originalExternalId)
+		if err != nil && err != repositories.ErrNotFound {
+			return nil, err
+		}
+		if err == nil && original.UserId == transactionRequest.UserId {
+			transactionRequest.OriginalTransactionId = original.Id
+			if transactionRequest.Type == models.TypeRollback && transactionRequest.Amount == 0 {
+				// Providers reverse a transaction by its id alone, the rollback takes over the amount of the original.
+				transactionRequest.Amount = original.Amount
+			}
+		} else if transactionRequest.Type == models.TypeRollback {
+			return nil, ErrOriginalTransactionNotFound
+		}
+	}
+
+	for retried := false; ; retried = true {
+		transaction, err := s.TransactionRepository.FindByExternalId(transactionRequest.Provider, transactionRequest.ExternalId)
+		if err == nil {
+			return replayedTransaction(transaction, transactionRequest)
+		}
+		if err != repositories.ErrNotFound {
+			return nil, err
+		}
+
+		transactionRequest.TransactionId = atomic.AddUint64(&s.lastProviderId, 1)
+		response, err := s.transaction(nil, a, transactionRequest)
+		if err == ErrConflict && !retried {
+			lastProviderId, findErr := s.TransactionRepository.FindLastProviderId()
+			if findErr != nil {
+				return nil, err
+			}
+			s.raiseProviderId(lastProviderId)
+			continue
+		}
+
+		return response, err
+	}
+}
+
+// raiseProviderId continues the provider sequence after last, and after the ids left to clients.
+func (s *UserService) raiseProviderId(last uint64) {
+	if last < models.ProviderTransactionIdBase {
+		last = models.ProviderTransactionIdBase
+	}
+	for {
+		current := atomic.LoadUint64(&s.lastProviderId)
+		if current >= last || atomic.CompareAndSwapUint64(&s.lastProviderId, current, last) {
+			return
+		}
+	}
+}
+
+func (s *UserService) providerBalance(call models.ProviderRequestModel) (*models.ProviderResponseModel, error) {
+	a, err := s.lockAuthorized(call.UserId, call.Token)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	// A game session starts only on an active account, open rounds may still read the balance.
+	allowed := []string{models.StatusActive}
+	if call.Action == models.ProviderBalance {
+		allowed = append(allowed, models.StatusSuspended, models.StatusSelfExcluded)
+	}
+	if err := s.checkStatus(a, allowed...); err != nil {
+		return nil, err
+	}
+
+	balance, err := a.wallet(call.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ProviderResponseModel{
+		UserId:   call.UserId,
+		Currency: call.Currency,
+		Balance:  balance,
+	}, nil
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"guru/models"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ledgerStep is one balance change of a user. Steps without recorded balances
+// (a rejected withdrawal releasing its hold) are applied but not chain checked.
+// Rows stored before ledger sequences existed have sequence 0. The entry type,
+// amount and original of the row make the step an entry of the admin history.
+type ledgerStep struct {
+	sequence      uint64
+	currency      string
+	createdAt     time.Time
+	source        string
+	id            uint64
+	delta         models.Money
+	recorded      bool
+	balanceBefore models.Money
+	balanceAfter  models.Money
+	entryType     string
+	amount        models.Money
+	originalId    uint64
+}
+
+// precedes reports whether the step comes before the other one in ledger order.
+func (step ledgerStep) precedes(other ledgerStep) bool {
+	if step.sequence != other.sequence {
+		return step.sequence < other.sequence
+	}
+	if !step.createdAt.Equal(other.createdAt) {
+		return step.createdAt.Before(other.createdAt)
+	}
+	if step.source != other.source {
+		return sourceOrder[step.source] < sourceOrder[other.source]
+	}
+
+	return step.id < other.id
+}
+
+// sourceOrder orders steps without sequences made at the same instant; a bonus converts right after the bet
+// meeting its target.
+var sourceOrder = map[string]int{
+	models.HistorySourceDeposit:     0,
+	models.HistorySourceTransaction: 1,
+	models.HistorySourceWithdrawal:  2,
+	models.HistorySourceBonus:       3,
+	models.HistorySourceAdjustment:  4,
+}
+
+// Reconcile replays the ledger of every wallet of the requested users, or of every user when none
+// are given, from the balances the users were created with, and reports sequence gaps, chain breaks and
+// balances that differ from the ledger. With Repair set, mismatching balances are replaced by the ones
+// rebuilt from the ledger, unless the ledger of the wallet is broken. Requested users must exist.
+func (s *UserService) Reconcile(reconcileRequest models.ReconcileRequestModel) (*models.ReconciliationReportModel, error) {
+	userIds := reconcileRequest.UserIds
+	if len(userIds) == 0 {
+		s.RLock()
+		for id := range s.accounts {
+			userIds = append(userIds, id)
+		}
+		s.RUnlock()
+		sort.Slice(userIds, func(i, j int) bool { return userIds[i] < userIds[j] })
+	}
+
+	report := &models.ReconciliationReportModel{Discrepancies: make([]models.DiscrepancyModel, 0)}
+	for _, userId := range userIds {
+		discrepancies, err := s.reconcileUser(userId, reconcileRequest.Repair)
+		if err == ErrNotFound && len(reconcileRequest.UserIds) == 0 {
+			// The user is still being created.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		report.CheckedUsers++
+		report.Discrepancies = append(report.Discrepancies, discrepancies...)
+	}
+
+	return report, nil
+}
+
+func (s *UserService) reconcileUser(userId uint64, repair bool) ([]models.DiscrepancyModel, error) {
+	a, err := s.lockAccount(userId)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	steps, err := s.ledgerSteps(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	discrepancies := sequenceGaps(userId, steps)
+	wallets := make(map[string][]ledgerStep)
+	for currency := range a.user.Wallets {
+		wallets[currency] = nil
+	}
+	for _, step := range steps {
+		wallets[step.currency] = append(wallets[step.currency], step)
+	}
+	currencies := make([]string, 0, len(wallets))
+	for currency := range wallets {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	for _, currency := range currencies {
+		// Rows missing from the ledger may belong to any wallet of the user.
+		walletDiscrepancies, err := s.reconcileWallet(a, currency, wallets[currency], repair && len(discrepancies) == 0)
+		if err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, walletDiscrepancies...)
+	}
+
+	return discrepancies, nil
+}
+
+// sequenceGaps reports every ledger row of the user following missing or repeated ledger sequences.
+func sequenceGaps(userId uint64, steps []ledgerStep) []models.DiscrepancyModel {
+	var discrepancies []models.DiscrepancyModel
+	var last uint64
+	for _, step := range steps {
+		if step.sequence == 0 {
+			continue
+		}
+		if step.sequence != last+1 {
+			discrepancies = append(discrepancies, models.DiscrepancyModel{
+				UserId:   userId,
+				Kind:     models.DiscrepancySequenceGap,
+				Currency: step.currency,
+				Source:   step.source,
+				EntryId:  step.id,
+				Sequence: step.sequence,
+			})
+		}
+		last = step.sequence
+	}
+
+	return discrepancies
+}
+
+// reconcileWallet checks the ledger steps of one currency against the wallet balance. A wallet whose
+// chain is broken is not repaired, its ledger is in doubt. The caller holds the account.
+func (s *UserService) reconcileWallet(a *account, currency string, steps []ledgerStep, repair bool) ([]models.DiscrepancyModel, error) {
+	balance, discrepancies := replayWallet(a.user, currency, steps)
+
+	if actual := a.user.Wallets[currency]; actual != balance {
+		discrepancy := models.DiscrepancyModel{
+			UserId:   a.user.Id,
+			Kind:     models.DiscrepancyBalanceMismatch,
+			Currency: currency,
+			Expected: balance,
+			Actual:   actual,
+		}
+		if repair && len(discrepancies) == 0 {
+			if err := s.repairWallet(a, currency, balance); err != nil {
+				return nil, err
+			}
+			discrepancy.Repaired = true
+		}
+		discrepancies = append(discrepancies, discrepancy)
+	}
+
+	return discrepancies, nil
+}
+
+// replayWallet replays the ledger steps of one currency from the opening balance of the wallet and returns the
+// rebuilt balance with the chain breaks and amount mismatches.
+func replayWallet(user *models.UserModel, currency string, steps []ledgerStep) (models.Money, []models.DiscrepancyModel) {
+	var discrepancies []models.DiscrepancyModel
+	balance := openingBalance(user, currency, steps)
+	for _, step := range steps {
+		if step.recorded && step.balanceBefore != balance {
+			discrepancies = append(discrepancies, models.DiscrepancyModel{
+				UserId:   user.Id,
+				Kind:     models.DiscrepancyChainBreak,
+				Currency: currency,
+				Source:   step.source,
+				EntryId:  step.id,
+				Expected: balance,
+				Actual:   step.balanceBefore,
+			})
+		}
+		if step.recorded && step.balanceAfter != step.balanceBefore+step.delta {
+			discrepancies = append(discrepancies, models.DiscrepancyModel{
+				UserId:   user.Id,
+				Kind:     models.DiscrepancyAmountMismatch,
+				Currency: currency,
+				Source:   step.source,
+				EntryId:  step.id,
+				Expected: step.balanceBefore + step.delta,
+				Actual:   step.balanceAfter,
+			})
+		}
+		balance += step.delta
+	}
+
+	return balance, discrepancies
+}
+
+// openingBalance returns the balance the ledger of a wallet starts from: the one the user was created with, zero
+// for wallets opened later. Users stored before opening balances were kept start from their first ledger row, or
+// from their current balance when the wallet has none.
+func openingBalance(user *models.UserModel, currency string, steps []ledgerStep) models.Money {
+	if user.OpeningWallets != nil {
+		return user.OpeningWallets[currency]
+	}
+	for _, step := range steps {
+		if step.recorded {
+			return step.balanceBefore
+		}
+	}
+
+	return user.Wallets[currency]
+}
+
+// repairWallet moves the balance of a wallet to the one rebuilt from the ledger and records the move as a
+// repair adjustment, through the ledger when it is set. The caller holds the account.
+func (s *UserService) repairWallet(a *account, currency string, balance models.Money) error {
+	actual := a.user.Wallets[currency]
+	adjustment := models.AdjustmentModel{
+		Id:            s.repairId(),
+		UserId:        a.user.Id,
+		Currency:      currency,
+		Amount:        balance - actual,
+		Reason:        "reconciliation repair",
+		Actor:         "reconcile",
+		Repair:        true,
+		BalanceBefore: actual,
+		BalanceAfter:  balance,
+		CreatedAt:     s.now(),
+	}
+	insert := s.AdjustmentRepository.Insert
+	if s.Ledger != nil {
+		insert = s.Ledger.InsertAdjustment
+	}
+	if err := ledgerError(insert(adjustment)); err != nil {
+		return err
+	}
+
+	a.user.Wallets[currency] = balance
+	a.touch()
+
+	return s.journal(models.JournalRepair, a.user, currency, adjustment.Id)
+}
+
+// repairId returns the next id of the repairs, which have ids of their own apart from the ids operators choose.
+func (s *UserService) repairId() uint64 {
+	return atomic.AddUint64(&s.lastRepairId, 1)
+}
+
+// ledgerSteps collects every balance change of the user in ledger order: rows without sequences first, by
+// time, then the others by sequence.
+func (s *UserService) ledgerSteps(userId uint64) ([]ledgerStep, error) {
+	filter := models.HistoryFilterModel{UserId: userId}
+	deposits, err := s.DepositRepository.FindHistory(filter)
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := s.TransactionRepository.FindHistory(filter)
+	if err != nil {
+		return nil, err
+	}
+	withdrawals, err := s.WithdrawalRepository.FindByUserId(userId)
+	if err != nil {
+		return nil, err
+	}
+	bonuses, err := s.BonusRepository.FindByUserId(userId)
+	if err != nil {
+		return nil, err
+	}
+	adjustments, err := s.AdjustmentRepository.FindByUserId(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []ledgerStep
+	for _, deposit := range deposits {
+		steps = append(steps, ledgerStep{
+			sequence:      deposit.Sequence,
+			currency:      deposit.Currency,
+			createdAt:     deposit.CreatedAt,
+			source:        models.HistorySourceDeposit,
+			id:            deposit.Id,
+			delta:         deposit.Amount,
+			recorded:      true,
+			balanceBefore: deposit.BalanceBefore,
+			balanceAfter:  deposit.BalanceAfter,
+			entryType:     models.TypeDeposit,
+			amount:        deposit.Amount,
+		})
+	}
+
+	originals := make(map[uint64]models.TransactionModel)
+	for _, transaction := range transactions {
+		originals[transaction.Id] = transaction
+	}
+	for _, transaction := range transactions {
+		// Only the part not paid from or credited to a bonus moves the real balance; a rollback
+		// reverses the real money part of its original, even when the bonus is gone.
+		delta := transactionDelta(transaction.Type, transaction.Amount-transaction.BonusAmount)
+		if transaction.Type == models.TypeRollback {
+			original := originals[transaction.OriginalTransactionId]
+			delta = -transactionDelta(original.Type, original.Amount-original.BonusAmount)
+		}
+
+		steps = append(steps, ledgerStep{
+			sequence:      transaction.Sequence,
+			currency:      transaction.Currency,
+			createdAt:     transaction.CreatedAt,
+			source:        models.HistorySourceTransaction,
+			id:            transaction.Id,
+			delta:         delta,
+			recorded:      true,
+			balanceBefore: transaction.BalanceBefore,
+			balanceAfter:  transaction.BalanceAfter,
+			entryType:     transaction.Type,
+			amount:        transaction.Amount,
+			originalId:    transaction.OriginalTransactionId,
+		})
+	}
+
+	for _, withdrawal := range withdrawals {
+		steps = append(steps, ledgerStep{
+			sequence:      withdrawal.Sequence,
+			currency:      withdrawal.Currency,
+			createdAt:     withdrawal.CreatedAt,
+			source:        models.HistorySourceWithdrawal,
+			id:            withdrawal.Id,
+			delta:         -withdrawal.Amount,
+			recorded:      true,
+			balanceBefore: withdrawal.BalanceBefore,
+			balanceAfter:  withdrawal.BalanceAfter,
+			entryType:     models.TypeWithdrawal,
+			amount:        withdrawal.Amount,
+		})
+		if withdrawal.Status == models.WithdrawalRejected {
+			steps = append(steps, ledgerStep{
+				sequence:  withdrawal.ReleaseSequence,
+				currency:  withdrawal.Currency,
+				createdAt: withdrawal.SettledAt,
+				source:    models.HistorySourceWithdrawal,
+				id:        withdrawal.Id,
+				delta:     withdrawal.Amount,
+				entryType: models.TypeWithdrawalRelease,
+				amount:    withdrawal.Amount,
+			})
+		}
+	}
+
+	for _, bonus := range bonuses {
+		if bonus.Status != models.BonusConverted {
+			continue
+		}
+
+		steps = append(steps, ledgerStep{
+			sequence:      bonus.Sequence,
+			currency:      bonus.Currency,
+			createdAt:     bonus.SettledAt,
+			source:        models.HistorySourceBonus,
+			id:            bonus.Id,
+			delta:         bonus.Balance,
+			recorded:      true,
+			balanceBefore: bonus.BalanceBefore,
+			balanceAfter:  bonus.BalanceAfter,
+			entryType:     models.TypeBonusConversion,
+			amount:        bonus.Balance,
+		})
+	}
+
+	for _, adjustment := range adjustments {
+		if adjustment.Repair {
+			// A repair moved the stored balance to the ledger, it is no step of the ledger.
+			continue
+		}
+		steps = append(steps, ledgerStep{
+			sequence:      adjustment.Sequence,
+			currency:      adjustment.Currency,
+			createdAt:     adjustment.CreatedAt,
+			source:        models.HistorySourceAdjustment,
+			id:            adjustment.Id,
+			delta:         adjustment.Amount,
+			recorded:      true,
+			balanceBefore: adjustment.BalanceBefore,
+			balanceAfter:  adjustment.BalanceAfter,
+			entryType:     models.TypeAdjustment,
+			amount:        adjustment.Amount,
+		})
+	}
+
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].precedes(steps[j])
+	})
+
+	return steps, nil
+}
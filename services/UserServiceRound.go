@@ -0,0 +1,110 @@
+package services
+
+import (
+	"guru/models"
+	"guru/repositories"
+)
+
+// CloseRound closes an open round of the user; closing a closed round returns it unchanged.
+func (s *UserService) CloseRound(closeRequest models.CloseRoundRequestModel) (*models.RoundModel, error) {
+	a, err := s.lockAuthorized(closeRequest.UserId, closeRequest.Token)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	round, err := s.RoundRepository.FindById(closeRequest.Provider, closeRequest.RoundId)
+	if err == repositories.ErrNotFound || (err == nil && round.UserId != closeRequest.UserId) {
+		return nil, ErrRoundNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if round.Status == models.RoundOpen {
+		round.Status = models.RoundClosed
+		round.ClosedAt = s.now()
+		if err := s.RoundRepository.Update(*round); err != nil {
+			return nil, err
+		}
+	}
+
+	return round, nil
+}
+
+// findRound returns the stored round of a transaction, or nil when the transaction has no round or its
+// round is new. In strict mode wins must belong to a known round, and only rollbacks are accepted
+// on a closed round. The caller holds the account.
+func (s *UserService) findRound(b *ledgerBatch, transactionRequest models.TransactionRequestModel) (*models.RoundModel, error) {
+	if transactionRequest.RoundId == "" {
+		return nil, nil
+	}
+
+	round := b.findRound(transactionRequest.Provider, transactionRequest.RoundId)
+	var err error
+	if round == nil {
+		round, err = s.RoundRepository.FindById(transactionRequest.Provider, transactionRequest.RoundId)
+	}
+	if err == repositories.ErrNotFound {
+		if s.StrictRounds && transactionRequest.Type == models.TypeWin {
+			return nil, ErrRoundNotFound
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if round.UserId != transactionRequest.UserId ||
+		round.Currency != transactionRequest.Currency ||
+		(transactionRequest.GameId != "" && round.GameId != transactionRequest.GameId) {
+		return nil, ErrRoundMismatch
+	}
+
+	if s.StrictRounds && round.Status == models.RoundClosed && transactionRequest.Type != models.TypeRollback {
+		return nil, ErrRoundClosed
+	}
+
+	return round, nil
+}
+
+// saveRound opens the round of a saved transaction when it is new and closes it when the transaction asks to.
+func (s *UserService) saveRound(b *ledgerBatch, transactionRequest models.TransactionRequestModel, round *models.RoundModel) error {
+	if transactionRequest.RoundId == "" {
+		return nil
+	}
+
+	if round == nil {
+		round = &models.RoundModel{
+			Provider:  transactionRequest.Provider,
+			RoundId:   transactionRequest.RoundId,
+			UserId:    transactionRequest.UserId,
+			GameId:    transactionRequest.GameId,
+			Currency:  transactionRequest.Currency,
+			Status:    models.RoundOpen,
+			CreatedAt: s.now(),
+		}
+		if transactionRequest.CloseRound {
+			round.Status = models.RoundClosed
+			round.ClosedAt = s.now()
+		}
+
+		inserted := b.saveRound(*round)
+
+		return b.run(func() error {
+			return ledgerError(s.RoundRepository.Insert(inserted))
+		})
+	}
+
+	if !transactionRequest.CloseRound || round.Status == models.RoundClosed {
+		return nil
+	}
+
+	round.Status = models.RoundClosed
+	round.ClosedAt = s.now()
+	closed := b.saveRound(*round)
+
+	return b.run(func() error {
+		return s.RoundRepository.Update(closed)
+	})
+}
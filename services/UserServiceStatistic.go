@@ -0,0 +1,113 @@
+package services
+
+import (
+	"guru/models"
+	"sort"
+)
+
+// Statistics returns the user's deposit, bet and win counts and sums per time bucket and currency,
+// and the bets and wins per game and provider within the window.
+func (s *UserService) Statistics(statisticRequest models.StatisticRequestModel) (*models.StatisticResponseModel, error) {
+	if err := s.authorize(statisticRequest.UserId, statisticRequest.Token); err != nil {
+		return nil, err
+	}
+
+	filter := models.StatisticFilterModel{
+		UserId:      statisticRequest.UserId,
+		From:        statisticRequest.From,
+		To:          statisticRequest.To,
+		Granularity: statisticRequest.Granularity,
+	}
+	buckets, err := s.statisticBuckets(filter)
+	if err != nil {
+		return nil, err
+	}
+	games, providers, err := s.gameStatistics(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.StatisticResponseModel{
+		Granularity: statisticRequest.Granularity,
+		Buckets:     buckets,
+		Games:       games,
+		Providers:   providers,
+	}, nil
+}
+
+// gameStatistics returns the bets and wins per game and their totals per provider, in each currency.
+func (s *UserService) gameStatistics(filter models.StatisticFilterModel) ([]models.GameStatisticModel, []models.GameStatisticModel, error) {
+	results := make(map[models.GameStatisticKeyModel]*models.GameStatisticModel)
+	if err := s.TransactionRepository.FindGameStatistics(filter, results); err != nil {
+		return nil, nil, err
+	}
+
+	var games []models.GameStatisticModel
+	totals := make(map[models.GameStatisticKeyModel]*models.GameStatisticModel)
+	for _, game := range results {
+		games = append(games, *game)
+
+		provider := models.GameStatisticModel{Provider: game.Provider, Currency: game.Currency}
+		if _, ok := totals[provider.Key()]; !ok {
+			totals[provider.Key()] = &provider
+		}
+		total := totals[provider.Key()]
+		total.BetCount += game.BetCount
+		total.BetSum += game.BetSum
+		total.WinCount += game.WinCount
+		total.WinSum += game.WinSum
+	}
+
+	var providers []models.GameStatisticModel
+	for _, total := range totals {
+		providers = append(providers, *total)
+	}
+	sortGameStatistics(games)
+	sortGameStatistics(providers)
+
+	return games, providers, nil
+}
+
+func sortGameStatistics(games []models.GameStatisticModel) {
+	sort.Slice(games, func(i, j int) bool {
+		if games[i].Provider != games[j].Provider {
+			return games[i].Provider < games[j].Provider
+		}
+		if games[i].GameId != games[j].GameId {
+			return games[i].GameId < games[j].GameId
+		}
+
+		return games[i].Currency < games[j].Currency
+	})
+}
+
+func (s *UserService) statisticBuckets(filter models.StatisticFilterModel) ([]models.StatisticBucketModel, error) {
+	if !filter.To.IsZero() && !filter.To.After(filter.From) {
+		return nil, ErrInvalidTimeWindow
+	}
+
+	results := make(map[models.StatisticBucketKeyModel]*models.StatisticBucketModel)
+	if err := s.DepositRepository.FindDepositBuckets(filter, results); err != nil {
+		return nil, err
+	}
+	if err := s.TransactionRepository.FindBetBuckets(filter, results); err != nil {
+		return nil, err
+	}
+	if err := s.TransactionRepository.FindWinBuckets(filter, results); err != nil {
+		return nil, err
+	}
+
+	buckets := make([]models.StatisticBucketModel, 0, len(results))
+	for _, bucket := range results {
+		buckets = append(buckets, *bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if !buckets[i].Start.Equal(buckets[j].Start) {
+			return buckets[i].Start.Before(buckets[j].Start)
+		}
+
+		return buckets[i].Currency < buckets[j].Currency
+	})
+
+	return buckets, nil
+}
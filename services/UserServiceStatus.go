@@ -0,0 +1,113 @@
+package services
+
+import (
+	"guru/models"
+	"time"
+)
+
+// statusTransitions lists the statuses an account may move to from the status in force. Until it lapses,
+// a self-exclusion can only be extended or turned into a closure, and a closed account stays closed.
+var statusTransitions = map[string][]string{
+	models.StatusActive:       {models.StatusSuspended, models.StatusSelfExcluded, models.StatusClosed},
+	models.StatusSuspended:    {models.StatusActive, models.StatusSelfExcluded, models.StatusClosed},
+	models.StatusSelfExcluded: {models.StatusSelfExcluded, models.StatusClosed},
+}
+
+// SetStatus lets the player self-exclude or close the account.
+func (s *UserService) SetStatus(statusRequest models.SetStatusRequestModel) (*models.StatusResponseModel, error) {
+	a, err := s.lockAuthorized(statusRequest.UserId, statusRequest.Token)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	return s.changeStatus(a, statusRequest.Status, statusRequest.Reason, statusRequest.ExcludedUntil)
+}
+
+// ChangeStatus moves the account to a status on behalf of the operator.
+func (s *UserService) ChangeStatus(statusRequest models.ChangeStatusRequestModel) (*models.StatusResponseModel, error) {
+	a, err := s.lockAccount(statusRequest.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	return s.changeStatus(a, statusRequest.Status, statusRequest.Reason, statusRequest.ExcludedUntil)
+}
+
+// changeStatus checks the transition and stores the new status. The caller holds the account.
+func (s *UserService) changeStatus(a *account, status string, reason string, excludedUntil time.Time) (*models.StatusResponseModel, error) {
+	current := a.user.AccountStatus(s.now())
+	allowed := false
+	for _, next := range statusTransitions[current] {
+		if next == status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, ErrInvalidStatusTransition
+	}
+
+	if status != models.StatusSelfExcluded {
+		excludedUntil = time.Time{}
+	} else if !excludedUntil.After(s.now()) ||
+		(current == models.StatusSelfExcluded && !excludedUntil.After(a.user.ExcludedUntil)) {
+		// A self-exclusion must end in the future and can be extended but not shortened.
+		return nil, ErrInvalidExclusionEnd
+	}
+
+	user := *a.user
+	user.Status = status
+	user.StatusReason = reason
+	user.ExcludedUntil = excludedUntil.UTC()
+	user.StatusChangedAt = s.now()
+	if s.Ledger != nil {
+		if err := s.UserRepository.Update(&user); err != nil {
+			return nil, err
+		}
+	}
+
+	*a.user = user
+	if s.Ledger == nil {
+		a.touch()
+	}
+	if err := s.journal(models.JournalStatus, a.user, "", 0); err != nil {
+		return nil, err
+	}
+
+	return statusResponse(a.user, s.now()), nil
+}
+
+// checkStatus fails unless the account status in force is one of allowed. The caller holds the account.
+func (s *UserService) checkStatus(a *account, allowed ...string) error {
+	current := a.user.AccountStatus(s.now())
+	for _, status := range allowed {
+		if current == status {
+			return nil
+		}
+	}
+
+	switch current {
+	case models.StatusSuspended:
+		return ErrAccountSuspended
+	case models.StatusSelfExcluded:
+		return ErrAccountSelfExcluded
+	}
+
+	return ErrAccountClosed
+}
+
+func statusResponse(user *models.UserModel, now time.Time) *models.StatusResponseModel {
+	response := &models.StatusResponseModel{
+		UserId:    user.Id,
+		Status:    user.AccountStatus(now),
+		Reason:    user.StatusReason,
+		ChangedAt: user.StatusChangedAt,
+	}
+	if response.Status == models.StatusSelfExcluded {
+		excludedUntil := user.ExcludedUntil
+		response.ExcludedUntil = &excludedUntil
+	}
+
+	return response
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"guru/models"
+	"time"
+)
+
+// RotateToken replaces the token of the user by a new random token. Only the current token may rotate,
+// the replaced one is still accepted during the requested grace period.
+func (s *UserService) RotateToken(rotateRequest models.RotateTokenRequestModel) (*models.RotateTokenResponseModel, error) {
+	a, err := s.lockAccount(rotateRequest.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	if !models.MatchTokenHash(a.user.TokenHash, rotateRequest.Token) {
+		return nil, ErrWrongToken
+	}
+	if err := s.checkStatus(a, models.StatusActive, models.StatusSuspended, models.StatusSelfExcluded); err != nil {
+		return nil, err
+	}
+
+	token, err := models.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	user := *a.user
+	if user.TokenHash, err = models.NewTokenHash(token); err != nil {
+		return nil, err
+	}
+	user.PreviousTokenHash = ""
+	user.PreviousTokenExpiresAt = time.Time{}
+	if rotateRequest.GracePeriod > 0 {
+		user.PreviousTokenHash = a.user.TokenHash
+		user.PreviousTokenExpiresAt = s.now().Add(time.Duration(rotateRequest.GracePeriod) * time.Second)
+	}
+	if s.Ledger != nil {
+		if err := s.UserRepository.Update(&user); err != nil {
+			return nil, err
+		}
+	}
+
+	*a.user = user
+	if s.Ledger == nil {
+		a.touch()
+	}
+	if err := s.journal(models.JournalToken, a.user, "", 0); err != nil {
+		return nil, err
+	}
+
+	response := &models.RotateTokenResponseModel{UserId: user.Id, Token: token}
+	if user.PreviousTokenHash != "" {
+		previousTokenExpiresAt := user.PreviousTokenExpiresAt
+		response.PreviousTokenExpiresAt = &previousTokenExpiresAt
+	}
+
+	return response, nil
+}
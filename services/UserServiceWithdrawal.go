@@ -0,0 +1,174 @@
+package services
+
+import (
+	"guru/models"
+	"guru/repositories"
+)
+
+// Withdraw holds the requested amount from the user balance until the withdrawal is settled. A retry reports the
+// withdrawal as it is now, with the balance it left.
+func (s *UserService) Withdraw(withdrawalRequest models.WithdrawalRequestModel) (*models.WithdrawalResponseModel, error) {
+	a, err := s.lockAuthorized(withdrawalRequest.UserId, withdrawalRequest.Token)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+
+	balanceBefore, err := a.wallet(withdrawalRequest.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	withdrawal, err := s.WithdrawalRepository.FindById(withdrawalRequest.WithdrawalId)
+	if err == nil {
+		if withdrawal.UserId != withdrawalRequest.UserId ||
+			withdrawal.Currency != withdrawalRequest.Currency ||
+			withdrawal.Amount != withdrawalRequest.Amount {
+			return nil, ErrConflict
+		}
+
+		return withdrawalResponse(withdrawal, withdrawal.BalanceAfter), nil
+	}
+	if err != repositories.ErrNotFound {
+		return nil, err
+	}
+
+	if balanceBefore < withdrawalRequest.Amount {
+		return nil, ErrNotEnoughBalance
+	}
+
+	insert := s.WithdrawalRepository.Insert
+	if s.Ledger != nil {
+		insert = s.Ledger.InsertWithdrawal
+	}
+
+	undo, err := s.journalAhead(models.JournalWithdrawal, a.user, withdrawalRequest.Currency, withdrawalRequest.WithdrawalId, balanceBefore-withdrawalRequest.Amount)
+	if err != nil {
+		return nil, err
+	}
+	withdrawal = &models.WithdrawalModel{
+		Id:            withdrawalRequest.WithdrawalId,
+		UserId:        withdrawalRequest.UserId,
+		Currency:      withdrawalRequest.Currency,
+		Amount:        withdrawalRequest.Amount,
+		Status:        models.WithdrawalPending,
+		BalanceBefore: balanceBefore,
+		BalanceAfter:  balanceBefore - withdrawalRequest.Amount,
+		CreatedAt:     s.now(),
+		Sequence:      a.sequence + 1,
+	}
+	if err := ledgerError(insert(*withdrawal)); err != nil {
+		undo()
+		return nil, err
+	}
+
+	a.user.Wallets[withdrawalRequest.Currency] -= withdrawalRequest.Amount
+	a.sequence++
+	a.statistic(withdrawalRequest.Currency).PendingWithdrawalSum += withdrawalRequest.Amount
+	a.touch()
+
+	return withdrawalResponse(withdrawal, a.user.Wallets[withdrawal.Currency]), nil
+}
+
+// WithdrawalOwner returns the user a withdrawal belongs to.
+func (s *UserService) WithdrawalOwner(withdrawalId uint64) (uint64, error) {
+	withdrawal, err := s.WithdrawalRepository.FindById(withdrawalId)
+	if err == repositories.ErrNotFound {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return withdrawal.UserId, nil
+}
+
+// SettleWithdrawal approves a pending withdrawal or rejects it and releases the hold back to the balance.
+func (s *UserService) SettleWithdrawal(settleRequest models.SettleWithdrawalRequestModel) (*models.WithdrawalResponseModel, error) {
+	withdrawal, err := s.WithdrawalRepository.FindById(settleRequest.WithdrawalId)
+	if err == repositories.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The withdrawal is read again under the account lock, a concurrent settlement may have changed it.
+	a, err := s.lockAccount(withdrawal.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Unlock()
+	if withdrawal, err = s.WithdrawalRepository.FindById(settleRequest.WithdrawalId); err != nil {
+		return nil, err
+	}
+
+	if withdrawal.Status != models.WithdrawalPending && withdrawal.Status != settleRequest.Status {
+		return nil, ErrWithdrawalAlreadySettled
+	}
+
+	if withdrawal.Status == models.WithdrawalPending {
+		withdrawal.Status = settleRequest.Status
+		withdrawal.SettledAt = s.now()
+		undo := func() {}
+		if withdrawal.Status == models.WithdrawalRejected {
+			withdrawal.ReleaseSequence = a.sequence + 1
+			balance, err := a.user.Wallets[withdrawal.Currency].Add(withdrawal.Amount)
+			if err != nil {
+				return nil, ErrInvalidAmount
+			}
+			if undo, err = s.journalAhead(models.JournalSettlement, a.user, withdrawal.Currency, withdrawal.Id, balance); err != nil {
+				return nil, err
+			}
+		}
+		err := s.updateWithdrawal(*withdrawal)
+		if err != nil {
+			undo()
+		}
+		if err == repositories.ErrNotFound {
+			// Settled elsewhere since it was read.
+			return nil, ErrWithdrawalAlreadySettled
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		statistic := a.statistic(withdrawal.Currency)
+		statistic.PendingWithdrawalSum -= withdrawal.Amount
+		if withdrawal.Status == models.WithdrawalApproved {
+			statistic.WithdrawalCount += 1
+			statistic.WithdrawalSum += withdrawal.Amount
+		}
+		if withdrawal.Status == models.WithdrawalRejected {
+			a.user.Wallets[withdrawal.Currency] += withdrawal.Amount
+			a.sequence++
+			a.touch()
+		}
+	}
+
+	return withdrawalResponse(withdrawal, a.user.Wallets[withdrawal.Currency]), nil
+}
+
+func withdrawalResponse(withdrawal *models.WithdrawalModel, balance models.Money) *models.WithdrawalResponseModel {
+	return &models.WithdrawalResponseModel{
+		Id:       withdrawal.Id,
+		UserId:   withdrawal.UserId,
+		Currency: withdrawal.Currency,
+		Amount:   withdrawal.Amount,
+		Status:   withdrawal.Status,
+		Balance:  balance,
+	}
+}
+
+func (s *UserService) updateWithdrawal(withdrawal models.WithdrawalModel) error {
+	if s.Ledger == nil {
+		return s.WithdrawalRepository.Update(withdrawal)
+	}
+
+	var release models.Money
+	if withdrawal.Status == models.WithdrawalRejected {
+		release = withdrawal.Amount
+	}
+
+	return s.Ledger.UpdateWithdrawal(withdrawal, release)
+}
@@ -0,0 +1,1292 @@
+package services
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"guru/models"
+	"guru/repositories"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const benchmarkUsers = 64
+
+// slowDepositRepository simulates the latency of a remote store on every insert.
+type slowDepositRepository struct {
+	*repositories.MemoryDepositRepository
+	latency time.Duration
+}
+
+func (r *slowDepositRepository) Insert(depositModel models.DepositModel) error {
+	time.Sleep(r.latency)
+	return r.MemoryDepositRepository.Insert(depositModel)
+}
+
+// failingJournalRepository fails appends while failing is set.
+type failingJournalRepository struct {
+	*repositories.MemoryJournalRepository
+	failing bool
+}
+
+func (r *failingJournalRepository) Append(entry models.JournalEntryModel) error {
+	if r.failing {
+		return errors.New("disk full")
+	}
+
+	return r.MemoryJournalRepository.Append(entry)
+}
+
+// failingDepositRepository fails inserts while failing is set.
+type failingDepositRepository struct {
+	*repositories.MemoryDepositRepository
+	failing bool
+}
+
+func (r *failingDepositRepository) Insert(depositModel models.DepositModel) error {
+	if r.failing {
+		return errors.New("connection reset")
+	}
+
+	return r.MemoryDepositRepository.Insert(depositModel)
+}
+
+// failingAuditRepository fails inserts while failing is set.
+type failingAuditRepository struct {
+	*repositories.MemoryAuditRepository
+	failing bool
+}
+
+func (r *failingAuditRepository) Insert(auditModel models.AuditModel) error {
+	if r.failing {
+		return errors.New("connection reset")
+	}
+
+	return r.MemoryAuditRepository.Insert(auditModel)
+}
+
+// failingTransactionRepository stops a bulk insert at some transactions, or stores them all and reports an
+// error, like a store whose reply is lost.
+type failingTransactionRepository struct {
+	*repositories.MemoryTransactionRepository
+	failing map[uint64]bool
+	lost    bool
+}
+
+func (r *failingTransactionRepository) InsertMany(transactionModels []models.TransactionModel) (int, error) {
+	for i, transactionModel := range transactionModels {
+		if r.failing[transactionModel.Id] {
+			return i, repositories.ErrDuplicate
+		}
+		if err := r.Insert(transactionModel); err != nil {
+			return i, err
+		}
+	}
+	if r.lost {
+		return -1, errors.New("connection reset")
+	}
+
+	return len(transactionModels), nil
+}
+
+// failingRoundRepository fails every insert of a round.
+type failingRoundRepository struct {
+	*repositories.MemoryRoundRepository
+}
+
+func (r *failingRoundRepository) Insert(roundModel models.RoundModel) error {
+	return errors.New("connection reset")
+}
+
+// blockingUserRepository holds the first insert until release is closed and fails it.
+type blockingUserRepository struct {
+	*repositories.MemoryUserRepository
+	release chan struct{}
+	started chan struct{}
+	once    sync.Once
+}
+
+func (r *blockingUserRepository) Insert(userModels []models.UserModel) error {
+	first := false
+	r.once.Do(func() {
+		first = true
+		close(r.started)
+		<-r.release
+	})
+	if first {
+		return errors.New("connection reset")
+	}
+
+	return r.MemoryUserRepository.Insert(userModels)
+}
+
+// testServiceOption changes the service newTestService builds before it is loaded.
+type testServiceOption func(*UserService)
+
+// withUsers replaces the default users.
+func withUsers(users ...models.UserModel) testServiceOption {
+	return func(s *UserService) {
+		s.UserRepository = repositories.NewMemoryUserRepository(users...)
+	}
+}
+
+func withClock(clock func() time.Time) testServiceOption {
+	return func(s *UserService) {
+		s.Clock = clock
+	}
+}
+
+func withJournal(journal repositories.JournalRepository) testServiceOption {
+	return func(s *UserService) {
+		s.Journal = journal
+	}
+}
+
+// restartTestService loads a new service over the repositories, journal and clock of service, like a restart
+// before the next flush, which recovers the balances from the journal.
+func restartTestService(tb testing.TB, service *UserService) *UserService {
+	return newTestService(tb, service.DepositRepository, func(restarted *UserService) {
+		restarted.UserRepository = service.UserRepository
+		restarted.TransactionRepository = service.TransactionRepository
+		restarted.WithdrawalRepository = service.WithdrawalRepository
+		restarted.BonusRepository = service.BonusRepository
+		restarted.LimitRepository = service.LimitRepository
+		restarted.RoundRepository = service.RoundRepository
+		restarted.AdjustmentRepository = service.AdjustmentRepository
+		restarted.AuditRepository = service.AuditRepository
+		restarted.Journal = service.Journal
+		restarted.Clock = service.Clock
+	})
+}
+
+func newTestService(tb testing.TB, deposits repositories.DepositRepository, options ...testServiceOption) *UserService {
+	users := make([]models.UserModel, 0, benchmarkUsers)
+	for id := uint64(1); id <= benchmarkUsers; id++ {
+		users = append(users, models.UserModel{Id: id, Wallets: models.Wallets{"EUR": 1000000}, OpeningWallets: models.Wallets{"EUR": 1000000}, Token: "token"})
+	}
+
+	service := &UserService{
+		UserRepository:        repositories.NewMemoryUserRepository(users...),
+		DepositRepository:     deposits,
+		TransactionRepository: repositories.NewMemoryTransactionRepository(),
+		WithdrawalRepository:  repositories.NewMemoryWithdrawalRepository(),
+		BonusRepository:       repositories.NewMemoryBonusRepository(),
+		LimitRepository:       repositories.NewMemoryLimitRepository(),
+		RoundRepository:       repositories.NewMemoryRoundRepository(),
+		AdjustmentRepository:  repositories.NewMemoryAdjustmentRepository(),
+		AuditRepository:       repositories.NewMemoryAuditRepository(),
+		Journal:               repositories.NewMemoryJournalRepository(),
+	}
+	for _, option := range options {
+		option(service)
+	}
+	if err := service.Load(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return service
+}
+
+func TestUserService_ConcurrentDeposits(t *testing.T) {
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+
+	var wg sync.WaitGroup
+	for i := uint64(1); i <= 100; i++ {
+		wg.Add(1)
+		go func(depositId uint64) {
+			defer wg.Done()
+			_, err := service.AddDeposit(models.DepositRequestModel{UserId: depositId%2 + 1, DepositId: depositId, Currency: "EUR", Amount: 100, Token: "token"})
+			assert.Nil(t, err)
+		}(i)
+		if i%10 == 0 {
+			assert.Nil(t, service.Flush())
+		}
+	}
+	wg.Wait()
+	assert.Nil(t, service.Flush())
+
+	for _, userId := range []uint64{1, 2} {
+		user, err := service.GetUser(models.GetUserRequestModel{Id: userId, Token: "token"})
+		assert.Nil(t, err)
+		assert.Equal(t, models.Money(1005000), user.Wallets[0].Balance)
+		assert.Equal(t, 50, user.Wallets[0].DepositCount)
+	}
+
+	users := make(map[uint64]*models.UserModel)
+	assert.Nil(t, service.UserRepository.FindAll(users))
+	assert.Equal(t, models.Wallets{"EUR": 1005000}, users[1].Wallets)
+	assert.Equal(t, models.Wallets{"EUR": 1005000}, users[2].Wallets)
+}
+
+func TestUserService_ConcurrentCreate(t *testing.T) {
+	users := &blockingUserRepository{MemoryUserRepository: repositories.NewMemoryUserRepository(), release: make(chan struct{}), started: make(chan struct{})}
+	service := newTestService(t, repositories.NewMemoryDepositRepository(), func(s *UserService) {
+		s.UserRepository = users
+		s.Ledger = repositories.NewMemoryLedgerRepository(users.MemoryUserRepository, repositories.NewMemoryDepositRepository(), repositories.NewMemoryTransactionRepository(),
+			repositories.NewMemoryWithdrawalRepository(), repositories.NewMemoryBonusRepository(), repositories.NewMemoryAdjustmentRepository())
+	})
+	create := func() error {
+		return service.CreateUser(100, models.UserModel{Id: 100, Wallets: models.Wallets{"EUR": 0}, Token: "token"})
+	}
+
+	// The second create waits on the placeholder of the first, which fails and drops it.
+	failed := make(chan error)
+	go func() { failed <- create() }()
+	<-users.started
+	created := make(chan error)
+	go func() { created <- create() }()
+	time.Sleep(10 * time.Millisecond)
+	close(users.release)
+
+	assert.NotNil(t, <-failed)
+	assert.Nil(t, <-created)
+	_, err := service.GetUser(models.GetUserRequestModel{Id: 100, Token: "token"})
+	assert.Nil(t, err)
+}
+
+func TestUserService_JournalRestart(t *testing.T) {
+	deposits := &failingDepositRepository{MemoryDepositRepository: repositories.NewMemoryDepositRepository()}
+	journal := &failingJournalRepository{MemoryJournalRepository: repositories.NewMemoryJournalRepository()}
+	service := newTestService(t, deposits, withJournal(journal))
+	balance := func(service *UserService) models.Money {
+		user, err := service.GetUser(models.GetUserRequestModel{Id: 1, Token: "token"})
+		assert.Nil(t, err)
+		return user.Wallets[0].Balance
+	}
+	deposit := func(id uint64) error {
+		_, err := service.AddDeposit(models.DepositRequestModel{UserId: 1, DepositId: id, Currency: "EUR", Amount: 10000, Token: "token"})
+		return err
+	}
+
+	// A mutation that cannot be journaled is neither stored nor applied.
+	journal.failing = true
+	assert.NotNil(t, deposit(1))
+	journal.failing = false
+	_, err := deposits.FindById(1)
+	assert.Equal(t, repositories.ErrNotFound, err)
+	assert.Equal(t, models.Money(1000000), balance(service))
+
+	// A mutation journaled but not stored is journaled back.
+	deposits.failing = true
+	assert.NotNil(t, deposit(2))
+	deposits.failing = false
+	assert.Equal(t, models.Money(1000000), balance(service))
+	assert.Equal(t, models.Money(1000000), balance(restartTestService(t, service)))
+
+	assert.Nil(t, deposit(3))
+	_, err = service.Transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 1, Type: models.TypeBet, Currency: "EUR", Amount: 3000, Token: "token"})
+	assert.Nil(t, err)
+	_, err = service.Withdraw(models.WithdrawalRequestModel{UserId: 1, WithdrawalId: 1, Currency: "EUR", Amount: 2000, Token: "token"})
+	assert.Nil(t, err)
+	_, err = service.SettleWithdrawal(models.SettleWithdrawalRequestModel{WithdrawalId: 1, Status: models.WithdrawalRejected})
+	assert.Nil(t, err)
+	_, err = service.AdjustBalance(models.AdjustBalanceRequestModel{UserId: 1, AdjustmentId: 1, Currency: "EUR", Amount: -500, Reason: "chargeback"}, "admin")
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(1006500), balance(service))
+	assert.Equal(t, models.Money(1006500), balance(restartTestService(t, service)))
+}
+
+func TestUserService_BonusWagering(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	service.Clock = func() time.Time { return now }
+
+	transaction := func(id uint64, transactionType string, amount models.Money, originalId uint64) {
+		_, err := service.Transaction(models.TransactionRequestModel{
+			UserId:                1,
+			TransactionId:         id,
+			Type:                  transactionType,
+			Currency:              "EUR",
+			Amount:                amount,
+			Token:                 "token",
+			OriginalTransactionId: originalId,
+		})
+		assert.Nil(t, err)
+	}
+
+	transaction(1, models.TypeBet, 990000, 0)
+	_, err := service.GrantBonus(models.GrantBonusRequestModel{
+		UserId:             1,
+		BonusId:            1,
+		Currency:           "EUR",
+		Amount:             50000,
+		WageringMultiplier: 2,
+		ExpiresAt:          now.Add(time.Hour),
+	})
+	assert.Nil(t, err)
+
+	// The bet spends the remaining 100.00 of real money and 200.00 of bonus.
+	transaction(2, models.TypeBet, 30000, 0)
+	// Two thirds of the stakes came from the bonus, so are two thirds of the win.
+	transaction(3, models.TypeWin, 9000, 0)
+	transaction(4, models.TypeRollback, 9000, 3)
+	transaction(5, models.TypeWin, 60000, 0)
+
+	user, err := service.GetUser(models.GetUserRequestModel{Id: 1, Token: "token"})
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(20000), user.Wallets[0].Balance)
+	assert.Equal(t, &models.BonusResponseModel{
+		Id:             1,
+		Currency:       "EUR",
+		Amount:         50000,
+		Balance:        70000,
+		WageringTarget: 100000,
+		Wagered:        30000,
+		Status:         models.BonusActive,
+		ExpiresAt:      now.Add(time.Hour),
+	}, user.Wallets[0].Bonus)
+
+	// The bet meets the wagering target and the remaining bonus converts to real money.
+	transaction(6, models.TypeBet, 70000, 0)
+
+	user, err = service.GetUser(models.GetUserRequestModel{Id: 1, Token: "token"})
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(20000), user.Wallets[0].Balance)
+	assert.Nil(t, user.Wallets[0].Bonus)
+
+	bonus, err := service.BonusRepository.FindById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, models.BonusConverted, bonus.Status)
+	assert.Equal(t, models.Money(20000), bonus.Balance)
+
+	report, err := service.Reconcile(models.ReconcileRequestModel{UserIds: []uint64{1}})
+	assert.Nil(t, err)
+	assert.Empty(t, report.Discrepancies)
+}
+
+func TestUserService_BonusExpiry(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	service.Clock = func() time.Time { return now }
+
+	_, err := service.GrantBonus(models.GrantBonusRequestModel{
+		UserId:             1,
+		BonusId:            1,
+		Currency:           "EUR",
+		Amount:             50000,
+		WageringMultiplier: 10,
+		ExpiresAt:          now.Add(time.Hour),
+	})
+	assert.Nil(t, err)
+
+	now = now.Add(time.Hour)
+	user, err := service.GetUser(models.GetUserRequestModel{Id: 1, Token: "token"})
+	assert.Nil(t, err)
+	assert.Nil(t, user.Wallets[0].Bonus)
+
+	_, err = service.Transaction(models.TransactionRequestModel{
+		UserId:        1,
+		TransactionId: 1,
+		Type:          models.TypeBet,
+		Currency:      "EUR",
+		Amount:        1010000,
+		Token:         "token",
+	})
+	assert.Equal(t, "not enough balance", err.Error())
+
+	bonus, err := service.BonusRepository.FindById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, models.BonusExpired, bonus.Status)
+}
+
+func TestUserService_RollbackAfterBonusExpiry(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	service.Clock = func() time.Time { return now }
+
+	_, err := service.GrantBonus(models.GrantBonusRequestModel{
+		UserId:             1,
+		BonusId:            1,
+		Currency:           "EUR",
+		Amount:             50000,
+		WageringMultiplier: 100,
+		ExpiresAt:          now.Add(time.Hour),
+	})
+	assert.Nil(t, err)
+
+	// The bet spends all real money and 100.00 of bonus.
+	_, err = service.Transaction(models.TransactionRequestModel{
+		UserId:        1,
+		TransactionId: 1,
+		Type:          models.TypeBet,
+		Currency:      "EUR",
+		Amount:        1010000,
+		Token:         "token",
+	})
+	assert.Nil(t, err)
+
+	// The bonus expires before the rollback, which returns only the real money part.
+	now = now.Add(time.Hour)
+	response, err := service.Transaction(models.TransactionRequestModel{
+		UserId:                1,
+		TransactionId:         2,
+		Type:                  models.TypeRollback,
+		Currency:              "EUR",
+		Amount:                1010000,
+		Token:                 "token",
+		OriginalTransactionId: 1,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(1000000), response.Balance)
+
+	bonus, err := service.BonusRepository.FindById(1)
+	assert.Nil(t, err)
+	assert.Equal(t, models.BonusExpired, bonus.Status)
+
+	report, err := service.Reconcile(models.ReconcileRequestModel{UserIds: []uint64{1}})
+	assert.Nil(t, err)
+	assert.Empty(t, report.Discrepancies)
+}
+
+func TestUserService_DepositLimit(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	service.Clock = func() time.Time { return now }
+
+	setLimit := func(amount models.Money) *models.LimitModel {
+		limit, err := service.SetLimit(models.SetLimitRequestModel{
+			UserId:   1,
+			Token:    "token",
+			Currency: "EUR",
+			Type:     models.LimitDeposit,
+			Period:   models.LimitDay,
+			Amount:   amount,
+		})
+		assert.Nil(t, err)
+		return limit
+	}
+	deposit := func(id uint64, amount models.Money) error {
+		_, err := service.AddDeposit(models.DepositRequestModel{UserId: 1, DepositId: id, Currency: "EUR", Amount: amount, Token: "token"})
+		return err
+	}
+
+	setLimit(10000)
+	assert.Nil(t, deposit(1, 6000))
+	assert.Equal(t, "deposit limit exceeded", deposit(2, 5000).Error())
+
+	// Raising the limit waits for the cooling-off delay, lowering it applies at once.
+	limit := setLimit(20000)
+	assert.Equal(t, models.Money(10000), limit.Amount)
+	assert.Equal(t, now.Add(24*time.Hour), limit.PendingAt)
+	assert.Equal(t, "deposit limit exceeded", deposit(2, 5000).Error())
+
+	limit = setLimit(8000)
+	assert.Equal(t, models.Money(8000), limit.Amount)
+	assert.False(t, limit.IsPending())
+
+	setLimit(20000)
+	now = now.Add(24 * time.Hour)
+	// The first deposit left the rolling window when the raise became effective.
+	assert.Nil(t, deposit(2, 15000))
+	assert.Equal(t, "deposit limit exceeded", deposit(3, 5001).Error())
+
+	user, err := service.GetUser(models.GetUserRequestModel{Id: 1, Token: "token"})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.LimitModel{{
+		UserId:    1,
+		Currency:  "EUR",
+		Type:      models.LimitDeposit,
+		Period:    models.LimitDay,
+		Amount:    20000,
+		UpdatedAt: now,
+	}}, user.Limits)
+}
+
+func TestUserService_LossLimit(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	service.Clock = func() time.Time { return now }
+
+	transaction := func(id uint64, transactionType string, amount models.Money) error {
+		_, err := service.Transaction(models.TransactionRequestModel{
+			UserId:        1,
+			TransactionId: id,
+			Type:          transactionType,
+			Currency:      "EUR",
+			Amount:        amount,
+			Token:         "token",
+		})
+		return err
+	}
+
+	_, err := service.SetLimit(models.SetLimitRequestModel{
+		UserId:   1,
+		Token:    "token",
+		Currency: "EUR",
+		Type:     models.LimitLoss,
+		Period:   models.LimitWeek,
+		Amount:   10000,
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, transaction(1, models.TypeBet, 8000))
+	assert.Equal(t, "loss limit exceeded", transaction(2, models.TypeBet, 3000).Error())
+	// Wins reduce the net loss within the window.
+	assert.Nil(t, transaction(3, models.TypeWin, 1000))
+	assert.Nil(t, transaction(4, models.TypeBet, 3000))
+
+	// Removing the limit waits for the cooling-off delay.
+	limit, err := service.SetLimit(models.SetLimitRequestModel{
+		UserId:   1,
+		Token:    "token",
+		Currency: "EUR",
+		Type:     models.LimitLoss,
+		Period:   models.LimitWeek,
+		Remove:   true,
+	})
+	assert.Nil(t, err)
+	assert.True(t, limit.PendingRemoval)
+	assert.Equal(t, "loss limit exceeded", transaction(5, models.TypeBet, 1).Error())
+
+	now = now.Add(24 * time.Hour)
+	assert.Nil(t, transaction(5, models.TypeBet, 1))
+
+	limits, err := service.LimitRepository.FindAll()
+	assert.Nil(t, err)
+	assert.Empty(t, limits)
+}
+
+func TestUserService_SelfExclusion(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository(), withUsers(models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 10000}, Token: "token"}), withClock(func() time.Time { return now }))
+
+	transaction := func(id uint64, transactionType string) error {
+		_, err := service.Transaction(models.TransactionRequestModel{
+			UserId:        1,
+			TransactionId: id,
+			Type:          transactionType,
+			Currency:      "EUR",
+			Amount:        100,
+			Token:         "token",
+		})
+		return err
+	}
+
+	assert.Nil(t, transaction(1, models.TypeBet))
+	_, err := service.SetStatus(models.SetStatusRequestModel{
+		UserId:        1,
+		Token:         "token",
+		Status:        models.StatusSelfExcluded,
+		Reason:        "taking a break",
+		ExcludedUntil: now.Add(24 * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	// The status survives a restart before the next flush.
+	service = restartTestService(t, service)
+	assert.Equal(t, "account self-excluded", transaction(2, models.TypeBet).Error())
+	assert.Nil(t, transaction(3, models.TypeWin))
+	_, err = service.AddDeposit(models.DepositRequestModel{UserId: 1, DepositId: 1, Currency: "EUR", Amount: 100, Token: "token"})
+	assert.Equal(t, "account self-excluded", err.Error())
+
+	// The operator cannot lift a self-exclusion, and the player cannot shorten it.
+	_, err = service.ChangeStatus(models.ChangeStatusRequestModel{UserId: 1, Status: models.StatusActive, Reason: "request"})
+	assert.Equal(t, "invalid status transition", err.Error())
+	_, err = service.SetStatus(models.SetStatusRequestModel{
+		UserId:        1,
+		Token:         "token",
+		Status:        models.StatusSelfExcluded,
+		Reason:        "shorter break",
+		ExcludedUntil: now.Add(time.Hour),
+	})
+	assert.Equal(t, "invalid exclusion end", err.Error())
+
+	now = now.Add(24 * time.Hour)
+	assert.Nil(t, transaction(2, models.TypeBet))
+
+	_, err = service.SetStatus(models.SetStatusRequestModel{UserId: 1, Token: "token", Status: models.StatusClosed, Reason: "done"})
+	assert.Nil(t, err)
+	_, err = service.GetUser(models.GetUserRequestModel{Id: 1, Token: "token"})
+	assert.Equal(t, "account closed", err.Error())
+	assert.Equal(t, "account closed", transaction(4, models.TypeWin).Error())
+}
+
+func TestUserService_RotateToken(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository(), withUsers(models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 10000}, Token: "token"}), withClock(func() time.Time { return now }))
+
+	// Plaintext tokens are replaced by their hash on load.
+	stored := make(map[uint64]*models.UserModel)
+	assert.Nil(t, service.UserRepository.FindAll(stored))
+	assert.Equal(t, "", stored[1].Token)
+	assert.True(t, models.MatchTokenHash(stored[1].TokenHash, "token"))
+
+	getUser := func(token string) error {
+		_, err := service.GetUser(models.GetUserRequestModel{Id: 1, Token: token})
+		return err
+	}
+
+	rotated, err := service.RotateToken(models.RotateTokenRequestModel{UserId: 1, Token: "token", GracePeriod: 3600})
+	assert.Nil(t, err)
+	assert.NotEqual(t, "token", rotated.Token)
+
+	// The rotation survives a restart before the next flush.
+	service = restartTestService(t, service)
+	assert.Nil(t, getUser("token"))
+	assert.Nil(t, getUser(rotated.Token))
+	_, err = service.RotateToken(models.RotateTokenRequestModel{UserId: 1, Token: "token"})
+	assert.Equal(t, "wrong token", err.Error())
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, "wrong token", getUser("token").Error())
+
+	assert.Nil(t, service.Flush())
+	service = restartTestService(t, service)
+	assert.Equal(t, "wrong token", getUser("token").Error())
+	assert.Nil(t, getUser(rotated.Token))
+
+	// Without a grace period the replaced token stops working at once.
+	rotatedAgain, err := service.RotateToken(models.RotateTokenRequestModel{UserId: 1, Token: rotated.Token})
+	assert.Nil(t, err)
+	assert.Nil(t, rotatedAgain.PreviousTokenExpiresAt)
+	assert.Equal(t, "wrong token", getUser(rotated.Token).Error())
+	assert.Nil(t, getUser(rotatedAgain.Token))
+}
+
+func TestUserService_StrictRounds(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	service.Clock = func() time.Time { return now }
+	service.StrictRounds = true
+
+	transaction := func(request models.TransactionRequestModel) error {
+		request.Currency = "EUR"
+		request.Amount = 1000
+		request.Token = "token"
+		request.Provider = "acme"
+		_, err := service.Transaction(request)
+		return err
+	}
+
+	assert.Nil(t, transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 1, Type: models.TypeBet, RoundId: "r1", GameId: "slots"}))
+	assert.Equal(t, "round not found", transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 2, Type: models.TypeWin, RoundId: "r2"}).Error())
+	assert.Equal(t, "round mismatch", transaction(models.TransactionRequestModel{UserId: 2, TransactionId: 2, Type: models.TypeBet, RoundId: "r1"}).Error())
+	assert.Nil(t, transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 2, Type: models.TypeWin, RoundId: "r1", CloseRound: true}))
+	assert.Equal(t, "round closed", transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 3, Type: models.TypeWin, RoundId: "r1"}).Error())
+	assert.Equal(t, "round closed", transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 3, Type: models.TypeBet, RoundId: "r1"}).Error())
+	// A rollback settles against the round of the reversed transaction, closed or not.
+	assert.Nil(t, transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 3, Type: models.TypeRollback, OriginalTransactionId: 2}))
+
+	rollback, err := service.TransactionRepository.FindById(3)
+	assert.Nil(t, err)
+	assert.Equal(t, "r1", rollback.RoundId)
+	assert.Equal(t, "slots", rollback.GameId)
+
+	assert.Nil(t, transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 4, Type: models.TypeBet, RoundId: "r3", GameId: "poker"}))
+	round, err := service.CloseRound(models.CloseRoundRequestModel{UserId: 1, Token: "token", Provider: "acme", RoundId: "r3"})
+	assert.Nil(t, err)
+	assert.Equal(t, models.RoundClosed, round.Status)
+
+	statistics, err := service.Statistics(models.StatisticRequestModel{UserId: 1, Token: "token", From: now.Add(-time.Hour), Granularity: models.GranularityDay})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.GameStatisticModel{
+		{Provider: "acme", GameId: "poker", Currency: "EUR", BetCount: 1, BetSum: 1000},
+		{Provider: "acme", GameId: "slots", Currency: "EUR", BetCount: 1, BetSum: 1000},
+	}, statistics.Games)
+	assert.Equal(t, []models.GameStatisticModel{
+		{Provider: "acme", Currency: "EUR", BetCount: 2, BetSum: 2000},
+	}, statistics.Providers)
+}
+
+func TestUserService_AdjustBalance(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository(), withUsers(models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 0}, Token: "token"}), withClock(func() time.Time { return now }))
+
+	_, err := service.AddDeposit(models.DepositRequestModel{UserId: 1, DepositId: 1, Currency: "EUR", Amount: 10000, Token: "token"})
+	assert.Nil(t, err)
+
+	adjustment, err := service.AdjustBalance(models.AdjustBalanceRequestModel{UserId: 1, AdjustmentId: 1, Currency: "EUR", Amount: -2500, Reason: "chargeback"}, "finance")
+	assert.Nil(t, err)
+	assert.Equal(t, models.AdjustmentModel{
+		Id: 1, UserId: 1, Currency: "EUR", Amount: -2500, Reason: "chargeback", Actor: "finance",
+		BalanceBefore: 10000, BalanceAfter: 7500, CreatedAt: now, Sequence: 2,
+	}, *adjustment)
+
+	_, err = service.AdjustBalance(models.AdjustBalanceRequestModel{UserId: 1, AdjustmentId: 2, Currency: "EUR", Amount: -7501, Reason: "chargeback"}, "finance")
+	assert.Equal(t, "not enough balance", err.Error())
+	_, err = service.AdjustBalance(models.AdjustBalanceRequestModel{UserId: 1, AdjustmentId: 2, Currency: "JPY", Amount: 100, Reason: "goodwill"}, "finance")
+	assert.Equal(t, "wallet not found", err.Error())
+
+	// The adjustment survives a restart before the next flush and balances the ledger.
+	service = restartTestService(t, service)
+	user, err := service.AdminGetUser(models.AdminUserRequestModel{UserId: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(7500), user.Wallets[0].Balance)
+
+	report, err := service.Reconcile(models.ReconcileRequestModel{UserIds: []uint64{1}})
+	assert.Nil(t, err)
+	assert.Empty(t, report.Discrepancies)
+}
+
+func TestUserService_AdminHistory(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository(), withUsers(models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 0}, Token: "token"}), withClock(func() time.Time { return now }))
+
+	_, err := service.AddDeposit(models.DepositRequestModel{UserId: 1, DepositId: 1, Currency: "EUR", Amount: 10000, Token: "token"})
+	assert.Nil(t, err)
+	_, err = service.Transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 1, Type: models.TypeBet, Currency: "EUR", Amount: 1000, Token: "token"})
+	assert.Nil(t, err)
+	_, err = service.Withdraw(models.WithdrawalRequestModel{UserId: 1, WithdrawalId: 1, Currency: "EUR", Amount: 2000, Token: "token"})
+	assert.Nil(t, err)
+	_, err = service.AdjustBalance(models.AdjustBalanceRequestModel{UserId: 1, AdjustmentId: 1, Currency: "EUR", Amount: -500, Reason: "chargeback"}, "finance")
+	assert.Nil(t, err)
+	_, err = service.SettleWithdrawal(models.SettleWithdrawalRequestModel{WithdrawalId: 1, Status: models.WithdrawalRejected})
+	assert.Nil(t, err)
+
+	// Every balance change is listed by ledger sequence, the entries were all made at the same instant.
+	entry := func(sequence uint64, entryType string, amount models.Money, balanceBefore models.Money, balanceAfter models.Money) models.HistoryEntryModel {
+		return models.HistoryEntryModel{Id: 1, Type: entryType, Currency: "EUR", Amount: amount, BalanceBefore: balanceBefore, BalanceAfter: balanceAfter, CreatedAt: now, Sequence: sequence}
+	}
+	var entries []models.HistoryEntryModel
+	request := models.AdminHistoryRequestModel{UserId: 1, Limit: 2}
+	for page := 0; page < 3; page++ {
+		history, err := service.AdminHistory(request)
+		if !assert.Nil(t, err) {
+			return
+		}
+		entries = append(entries, history.Entries...)
+		assert.Equal(t, page < 2, history.NextCursor != "")
+		request.Cursor = history.NextCursor
+	}
+	assert.Equal(t, []models.HistoryEntryModel{
+		entry(5, models.TypeWithdrawalRelease, 2000, 6500, 8500),
+		entry(4, models.TypeAdjustment, -500, 7000, 6500),
+		entry(3, models.TypeWithdrawal, 2000, 9000, 7000),
+		entry(2, models.TypeBet, 1000, 10000, 9000),
+		entry(1, models.TypeDeposit, 10000, 0, 10000),
+	}, entries)
+
+	history, err := service.AdminHistory(models.AdminHistoryRequestModel{UserId: 1, Types: []string{models.TypeWithdrawal, models.TypeWithdrawalRelease}})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.HistoryEntryModel{entries[0], entries[2]}, history.Entries)
+}
+
+func TestUserService_ProviderCall(t *testing.T) {
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	call := func(provider string, action string, transactionId string, originalId string, amount models.Money) (models.Money, error) {
+		response, err := service.ProviderCall(provider, models.ProviderRequestModel{
+			Action:                action,
+			UserId:                1,
+			Token:                 "token",
+			Currency:              "EUR",
+			TransactionId:         transactionId,
+			OriginalTransactionId: originalId,
+			Amount:                amount,
+		})
+		if err != nil {
+			return 0, err
+		}
+		return response.Balance, nil
+	}
+
+	// Each provider books its own transaction under the same id, and retries replay it.
+	balance, err := call("alpha", models.ProviderDebit, "1", "", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(999000), balance)
+	balance, err = call("beta", models.ProviderDebit, "1", "", 2000)
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(997000), balance)
+	balance, err = call("alpha", models.ProviderDebit, "1", "", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(999000), balance)
+
+	// A rollback reverses the transaction of its own provider.
+	balance, err = call("beta", models.ProviderRollback, "2", "1", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(999000), balance)
+
+	transaction, err := service.TransactionRepository.FindByExternalId("beta", "2")
+	if assert.Nil(t, err) {
+		original, err := service.TransactionRepository.FindByExternalId("beta", "1")
+		assert.Nil(t, err)
+		assert.Equal(t, original.Id, transaction.OriginalTransactionId)
+		assert.Equal(t, models.Money(2000), transaction.Amount)
+		assert.Equal(t, models.ProviderTransactionIdBase+3, transaction.Id)
+	}
+
+	// The ids of provider transactions are not open to clients.
+	_, err = service.Transaction(models.TransactionRequestModel{UserId: 1, TransactionId: models.ProviderTransactionIdBase + 4, Type: models.TypeBet, Currency: "EUR", Amount: 1000, Token: "token"})
+	assert.Equal(t, ErrTransactionIdReserved, err)
+
+	// When another instance took the next id, the call is booked past it.
+	taken := models.TransactionModel{Id: models.ProviderTransactionIdBase + 4, UserId: 2, Currency: "EUR", Amount: 1, Type: models.TypeBet, Provider: "gamma", ExternalId: "1"}
+	assert.Nil(t, service.TransactionRepository.Insert(taken))
+	balance, err = call("alpha", models.ProviderDebit, "3", "", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(998000), balance)
+	transaction, err = service.TransactionRepository.FindByExternalId("alpha", "3")
+	if assert.Nil(t, err) {
+		assert.Equal(t, models.ProviderTransactionIdBase+5, transaction.Id)
+	}
+
+	// The sequence continues after a restart.
+	service = restartTestService(t, service)
+	balance, err = call("alpha", models.ProviderDebit, "4", "", 1000)
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(997000), balance)
+	transaction, err = service.TransactionRepository.FindByExternalId("alpha", "4")
+	if assert.Nil(t, err) {
+		assert.Equal(t, models.ProviderTransactionIdBase+6, transaction.Id)
+	}
+}
+
+func TestUserService_BalanceOverflow(t *testing.T) {
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+
+	_, err := service.AddDeposit(models.DepositRequestModel{UserId: 1, DepositId: 1, Currency: "EUR", Amount: math.MaxInt64, Token: "token"})
+	assert.Equal(t, ErrInvalidAmount, err)
+	_, err = service.Transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 1, Type: models.TypeWin, Currency: "EUR", Amount: math.MaxInt64, Token: "token"})
+	assert.Equal(t, ErrInvalidAmount, err)
+	_, err = service.AdjustBalance(models.AdjustBalanceRequestModel{UserId: 1, AdjustmentId: 1, Currency: "EUR", Amount: math.MaxInt64, Reason: "correction"}, "admin")
+	assert.Equal(t, ErrInvalidAmount, err)
+
+	user, err := service.GetUser(models.GetUserRequestModel{Id: 1, Token: "token"})
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(1000000), user.Wallets[0].Balance)
+}
+
+func TestUserService_ReconcileSequence(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	service.Clock = func() time.Time { return now }
+
+	// Rows made at the same instant replay in the order they changed the balance.
+	_, err := service.Transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 1, Type: models.TypeBet, Currency: "EUR", Amount: 1000000, Token: "token"})
+	assert.Nil(t, err)
+	_, err = service.AddDeposit(models.DepositRequestModel{UserId: 1, DepositId: 1, Currency: "EUR", Amount: 5000, Token: "token"})
+	assert.Nil(t, err)
+	_, err = service.Transaction(models.TransactionRequestModel{UserId: 1, TransactionId: 2, Type: models.TypeBet, Currency: "EUR", Amount: 5000, Token: "token"})
+	assert.Nil(t, err)
+
+	report, err := service.Reconcile(models.ReconcileRequestModel{UserIds: []uint64{1}})
+	assert.Nil(t, err)
+	assert.Empty(t, report.Discrepancies)
+
+	// A lost row leaves a gap in the sequence; the wallet is not repaired.
+	kept, err := service.TransactionRepository.FindById(2)
+	assert.Nil(t, err)
+	service.TransactionRepository = repositories.NewMemoryTransactionRepository()
+	assert.Nil(t, service.TransactionRepository.Insert(*kept))
+	report, err = service.Reconcile(models.ReconcileRequestModel{UserIds: []uint64{1}, Repair: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.DiscrepancyModel{
+		{UserId: 1, Kind: models.DiscrepancySequenceGap, Currency: "EUR", Source: models.HistorySourceDeposit, EntryId: 1, Sequence: 2},
+		{UserId: 1, Kind: models.DiscrepancyChainBreak, Currency: "EUR", Source: models.HistorySourceDeposit, EntryId: 1, Expected: 1000000, Actual: 0},
+		{UserId: 1, Kind: models.DiscrepancyChainBreak, Currency: "EUR", Source: models.HistorySourceTransaction, EntryId: 2, Expected: 1005000, Actual: 5000},
+		{UserId: 1, Kind: models.DiscrepancyBalanceMismatch, Currency: "EUR", Expected: 1000000, Actual: 0},
+	}, report.Discrepancies)
+
+	_, err = service.Reconcile(models.ReconcileRequestModel{UserIds: []uint64{benchmarkUsers + 1}})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestUserService_ReconcileWithoutOpeningWallets(t *testing.T) {
+	// Users stored before opening balances were kept were created with balances of their own.
+	service := newTestService(t, repositories.NewMemoryDepositRepository(), withUsers(
+		models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 5000}, Token: "token"},
+		models.UserModel{Id: 2, Wallets: models.Wallets{"EUR": 7500}, Token: "token"},
+	))
+	_, err := service.AddDeposit(models.DepositRequestModel{UserId: 1, DepositId: 1, Currency: "EUR", Amount: 10000, Token: "token"})
+	assert.Nil(t, err)
+
+	report, err := service.Reconcile(models.ReconcileRequestModel{Repair: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.CheckedUsers)
+	assert.Empty(t, report.Discrepancies)
+
+	user, err := service.AdminGetUser(models.AdminUserRequestModel{UserId: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(7500), user.Wallets[0].Balance)
+}
+
+func TestUserService_ReconcileRepairLedger(t *testing.T) {
+	users := repositories.NewMemoryUserRepository(models.UserModel{Id: 1, Wallets: models.Wallets{"EUR": 1000000}, OpeningWallets: models.Wallets{"EUR": 1000000}, Token: "token"})
+	deposits := repositories.NewMemoryDepositRepository()
+	transactions := repositories.NewMemoryTransactionRepository()
+	withdrawals := repositories.NewMemoryWithdrawalRepository()
+	bonuses := repositories.NewMemoryBonusRepository()
+	adjustments := repositories.NewMemoryAdjustmentRepository()
+	service := &UserService{
+		UserRepository:        users,
+		DepositRepository:     deposits,
+		TransactionRepository: transactions,
+		WithdrawalRepository:  withdrawals,
+		BonusRepository:       bonuses,
+		LimitRepository:       repositories.NewMemoryLimitRepository(),
+		RoundRepository:       repositories.NewMemoryRoundRepository(),
+		AdjustmentRepository:  adjustments,
+		AuditRepository:       repositories.NewMemoryAuditRepository(),
+		Ledger:                repositories.NewMemoryLedgerRepository(users, deposits, transactions, withdrawals, bonuses, adjustments),
+	}
+	if err := service.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ledger holds a deposit the stored balance misses.
+	assert.Nil(t, deposits.Insert(models.DepositModel{Id: 1, UserId: 1, Currency: "EUR", Amount: 5000, BalanceBefore: 1000000, BalanceAfter: 1005000}))
+
+	report, err := service.Reconcile(models.ReconcileRequestModel{UserIds: []uint64{1}, Repair: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.DiscrepancyModel{
+		{UserId: 1, Kind: models.DiscrepancyBalanceMismatch, Currency: "EUR", Expected: 1005000, Actual: 1000000, Repaired: true},
+	}, report.Discrepancies)
+
+	// The repair is stored with the balance it moved, so it survives a restart.
+	stored := make(map[uint64]*models.UserModel)
+	assert.Nil(t, users.FindAll(stored))
+	assert.Equal(t, models.Wallets{"EUR": 1005000}, stored[1].Wallets)
+	repairs, err := adjustments.FindByUserId(1)
+	assert.Nil(t, err)
+	if assert.Len(t, repairs, 1) {
+		assert.True(t, repairs[0].Repair)
+		assert.Equal(t, uint64(1), repairs[0].Id)
+		assert.Equal(t, models.Money(5000), repairs[0].Amount)
+	}
+
+	// Repairs leave the adjustment ids to the operators.
+	adjustment, err := service.AdjustBalance(models.AdjustBalanceRequestModel{UserId: 1, AdjustmentId: 1, Currency: "EUR", Amount: 500, Reason: "goodwill"}, "finance")
+	assert.Nil(t, err)
+	assert.Equal(t, models.Money(1005500), adjustment.BalanceAfter)
+
+	report, err = service.Reconcile(models.ReconcileRequestModel{UserIds: []uint64{1}})
+	assert.Nil(t, err)
+	assert.Empty(t, report.Discrepancies)
+}
+
+func TestUserService_AuditChain(t *testing.T) {
+	now := time.Date(2020, 7, 12, 10, 0, 0, 0, time.UTC)
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	service.Clock = func() time.Time { return now }
+
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, service.Audit(models.AuditModel{Endpoint: "/user/deposit", Caller: models.CallerPlayer, UserId: uint64(i), Status: 200, Result: models.AuditSuccess}))
+		now = now.Add(time.Minute)
+	}
+
+	verification, err := service.VerifyAudit()
+	assert.Nil(t, err)
+	assert.Equal(t, models.AuditVerificationModel{Checked: 3, Valid: true}, *verification)
+
+	page, err := service.SearchAudit(models.SearchAuditRequestModel{From: now.Add(-time.Minute), Limit: 1})
+	assert.Nil(t, err)
+	if assert.Len(t, page.Entries, 1) {
+		assert.Equal(t, uint64(3), page.Entries[0].UserId)
+	}
+	assert.Zero(t, page.NextAfterSequence)
+
+	page, err = service.SearchAudit(models.SearchAuditRequestModel{Limit: 2})
+	assert.Nil(t, err)
+	assert.Len(t, page.Entries, 2)
+	assert.Equal(t, uint64(2), page.NextAfterSequence)
+
+	// A restarted service continues the chain and detects entries edited or removed behind its back.
+	entries, err := service.AuditRepository.Find(models.AuditFilterModel{})
+	assert.Nil(t, err)
+	restart := func(entries ...models.AuditModel) *UserService {
+		service.AuditRepository = repositories.NewMemoryAuditRepository(entries...)
+		assert.Nil(t, service.Load())
+		return service
+	}
+
+	service = restart(entries...)
+	assert.Nil(t, service.Audit(models.AuditModel{Endpoint: "/transaction", Caller: models.CallerPlayer, UserId: 1, Status: 200, Result: models.AuditSuccess}))
+	verification, err = service.VerifyAudit()
+	assert.Nil(t, err)
+	assert.Equal(t, models.AuditVerificationModel{Checked: 4, Valid: true}, *verification)
+
+	edited := append([]models.AuditModel(nil), entries...)
+	edited[1].Status = 400
+	verification, err = restart(edited...).VerifyAudit()
+	assert.Nil(t, err)
+	assert.Equal(t, models.AuditVerificationModel{Checked: 1, BrokenAt: 2}, *verification)
+
+	verification, err = restart(entries[0], entries[2]).VerifyAudit()
+	assert.Nil(t, err)
+	assert.Equal(t, models.AuditVerificationModel{Checked: 1, BrokenAt: 2}, *verification)
+
+	// Removing the newest entries is found against the tail the service appended last.
+	service = restart(entries...)
+	service.AuditRepository = repositories.NewMemoryAuditRepository(entries[:2]...)
+	verification, err = service.VerifyAudit()
+	assert.Nil(t, err)
+	assert.Equal(t, models.AuditVerificationModel{Checked: 2, BrokenAt: 3}, *verification)
+}
+
+func TestUserService_AuditDropped(t *testing.T) {
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	failing := &failingAuditRepository{MemoryAuditRepository: repositories.NewMemoryAuditRepository()}
+	service.AuditRepository = failing
+	entry := models.AuditModel{Endpoint: "/transaction", Caller: models.CallerPlayer, UserId: 1, Status: 200, Result: models.AuditSuccess}
+
+	// An entry is stored by the time Audit returns.
+	assert.Nil(t, service.Audit(entry))
+	found, err := service.SearchAudit(models.SearchAuditRequestModel{UserId: 1})
+	assert.Nil(t, err)
+	assert.Len(t, found.Entries, 1)
+
+	// A failed insert is reported and counted, and the chain continues from the last entry stored.
+	failing.failing = true
+	assert.NotNil(t, service.Audit(entry))
+	failing.failing = false
+	assert.Nil(t, service.Audit(entry))
+
+	verification, err := service.VerifyAudit()
+	assert.Nil(t, err)
+	assert.Equal(t, models.AuditVerificationModel{Checked: 2, Valid: true, Dropped: 1}, *verification)
+}
+
+func TestUserService_BatchTransaction(t *testing.T) {
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	transaction := func(userId uint64, id uint64, transactionType string, amount models.Money) models.TransactionRequestModel {
+		return models.TransactionRequestModel{UserId: userId, TransactionId: id, Type: transactionType, Currency: "EUR", Amount: amount, Token: "token"}
+	}
+	balance := func(userId uint64) models.Money {
+		user, err := service.GetUser(models.GetUserRequestModel{Id: userId, Token: "token"})
+		assert.Nil(t, err)
+		return user.Wallets[0].Balance
+	}
+	codes := func(response *models.BatchTransactionResponseModel) []string {
+		var codes []string
+		for _, result := range response.Results {
+			codes = append(codes, result.Code)
+		}
+		return codes
+	}
+
+	// One failure rejects the whole batch and leaves every wallet as it was.
+	response, err := service.BatchTransaction(models.BatchTransactionRequestModel{
+		Mode: models.BatchAllOrNothing,
+		Transactions: []models.TransactionRequestModel{
+			transaction(1, 1, models.TypeBet, 1000),
+			transaction(2, 2, models.TypeWin, 2000),
+			transaction(1, 3, models.TypeBet, 2000000),
+			transaction(2, 4, models.TypeBet, 100),
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, response.Applied)
+	assert.Equal(t, []string{ErrBatchAborted.Code, ErrBatchAborted.Code, ErrNotEnoughBalance.Code, ErrBatchAborted.Code}, codes(response))
+	assert.Equal(t, models.Money(1000000), balance(1))
+	assert.Equal(t, models.Money(1000000), balance(2))
+	_, err = service.TransactionRepository.FindById(1)
+	assert.Equal(t, repositories.ErrNotFound, err)
+
+	// Best effort applies what it can, rollbacks and replays see the transactions before them in the batch.
+	rollback := transaction(1, 4, models.TypeRollback, 1000)
+	rollback.OriginalTransactionId = 1
+	unknown := transaction(99, 6, models.TypeWin, 100)
+	wrongToken := transaction(2, 7, models.TypeWin, 100)
+	wrongToken.Token = "wrong"
+	response, err = service.BatchTransaction(models.BatchTransactionRequestModel{
+		Mode: models.BatchBestEffort,
+		Transactions: []models.TransactionRequestModel{
+			transaction(1, 1, models.TypeBet, 1000),
+			transaction(2, 2, models.TypeWin, 2000),
+			transaction(1, 3, models.TypeBet, 2000000),
+			rollback,
+			transaction(1, 1, models.TypeBet, 1000),
+			unknown,
+			wrongToken,
+			rollback,
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, response.Applied)
+	assert.Equal(t, []string{"", "", ErrNotEnoughBalance.Code, "", "", ErrNotFound.Code, ErrWrongToken.Code, ""}, codes(response))
+	assert.Equal(t, models.Money(999000), response.Results[0].Balance)
+	assert.Equal(t, models.Money(1002000), response.Results[1].Balance)
+	assert.Equal(t, models.Money(1000000), response.Results[3].Balance)
+	// Replays answer with the balance after the original transaction.
+	assert.Equal(t, models.Money(999000), response.Results[4].Balance)
+	assert.Equal(t, models.Money(1000000), response.Results[7].Balance)
+	assert.Equal(t, models.Money(1000000), balance(1))
+	assert.Equal(t, models.Money(1002000), balance(2))
+
+	user, err := service.GetUser(models.GetUserRequestModel{Id: 1, Token: "token"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, user.Wallets[0].BetCount)
+	_, err = service.TransactionRepository.FindRollback(1)
+	assert.Nil(t, err)
+
+	report, err := service.Reconcile(models.ReconcileRequestModel{UserIds: []uint64{1, 2}})
+	assert.Nil(t, err)
+	assert.Empty(t, report.Discrepancies)
+}
+
+func TestUserService_BatchTransactionLimits(t *testing.T) {
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	_, err := service.SetLimit(models.SetLimitRequestModel{UserId: 3, Token: "token", Currency: "EUR", Type: models.LimitLoss, Period: models.LimitDay, Amount: 1500})
+	assert.Nil(t, err)
+
+	// The losses of the batch count towards the limit before they are stored.
+	response, err := service.BatchTransaction(models.BatchTransactionRequestModel{
+		Mode: models.BatchBestEffort,
+		Transactions: []models.TransactionRequestModel{
+			{UserId: 3, TransactionId: 1, Type: models.TypeBet, Currency: "EUR", Amount: 1000, Token: "token"},
+			{UserId: 3, TransactionId: 2, Type: models.TypeBet, Currency: "EUR", Amount: 1000, Token: "token"},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, response.Applied)
+	assert.Equal(t, ErrLossLimitExceeded.Code, response.Results[1].Code)
+}
+
+func TestUserService_BatchTransactionStorageFailure(t *testing.T) {
+	transactions := []models.TransactionRequestModel{
+		{UserId: 1, TransactionId: 1, Type: models.TypeBet, Currency: "EUR", Amount: 1000, Token: "token"},
+		{UserId: 1, TransactionId: 2, Type: models.TypeBet, Currency: "EUR", Amount: 1000, Token: "token"},
+		{UserId: 1, TransactionId: 3, Type: models.TypeBet, Currency: "EUR", Amount: 1000, Token: "token"},
+		{UserId: 2, TransactionId: 4, Type: models.TypeWin, Currency: "EUR", Amount: 1000, Token: "token"},
+	}
+	tests := []struct {
+		name     string
+		mode     string
+		failing  map[uint64]bool
+		lost     bool
+		codes    []string
+		balances map[uint64]models.Money
+		stored   []uint64
+	}{
+		{
+			// The later transaction of the wallet depends on the failed one; the other user's does not.
+			name:     "best effort",
+			mode:     models.BatchBestEffort,
+			failing:  map[uint64]bool{2: true},
+			codes:    []string{"", ErrConflict.Code, ErrBatchAborted.Code, ""},
+			balances: map[uint64]models.Money{1: 999000, 2: 1001000},
+			stored:   []uint64{1, 4},
+		},
+		{
+			// Only a writer outside the service makes the store fail; what it took before stays in the ledger.
+			name:     "all or nothing",
+			mode:     models.BatchAllOrNothing,
+			failing:  map[uint64]bool{2: true},
+			codes:    []string{"", ErrConflict.Code, ErrBatchAborted.Code, ErrBatchAborted.Code},
+			balances: map[uint64]models.Money{1: 999000, 2: 1000000},
+			stored:   []uint64{1},
+		},
+		{
+			// The wallets are rebuilt from the transactions the store took.
+			name:     "unknown outcome",
+			mode:     models.BatchBestEffort,
+			lost:     true,
+			codes:    []string{ErrInternal.Code, ErrInternal.Code, ErrInternal.Code, ErrInternal.Code},
+			balances: map[uint64]models.Money{1: 997000, 2: 1001000},
+			stored:   []uint64{1, 2, 3, 4},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := newTestService(t, repositories.NewMemoryDepositRepository(), withJournal(repositories.NewMemoryJournalRepository()))
+			repository := &failingTransactionRepository{
+				MemoryTransactionRepository: repositories.NewMemoryTransactionRepository(),
+				failing:                     test.failing,
+				lost:                        test.lost,
+			}
+			service.TransactionRepository = repository
+
+			response, err := service.BatchTransaction(models.BatchTransactionRequestModel{Mode: test.mode, Transactions: transactions})
+			assert.Nil(t, err)
+			for i, code := range test.codes {
+				assert.Equal(t, code, response.Results[i].Code, "transaction %d", i+1)
+			}
+
+			// The journal, written ahead of the store, is compensated for every undone transaction.
+			restarted := restartTestService(t, service)
+			for userId, balance := range test.balances {
+				user, err := service.GetUser(models.GetUserRequestModel{Id: userId, Token: "token"})
+				assert.Nil(t, err)
+				assert.Equal(t, balance, user.Wallets[0].Balance, "user %d", userId)
+				user, err = restarted.GetUser(models.GetUserRequestModel{Id: userId, Token: "token"})
+				assert.Nil(t, err)
+				assert.Equal(t, balance, user.Wallets[0].Balance, "user %d after restart", userId)
+			}
+
+			stored := map[uint64]bool{}
+			for _, id := range test.stored {
+				stored[id] = true
+			}
+			for _, transaction := range transactions {
+				_, err := repository.FindById(transaction.TransactionId)
+				if stored[transaction.TransactionId] {
+					assert.Nil(t, err, "transaction %d", transaction.TransactionId)
+				} else {
+					assert.Equal(t, repositories.ErrNotFound, err, "transaction %d", transaction.TransactionId)
+				}
+			}
+		})
+	}
+}
+
+func TestUserService_BatchTransactionWriteFailure(t *testing.T) {
+	service := newTestService(t, repositories.NewMemoryDepositRepository())
+	service.RoundRepository = &failingRoundRepository{MemoryRoundRepository: repositories.NewMemoryRoundRepository()}
+
+	// The round is lost, but the stored transaction took effect and is reported as applied.
+	response, err := service.BatchTransaction(models.BatchTransactionRequestModel{
+		Mode: models.BatchAllOrNothing,
+		Transactions: []models.TransactionRequestModel{
+			{UserId: 1, TransactionId: 1, Type: models.TypeBet, Currency: "EUR", Amount: 1000, Token: "token", RoundId: "r1", GameId: "g1"},
+			{UserId: 2, TransactionId: 2, Type: models.TypeWin, Currency: "EUR", Amount: 1000, Token: "token"},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, response.Applied)
+	assert.Equal(t, models.BatchTransactionResultModel{TransactionId: 1, Currency: "EUR", Balance: 999000}, response.Results[0])
+	_, err = service.TransactionRepository.FindById(1)
+	assert.Nil(t, err)
+}
+
+func BenchmarkUserService_Transaction(b *testing.B) {
+	service := newTestService(b, repositories.NewMemoryDepositRepository())
+	var users, transactions uint64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		userId := atomic.AddUint64(&users, 1)%benchmarkUsers + 1
+		for pb.Next() {
+			_, err := service.Transaction(models.TransactionRequestModel{
+				UserId:        userId,
+				TransactionId: atomic.AddUint64(&transactions, 1),
+				Type:          models.TypeWin,
+				Currency:      "EUR",
+				Amount:        100,
+				Token:         "token",
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkUserService_AddDepositSlowStorage measures throughput when every ledger insert takes a millisecond;
+// operations on different users overlap their inserts instead of queueing behind each other.
+func BenchmarkUserService_AddDepositSlowStorage(b *testing.B) {
+	service := newTestService(b, &slowDepositRepository{
+		MemoryDepositRepository: repositories.NewMemoryDepositRepository(),
+		latency:                 time.Millisecond,
+	})
+	var users, deposits uint64
+
+	b.SetParallelism(benchmarkUsers)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		userId := atomic.AddUint64(&users, 1)%benchmarkUsers + 1
+		for pb.Next() {
+			_, err := service.AddDeposit(models.DepositRequestModel{
+				UserId:    userId,
+				DepositId: atomic.AddUint64(&deposits, 1),
+				Currency:  "EUR",
+				Amount:    100,
+				Token:     "token",
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}